@@ -1,14 +1,17 @@
 package api
 
 import (
-	"encoding/gob"
+	"crypto/subtle"
 	"encoding/hex"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-kit/log"
 	"github.com/k0yote/privatechain/core"
+	"github.com/k0yote/privatechain/crypto"
 	"github.com/k0yote/privatechain/types"
 	"github.com/labstack/echo/v4"
 )
@@ -25,7 +28,10 @@ type APIError struct {
 type Block struct {
 	Hash          string
 	Version       uint32
+	ChainID       uint64
 	DataHash      string
+	StateRoot     string
+	ReceiptsRoot  string
 	PrevBlockHash string
 	Height        uint32
 	Timestamp     time.Time
@@ -35,9 +41,132 @@ type Block struct {
 	TxResponse TxResponse
 }
 
+type ProofNode struct {
+	Hash string
+	Left bool
+}
+
+type TxProof struct {
+	TxHash      string
+	BlockHash   string
+	BlockHeight uint32
+	DataHash    string
+	Index       int
+	Branch      []ProofNode
+}
+
+type Event struct {
+	Address string
+	Topics  []string
+	Data    string
+}
+
+type Receipt struct {
+	TxHash  string
+	Status  string
+	GasUsed uint64
+	Events  []Event
+	Error   string `json:",omitempty"`
+}
+
+type TraceStep struct {
+	Depth   int
+	Address string
+	IP      int
+	Instr   string
+	Gas     uint64
+	Stack   []string
+}
+
+type StorageAccess struct {
+	Depth   int
+	Address string
+	Key     string
+	Value   string
+	Write   bool
+}
+
+type Trace struct {
+	TxHash    string
+	Receipt   Receipt
+	Truncated bool
+	Steps     []TraceStep
+	Storage   []StorageAccess
+}
+
+// AccountNonce holds the nonce of the account as of the last block and the
+// nonce the next transaction of the account has to use, which accounts for
+// its transactions still waiting in the pool.
+type AccountNonce struct {
+	Address      string
+	Nonce        uint64
+	PendingNonce uint64
+}
+
+type ContractCode struct {
+	Address string
+	Code    string
+}
+
+type ContractStorageValue struct {
+	Address string
+	Key     string
+	Value   string
+}
+
+// PeerInfo describes a peer of the node. The peer fields are only set for
+// connected peers, Attempts and NextDial only for addresses being redialed.
+type PeerInfo struct {
+	Addr            string
+	State           string
+	Outgoing        bool
+	ID              string     `json:",omitempty"`
+	NodeKey         string     `json:",omitempty"`
+	ProtocolVersion uint32     `json:",omitempty"`
+	Capabilities    []string   `json:",omitempty"`
+	Height          uint32     `json:",omitempty"`
+	Score           int        `json:",omitempty"`
+	ConnectedAt     *time.Time `json:",omitempty"`
+	Attempts        int        `json:",omitempty"`
+	NextDial        *time.Time `json:",omitempty"`
+}
+
+// BanInfo is a banned node. Until is not set for permanent bans.
+type BanInfo struct {
+	NodeKey string
+	Until   *time.Time `json:",omitempty"`
+	Reason  string
+}
+
+// Network gives the API access to the peers of the node.
+type Network interface {
+	Peers() []PeerInfo
+	Bans() []BanInfo
+	// Ban bans a node for the given duration, forever when it is zero.
+	Ban(nodeKey crypto.PublicKey, duration time.Duration, reason string) error
+	Unban(nodeKey crypto.PublicKey) (bool, error)
+}
+
+// Mempool gives the API access to the transactions waiting for a block.
+type Mempool interface {
+	PendingNonce(types.Address) uint64
+}
+
 type ServerConfig struct {
 	Logger     log.Logger
 	ListenAddr string
+	// Network is optional, the peer routes are only served when set.
+	Network Network
+	// Mempool is optional, without it the pending nonce of an account is
+	// its confirmed nonce.
+	Mempool Mempool
+	// AdminToken protects the routes that change the node, like banning
+	// peers. Requests have to carry it as "Authorization: Bearer <token>".
+	// The admin routes are not served when it is empty.
+	AdminToken string
+	// Debug serves the debug routes, which re-execute transactions and
+	// are expensive. The chain needs tracing enabled for them.
+	Debug bool
 }
 
 type Server struct {
@@ -59,14 +188,30 @@ func (s *Server) Start() error {
 
 	e.GET("/block/:hashorid", s.handleGetBlock)
 	e.GET("/tx/:hash", s.handleGetTx)
+	e.GET("/tx/:hash/proof", s.handleGetTxProof)
+	e.GET("/tx/:hash/receipt", s.handleGetTxReceipt)
 	e.POST("/tx", s.handlePostTx)
+	e.GET("/account/:address/nonce", s.handleGetNonce)
+	e.GET("/contract/:address", s.handleGetContractCode)
+	e.GET("/contract/:address/storage/:key", s.handleGetContractStorage)
+	if s.Debug {
+		e.GET("/debug/tx/:hash/trace", s.handleTraceTx)
+	}
+	if s.Network != nil {
+		e.GET("/peers", s.handleGetPeers)
+		e.GET("/bans", s.handleGetBans)
+		if len(s.AdminToken) > 0 {
+			e.POST("/bans/:nodekey", s.handleBan, s.requireAdmin)
+			e.DELETE("/bans/:nodekey", s.handleUnban, s.requireAdmin)
+		}
+	}
 
 	return e.Start(s.ListenAddr)
 }
 
 func (s *Server) handlePostTx(c echo.Context) error {
 	tx := &core.Transaction{}
-	if err := gob.NewDecoder(c.Request().Body).Decode(tx); err != nil {
+	if err := tx.Decode(core.NewBinaryTxDecoder(c.Request().Body)); err != nil {
 		return c.JSON(http.StatusBadRequest, APIError{Error: err.Error()})
 	}
 
@@ -94,6 +239,257 @@ func (s *Server) handleGetTx(c echo.Context) error {
 	return c.JSON(http.StatusOK, tx)
 }
 
+func (s *Server) handleGetTxProof(c echo.Context) error {
+	hash := c.Param("hash")
+
+	h, err := hex.DecodeString(hash)
+	if err != nil || len(h) != 32 {
+		return c.JSON(http.StatusBadRequest, APIError{Error: "invalid transaction hash"})
+	}
+
+	proof, err := s.bc.GetTxProof(types.HashFromBytes(h))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, APIError{Error: err.Error()})
+	}
+
+	branch := make([]ProofNode, len(proof.Proof.Siblings))
+	for i, sibling := range proof.Proof.Siblings {
+		branch[i] = ProofNode{
+			Hash: sibling.Hash.String(),
+			Left: sibling.Left,
+		}
+	}
+
+	return c.JSON(http.StatusOK, TxProof{
+		TxHash:      proof.TxHash.String(),
+		BlockHash:   proof.BlockHash.String(),
+		BlockHeight: proof.BlockHeight,
+		DataHash:    proof.DataHash.String(),
+		Index:       proof.Proof.Index,
+		Branch:      branch,
+	})
+}
+
+func (s *Server) handleGetTxReceipt(c echo.Context) error {
+	h, err := hex.DecodeString(c.Param("hash"))
+	if err != nil || len(h) != 32 {
+		return c.JSON(http.StatusBadRequest, APIError{Error: "invalid transaction hash"})
+	}
+
+	receipt, err := s.bc.GetReceipt(types.HashFromBytes(h))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, APIError{Error: err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, convertReceipt(receipt))
+}
+
+// handleTraceTx re-executes a transaction of the chain and returns every
+// instruction and storage access of its code.
+func (s *Server) handleTraceTx(c echo.Context) error {
+	h, err := hex.DecodeString(c.Param("hash"))
+	if err != nil || len(h) != 32 {
+		return c.JSON(http.StatusBadRequest, APIError{Error: "invalid transaction hash"})
+	}
+
+	trace, err := s.bc.TraceTransaction(types.HashFromBytes(h))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, APIError{Error: err.Error()})
+	}
+
+	steps := make([]TraceStep, len(trace.Steps))
+	for i, step := range trace.Steps {
+		stack := make([]string, len(step.Stack))
+		for j, v := range step.Stack {
+			stack[j] = fmt.Sprint(v)
+		}
+
+		steps[i] = TraceStep{
+			Depth:   step.Depth,
+			Address: step.Address.String(),
+			IP:      step.IP,
+			Instr:   step.Instr.String(),
+			Gas:     step.Gas,
+			Stack:   stack,
+		}
+	}
+
+	storage := make([]StorageAccess, len(trace.Storage))
+	for i, access := range trace.Storage {
+		storage[i] = StorageAccess{
+			Depth:   access.Depth,
+			Address: access.Address.String(),
+			Key:     hex.EncodeToString(access.Key),
+			Value:   hex.EncodeToString(access.Value),
+			Write:   access.Write,
+		}
+	}
+
+	return c.JSON(http.StatusOK, Trace{
+		TxHash:    trace.TxHash.String(),
+		Receipt:   convertReceipt(trace.Receipt),
+		Truncated: trace.Truncated,
+		Steps:     steps,
+		Storage:   storage,
+	})
+}
+
+func convertReceipt(receipt *core.Receipt) Receipt {
+	events := make([]Event, len(receipt.Events))
+	for i, e := range receipt.Events {
+		topics := make([]string, len(e.Topics))
+		for j, topic := range e.Topics {
+			topics[j] = hex.EncodeToString(topic)
+		}
+
+		events[i] = Event{
+			Address: e.Address.String(),
+			Topics:  topics,
+			Data:    hex.EncodeToString(e.Data),
+		}
+	}
+
+	return Receipt{
+		TxHash:  receipt.TxHash.String(),
+		Status:  receipt.Status.String(),
+		GasUsed: receipt.GasUsed,
+		Events:  events,
+		Error:   receipt.Error,
+	}
+}
+
+func (s *Server) handleGetNonce(c echo.Context) error {
+	b, err := hex.DecodeString(c.Param("address"))
+	if err != nil || len(b) != 20 {
+		return c.JSON(http.StatusBadRequest, APIError{Error: "invalid account address"})
+	}
+
+	address := types.AddressFromBytes(b)
+
+	nonce := AccountNonce{
+		Address: address.String(),
+		Nonce:   s.bc.GetNonce(address),
+	}
+	nonce.PendingNonce = nonce.Nonce
+	if s.Mempool != nil {
+		nonce.PendingNonce = s.Mempool.PendingNonce(address)
+	}
+
+	return c.JSON(http.StatusOK, nonce)
+}
+
+func (s *Server) handleGetContractCode(c echo.Context) error {
+	b, err := hex.DecodeString(c.Param("address"))
+	if err != nil || len(b) != 20 {
+		return c.JSON(http.StatusBadRequest, APIError{Error: "invalid contract address"})
+	}
+
+	address := types.AddressFromBytes(b)
+
+	code, err := s.bc.GetContractCode(address)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, APIError{Error: err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, ContractCode{
+		Address: address.String(),
+		Code:    hex.EncodeToString(code),
+	})
+}
+
+func (s *Server) handleGetContractStorage(c echo.Context) error {
+	b, err := hex.DecodeString(c.Param("address"))
+	if err != nil || len(b) != 20 {
+		return c.JSON(http.StatusBadRequest, APIError{Error: "invalid contract address"})
+	}
+
+	key, err := hex.DecodeString(c.Param("key"))
+	if err != nil || len(key) == 0 {
+		return c.JSON(http.StatusBadRequest, APIError{Error: "invalid storage key"})
+	}
+
+	address := types.AddressFromBytes(b)
+
+	value, err := s.bc.GetContractStorage(address, key)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, APIError{Error: err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, ContractStorageValue{
+		Address: address.String(),
+		Key:     hex.EncodeToString(key),
+		Value:   hex.EncodeToString(value),
+	})
+}
+
+func (s *Server) handleGetPeers(c echo.Context) error {
+	return c.JSON(http.StatusOK, s.Network.Peers())
+}
+
+func (s *Server) handleGetBans(c echo.Context) error {
+	return c.JSON(http.StatusOK, s.Network.Bans())
+}
+
+// requireAdmin rejects requests that do not carry the admin token.
+func (s *Server) requireAdmin(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		token, ok := strings.CutPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.AdminToken)) != 1 {
+			return c.JSON(http.StatusUnauthorized, APIError{Error: "admin token required"})
+		}
+
+		return next(c)
+	}
+}
+
+// handleBan bans a node for the duration given as query parameter, e.g.
+// ?duration=2h, or forever without one.
+func (s *Server) handleBan(c echo.Context) error {
+	nodeKey, err := parseNodeKey(c.Param("nodekey"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, APIError{Error: err.Error()})
+	}
+
+	var duration time.Duration
+	if d := c.QueryParam("duration"); len(d) > 0 {
+		if duration, err = time.ParseDuration(d); err != nil || duration < 0 {
+			return c.JSON(http.StatusBadRequest, APIError{Error: "invalid ban duration"})
+		}
+	}
+
+	if err := s.Network.Ban(nodeKey, duration, c.QueryParam("reason")); err != nil {
+		return c.JSON(http.StatusInternalServerError, APIError{Error: err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, s.Network.Bans())
+}
+
+func (s *Server) handleUnban(c echo.Context) error {
+	nodeKey, err := parseNodeKey(c.Param("nodekey"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, APIError{Error: err.Error()})
+	}
+
+	ok, err := s.Network.Unban(nodeKey)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, APIError{Error: err.Error()})
+	}
+	if !ok {
+		return c.JSON(http.StatusNotFound, APIError{Error: "node is not banned"})
+	}
+
+	return c.JSON(http.StatusOK, s.Network.Bans())
+}
+
+func parseNodeKey(s string) (crypto.PublicKey, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != 33 {
+		return nil, fmt.Errorf("invalid node key")
+	}
+
+	return crypto.PublicKey(b), nil
+}
+
 func (s *Server) handleGetBlock(c echo.Context) error {
 	hashOrID := c.Param("hashorid")
 
@@ -134,8 +530,11 @@ func convertResponse(block *core.Block) Block {
 	return Block{
 		Hash:          block.Hash(core.BlockHasher{}).String(),
 		Version:       block.Header.Version,
+		ChainID:       block.Header.ChainID,
 		Height:        block.Header.Height,
 		DataHash:      block.Header.DataHash.String(),
+		StateRoot:     block.Header.StateRoot.String(),
+		ReceiptsRoot:  block.Header.ReceiptsRoot.String(),
 		PrevBlockHash: block.Header.PrevBlockHash.String(),
 		Timestamp:     time.Unix(0, block.Header.Timestamp),
 		Validator:     block.Validator.Address().String(),
@@ -0,0 +1,401 @@
+// Package asm implements a textual assembly language for the instruction set
+// of core.VM.
+//
+// Every line holds at most one statement, comments start with ';':
+//
+//	.const LIMIT 1000   ; named constant, an integer or a string
+//	.byte 0x01 0x02     ; raw bytes
+//	loop:               ; label, emits JUMPDEST
+//	    PUSH LIMIT      ; smallest push for integers, PUSH_BYTES for strings
+//	    PUSH @loop      ; address of a label
+//	    PUSH2 0x0100    ; explicit push width
+//	    JUMPI
+//
+// The assembler produces version 1 code, see core.CodeMagic.
+package asm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/k0yote/privatechain/core"
+)
+
+// labelPushSize is the width of the push emitted for label references, so
+// forward references can be sized before the label is known.
+const labelPushSize = 2
+
+type operand struct {
+	isBytes bool
+	bytes   []byte
+	integer int64
+	label   string
+}
+
+type statement struct {
+	line  int
+	label string
+	instr core.Instruction
+	// push is set for the PUSH pseudo instruction, see instruction.
+	push bool
+	arg  *operand
+	raw  []byte
+}
+
+type assembler struct {
+	consts map[string]operand
+	labels map[string]int
+	stmts  []*statement
+}
+
+// Assemble translates source into version 1 code.
+func Assemble(source string) ([]byte, error) {
+	a := &assembler{
+		consts: make(map[string]operand),
+		labels: make(map[string]int),
+	}
+
+	for i, line := range strings.Split(source, "\n") {
+		if err := a.parseLine(i+1, line); err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+	}
+
+	size := 0
+	for _, stmt := range a.stmts {
+		if stmt.label != "" {
+			if _, ok := a.labels[stmt.label]; ok {
+				return nil, fmt.Errorf("line %d: label (%s) already defined", stmt.line, stmt.label)
+			}
+			a.labels[stmt.label] = size
+		}
+
+		n, err := a.size(stmt)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", stmt.line, err)
+		}
+		size += n
+	}
+
+	code := make([]byte, 0, size)
+	for _, stmt := range a.stmts {
+		b, err := a.emit(stmt)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", stmt.line, err)
+		}
+		code = append(code, b...)
+	}
+
+	return core.NewCode(core.CodeVersion1, code), nil
+}
+
+// MustAssemble is like Assemble but panics on errors.
+func MustAssemble(source string) []byte {
+	code, err := Assemble(source)
+	if err != nil {
+		panic(err)
+	}
+
+	return code
+}
+
+func (a *assembler) parseLine(n int, line string) error {
+	fields, err := tokenize(line)
+	if err != nil {
+		return err
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+
+	if name, ok := strings.CutSuffix(fields[0], ":"); ok {
+		if !isIdentifier(name) {
+			return fmt.Errorf("invalid label (%s)", name)
+		}
+		a.stmts = append(a.stmts, &statement{line: n, label: name, instr: core.InstrJumpDest})
+
+		fields = fields[1:]
+		if len(fields) == 0 {
+			return nil
+		}
+	}
+
+	switch strings.ToLower(fields[0]) {
+	case ".const":
+		if len(fields) != 3 || !isIdentifier(fields[1]) {
+			return fmt.Errorf("expected .const NAME VALUE")
+		}
+		if _, ok := a.consts[fields[1]]; ok {
+			return fmt.Errorf("constant (%s) already defined", fields[1])
+		}
+
+		op, err := a.parseOperand(fields[2])
+		if err != nil {
+			return err
+		}
+		if op.label != "" {
+			return fmt.Errorf("constants cannot refer to labels")
+		}
+		a.consts[fields[1]] = *op
+
+		return nil
+
+	case ".byte":
+		if len(fields) == 1 {
+			return fmt.Errorf("expected .byte VALUE...")
+		}
+
+		stmt := &statement{line: n}
+		for _, f := range fields[1:] {
+			v, err := strconv.ParseUint(f, 0, 8)
+			if err != nil {
+				return fmt.Errorf("invalid byte (%s)", f)
+			}
+			stmt.raw = append(stmt.raw, byte(v))
+		}
+		a.stmts = append(a.stmts, stmt)
+
+		return nil
+	}
+
+	name := strings.ToUpper(fields[0])
+	stmt := &statement{line: n}
+
+	if name == "PUSH" {
+		stmt.push = true
+	} else {
+		instr, ok := core.LookupInstruction(name)
+		if !ok || !instr.IsValid(core.CodeVersion1) {
+			return fmt.Errorf("unknown instruction (%s)", fields[0])
+		}
+		stmt.instr = instr
+	}
+
+	takesOperand := stmt.push || isPush(stmt.instr)
+	switch {
+	case takesOperand && len(fields) != 2:
+		return fmt.Errorf("%s takes one operand", name)
+	case !takesOperand && len(fields) != 1:
+		return fmt.Errorf("%s takes no operands", name)
+	}
+
+	if takesOperand {
+		op, err := a.parseOperand(fields[1])
+		if err != nil {
+			return err
+		}
+		stmt.arg = op
+	}
+
+	a.stmts = append(a.stmts, stmt)
+
+	return nil
+}
+
+func (a *assembler) parseOperand(s string) (*operand, error) {
+	switch {
+	case strings.HasPrefix(s, "\""):
+		str, err := strconv.Unquote(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid string (%s)", s)
+		}
+		return &operand{isBytes: true, bytes: []byte(str)}, nil
+
+	case strings.HasPrefix(s, "@"):
+		if !isIdentifier(s[1:]) {
+			return nil, fmt.Errorf("invalid label reference (%s)", s)
+		}
+		return &operand{label: s[1:]}, nil
+
+	case isIdentifier(s):
+		op, ok := a.consts[s]
+		if !ok {
+			return nil, fmt.Errorf("unknown constant (%s)", s)
+		}
+		return &op, nil
+	}
+
+	v, err := strconv.ParseInt(s, 0, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid integer (%s)", s)
+	}
+
+	return &operand{integer: v}, nil
+}
+
+// resolve returns the operand of stmt with label references replaced by
+// their address.
+func (a *assembler) resolve(stmt *statement) (*operand, error) {
+	if stmt.arg == nil || stmt.arg.label == "" {
+		return stmt.arg, nil
+	}
+
+	address, ok := a.labels[stmt.arg.label]
+	if !ok {
+		return nil, fmt.Errorf("unknown label (%s)", stmt.arg.label)
+	}
+	if address > math.MaxUint16 {
+		return nil, fmt.Errorf("label (%s) out of range", stmt.arg.label)
+	}
+
+	return &operand{integer: int64(address)}, nil
+}
+
+// instruction returns the instruction emitted for stmt, picking the push
+// instruction for PUSH.
+func (a *assembler) instruction(stmt *statement) core.Instruction {
+	if !stmt.push {
+		return stmt.instr
+	}
+
+	switch {
+	case stmt.arg.label != "":
+		return core.InstrPush1 + labelPushSize - 1
+	case stmt.arg.isBytes:
+		return core.InstrPushBytes
+	case stmt.arg.integer < 0:
+		return core.InstrPushInt64
+	}
+
+	n := 1
+	for v := uint64(stmt.arg.integer) >> 8; v > 0; v >>= 8 {
+		n++
+	}
+
+	return core.InstrPush1 + core.Instruction(n-1)
+}
+
+func (a *assembler) size(stmt *statement) (int, error) {
+	if stmt.raw != nil {
+		return len(stmt.raw), nil
+	}
+
+	instr := a.instruction(stmt)
+
+	switch {
+	case instr == core.InstrPushBytes:
+		if !stmt.arg.isBytes {
+			return 0, fmt.Errorf("PUSH_BYTES expects a string")
+		}
+		if len(stmt.arg.bytes) > math.MaxUint16 {
+			return 0, fmt.Errorf("string too long (%d bytes)", len(stmt.arg.bytes))
+		}
+		return 3 + len(stmt.arg.bytes), nil
+	case instr == core.InstrPushInt64:
+		return 9, nil
+	case isPush(instr):
+		return 1 + int(instr-core.InstrPush1) + 1, nil
+	}
+
+	return 1, nil
+}
+
+func (a *assembler) emit(stmt *statement) ([]byte, error) {
+	if stmt.raw != nil {
+		return stmt.raw, nil
+	}
+
+	instr := a.instruction(stmt)
+	arg, err := a.resolve(stmt)
+	if err != nil {
+		return nil, err
+	}
+
+	code := []byte{byte(instr)}
+
+	switch {
+	case instr == core.InstrPushBytes:
+		code = binary.BigEndian.AppendUint16(code, uint16(len(arg.bytes)))
+		code = append(code, arg.bytes...)
+
+	case instr == core.InstrPushInt64:
+		if arg.isBytes {
+			return nil, fmt.Errorf("PUSH_INT64 expects an integer")
+		}
+		code = binary.BigEndian.AppendUint64(code, uint64(arg.integer))
+
+	case isPush(instr):
+		if arg.isBytes || arg.integer < 0 {
+			return nil, fmt.Errorf("%s expects a non negative integer", instr)
+		}
+
+		n := int(instr-core.InstrPush1) + 1
+		imm := make([]byte, n)
+		v := uint64(arg.integer)
+		for i := n - 1; i >= 0; i-- {
+			imm[i] = byte(v)
+			v >>= 8
+		}
+		if v != 0 {
+			return nil, fmt.Errorf("%d does not fit %s", arg.integer, instr)
+		}
+		code = append(code, imm...)
+	}
+
+	return code, nil
+}
+
+func isPush(instr core.Instruction) bool {
+	return instr == core.InstrPushInt64 || instr == core.InstrPushBytes ||
+		(instr >= core.InstrPush1 && instr <= core.InstrPush32)
+}
+
+func isIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for i, r := range s {
+		if r == '_' || unicode.IsLetter(r) || (i > 0 && unicode.IsDigit(r)) {
+			continue
+		}
+		return false
+	}
+
+	return true
+}
+
+// tokenize splits line into whitespace separated fields, keeping quoted
+// strings together and dropping comments.
+func tokenize(line string) ([]string, error) {
+	fields := []string{}
+
+	for i := 0; i < len(line); {
+		c := line[i]
+
+		switch {
+		case c == ';':
+			return fields, nil
+
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+
+		case c == '"':
+			end := i + 1
+			for ; end < len(line) && line[end] != '"'; end++ {
+				if line[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(line) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			fields = append(fields, line[i:end+1])
+			i = end + 1
+
+		default:
+			end := i
+			for end < len(line) && !strings.ContainsRune(" \t\r;\"", rune(line[end])) {
+				end++
+			}
+			fields = append(fields, line[i:end])
+			i = end
+		}
+	}
+
+	return fields, nil
+}
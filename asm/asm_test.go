@@ -0,0 +1,138 @@
+package asm
+
+import (
+	"testing"
+
+	"github.com/k0yote/privatechain/core"
+	"github.com/stretchr/testify/assert"
+)
+
+const loopSource = `
+.const COUNT 1000
+.const STEP 2
+
+	PUSH 0          ; acc
+	PUSH COUNT      ; i
+loop:
+	SWAP
+	PUSH STEP
+	ADD
+	SWAP
+	PUSH 1
+	SWAP
+	SUB             ; i - 1
+	DUP
+	PUSH @loop
+	JUMPI
+	POP
+	RETURN
+`
+
+func TestAssemble(t *testing.T) {
+	code, err := Assemble(`
+		PUSH 5
+		PUSH 300
+		PUSH -1
+		PUSH "FOO"
+		push2 7 ; lower case and explicit width
+		HALT
+	`)
+	assert.Nil(t, err)
+
+	expected := core.NewCode(core.CodeVersion1, []byte{
+		0x60, 0x05,
+		0x61, 0x01, 0x2c,
+		0x21, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0x22, 0x00, 0x03, 'F', 'O', 'O',
+		0x61, 0x00, 0x07,
+		0x1f,
+	})
+	assert.Equal(t, expected, code)
+}
+
+func TestAssembleRun(t *testing.T) {
+	vm := core.NewVM(MustAssemble(loopSource), core.NewState(), 100_000, core.VMContext{})
+	assert.Nil(t, vm.Run())
+	assert.Equal(t, core.Int(2000), vm.Result())
+}
+
+func TestAssembleForwardLabel(t *testing.T) {
+	code := MustAssemble(`
+		PUSH @end
+		JUMP
+		PUSH 1
+		RETURN
+	end:
+		PUSH 2
+		RETURN
+	`)
+
+	vm := core.NewVM(code, core.NewState(), 1_000, core.VMContext{})
+	assert.Nil(t, vm.Run())
+	assert.Equal(t, core.Int(2), vm.Result())
+}
+
+func TestAssembleErrors(t *testing.T) {
+	sources := []string{
+		"FOO",
+		"PUSH @missing\nJUMP",
+		"a:\na:",
+		"PUSH_INT",
+		"ADD 1",
+		"PUSH",
+		"PUSH1 256",
+		"PUSH \"open",
+		"PUSH UNKNOWN",
+		".const X 1\n.const X 2",
+		".byte",
+		".byte ; no bytes",
+	}
+
+	for _, source := range sources {
+		_, err := Assemble(source)
+		assert.NotNil(t, err, source)
+	}
+}
+
+func TestDisassemble(t *testing.T) {
+	code := MustAssemble(`
+	start:
+		PUSH 300
+		PUSH -7
+		PUSH "a;b"
+		PUSH @start
+		JUMP
+	`)
+
+	listing, err := Disassemble(code)
+	assert.Nil(t, err)
+	assert.Equal(t, `; version 1
+0000  JUMPDEST
+0001  PUSH2 0x012c
+0004  PUSH_INT64 -7
+000d  PUSH_BYTES "a;b"
+0013  PUSH2 0x0000
+0016  JUMP
+`, listing)
+
+	// The listing without addresses assembles to the same code.
+	_, lines, err := Decode(code)
+	assert.Nil(t, err)
+	source := ""
+	for _, line := range lines {
+		source += line.String()[6:] + "\n"
+	}
+	assert.Equal(t, code, MustAssemble(source))
+}
+
+func TestDisassembleLegacy(t *testing.T) {
+	listing, err := Disassemble([]byte{0x02, 0x0a, 0x03, 0x0a, 0x0b})
+	assert.Nil(t, err)
+	assert.Equal(t, `; legacy code
+0000  .byte 0x02
+0001  PUSH_INT ; operand 0x02
+0002  .byte 0x03
+0003  PUSH_INT ; operand 0x03
+0004  ADD
+`, listing)
+}
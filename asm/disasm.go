@@ -0,0 +1,103 @@
+package asm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/k0yote/privatechain/core"
+)
+
+// Line is one instruction of a disassembled program.
+type Line struct {
+	Address int
+	Instr   core.Instruction
+	// Operand is the formatted immediate, or a note for legacy code.
+	Operand string
+	// Raw is set for bytes that are not a valid instruction.
+	Raw bool
+}
+
+func (l Line) String() string {
+	var s string
+	switch {
+	case l.Raw:
+		s = fmt.Sprintf(".byte 0x%02x", byte(l.Instr))
+	case l.Operand != "":
+		s = fmt.Sprintf("%s %s", l.Instr, l.Operand)
+	default:
+		s = l.Instr.String()
+	}
+
+	return fmt.Sprintf("%04x  %s", l.Address, s)
+}
+
+// Decode splits code into its version and instructions. Legacy code is
+// listed byte by byte since every byte is executed.
+func Decode(code []byte) (byte, []Line, error) {
+	version, body, err := core.ParseCode(code)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	lines := []Line{}
+	for pc := 0; pc < len(body); pc++ {
+		instr := core.Instruction(body[pc])
+		line := Line{
+			Address: pc,
+			Instr:   instr,
+			Raw:     !instr.IsValid(version),
+		}
+
+		if version == core.CodeVersionLegacy {
+			if !line.Raw && (instr == core.InstrPushInt || instr == core.InstrPushByte) && pc > 0 {
+				line.Operand = fmt.Sprintf("; operand 0x%02x", body[pc-1])
+			}
+			lines = append(lines, line)
+			continue
+		}
+
+		n, err := core.ImmediateSize(body, pc)
+		if err != nil || pc+1+n > len(body) {
+			return 0, nil, fmt.Errorf("truncated immediate at %04x", pc)
+		}
+
+		imm := body[pc+1 : pc+1+n]
+		switch {
+		case instr == core.InstrPushBytes:
+			line.Operand = strconv.Quote(string(imm[2:]))
+		case instr == core.InstrPushInt64:
+			line.Operand = strconv.FormatInt(int64(binary.BigEndian.Uint64(imm)), 10)
+		case n > 0:
+			line.Operand = fmt.Sprintf("0x%x", imm)
+		}
+
+		lines = append(lines, line)
+		pc += n
+	}
+
+	return version, lines, nil
+}
+
+// Disassemble returns a listing of code.
+func Disassemble(code []byte) (string, error) {
+	version, lines, err := Decode(code)
+	if err != nil {
+		return "", err
+	}
+
+	b := &strings.Builder{}
+	if version == core.CodeVersionLegacy {
+		b.WriteString("; legacy code\n")
+	} else {
+		fmt.Fprintf(b, "; version %d\n", version)
+	}
+
+	for _, line := range lines {
+		b.WriteString(line.String())
+		b.WriteByte('\n')
+	}
+
+	return b.String(), nil
+}
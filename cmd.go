@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/k0yote/privatechain/asm"
+)
+
+var errUsage = errors.New(`usage:
+  k0yote                 run the demo network
+  k0yote asm FILE        assemble FILE (- for stdin) and print the code as hex
+  k0yote disasm HEX      disassemble code given as hex (- for stdin)
+  k0yote debug FILE      assemble FILE and step through it`)
+
+// runCommand runs the subcommand given in args.
+func runCommand(args []string, out io.Writer) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+
+	switch args[0] {
+	case "asm":
+		source, err := readArg(args[1], os.ReadFile)
+		if err != nil {
+			return err
+		}
+
+		code, err := asm.Assemble(source)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(out, hex.EncodeToString(code))
+
+		return err
+
+	case "disasm":
+		input, err := readArg(args[1], func(s string) ([]byte, error) { return []byte(s), nil })
+		if err != nil {
+			return err
+		}
+
+		code, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(input), "0x"))
+		if err != nil {
+			return err
+		}
+
+		listing, err := asm.Disassemble(code)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprint(out, listing)
+
+		return err
+
+	case "debug":
+		source, err := os.ReadFile(args[1])
+		if err != nil {
+			return err
+		}
+
+		return debugCode(string(source), os.Stdin, out)
+	}
+
+	return errUsage
+}
+
+// readArg reads stdin for "-" and calls read with arg otherwise.
+func readArg(arg string, read func(string) ([]byte, error)) (string, error) {
+	if arg == "-" {
+		b, err := io.ReadAll(os.Stdin)
+		return string(b), err
+	}
+
+	b, err := read(arg)
+	return string(b), err
+}
@@ -1,6 +1,7 @@
 package core
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"sync"
@@ -11,20 +12,47 @@ import (
 var (
 	ErrAccountNotFound     = errors.New("account not found")
 	ErrInsufficientBalance = errors.New("insufficient account balance")
+	ErrInvalidAccount      = errors.New("invalid encoded account")
+	ErrNonceTooLow         = errors.New("nonce too low")
+	ErrNonceTooHigh        = errors.New("nonce too high")
 )
 
 type Account struct {
 	Address types.Address
 	Balance uint64
+	// Nonce is the number of transactions sent from this account, which is
+	// also the nonce its next transaction has to carry.
+	Nonce uint64
 }
 
 func (a *Account) String() string {
 	return fmt.Sprintf("%d", a.Balance)
 }
 
+func (a *Account) Bytes() []byte {
+	buf := make([]byte, 16)
+	binary.BigEndian.PutUint64(buf[0:8], a.Balance)
+	binary.BigEndian.PutUint64(buf[8:16], a.Nonce)
+
+	return buf
+}
+
+func decodeAccount(address types.Address, b []byte) (*Account, error) {
+	if len(b) != 16 {
+		return nil, ErrInvalidAccount
+	}
+
+	return &Account{
+		Address: address,
+		Balance: binary.BigEndian.Uint64(b[0:8]),
+		Nonce:   binary.BigEndian.Uint64(b[8:16]),
+	}, nil
+}
+
 type AccountState struct {
 	mu       sync.RWMutex
 	accounts map[types.Address]*Account
+	journal  *journal
 }
 
 func NewAccountState() *AccountState {
@@ -37,6 +65,7 @@ func (s *AccountState) CreateAccount(address types.Address) *Account {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	s.recordWithoutLock(address)
 	acc := &Account{Address: address}
 	s.accounts[address] = acc
 	return acc
@@ -46,6 +75,7 @@ func (s *AccountState) CreateAccountWithBalance(address types.Address, balance u
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	s.recordWithoutLock(address)
 	acc := &Account{Address: address, Balance: balance}
 	s.accounts[address] = acc
 	return acc
@@ -79,6 +109,45 @@ func (s *AccountState) GetBalance(address types.Address) (uint64, error) {
 	return account.Balance, nil
 }
 
+// GetNonce returns the nonce the next transaction of address has to carry.
+// Unknown accounts start at zero.
+func (s *AccountState) GetNonce(address types.Address) uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	account, err := s.getAccountWithoutLock(address)
+	if err != nil {
+		return 0
+	}
+
+	return account.Nonce
+}
+
+// UseNonce checks that nonce is the next nonce of address and increments the
+// account nonce, creating the account if needed.
+func (s *AccountState) UseNonce(address types.Address, nonce uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	account, ok := s.accounts[address]
+	if !ok {
+		account = &Account{Address: address}
+	}
+
+	if nonce < account.Nonce {
+		return ErrNonceTooLow
+	}
+	if nonce > account.Nonce {
+		return ErrNonceTooHigh
+	}
+
+	s.recordWithoutLock(address)
+	account.Nonce++
+	s.accounts[address] = account
+
+	return nil
+}
+
 func (s *AccountState) Transfer(from, to types.Address, amount uint64) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -92,6 +161,9 @@ func (s *AccountState) Transfer(from, to types.Address, amount uint64) error {
 		return ErrInsufficientBalance
 	}
 
+	s.recordWithoutLock(from)
+	s.recordWithoutLock(to)
+
 	fromAccount.Balance -= amount
 
 	if s.accounts[to] == nil {
@@ -104,3 +176,97 @@ func (s *AccountState) Transfer(from, to types.Address, amount uint64) error {
 
 	return nil
 }
+
+// Charge takes amount from the balance of address.
+func (s *AccountState) Charge(address types.Address, amount uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	account, err := s.getAccountWithoutLock(address)
+	if err != nil {
+		return err
+	}
+
+	if account.Balance < amount {
+		return ErrInsufficientBalance
+	}
+
+	s.recordWithoutLock(address)
+	account.Balance -= amount
+
+	return nil
+}
+
+// Credit adds amount to the balance of address, creating the account if
+// needed. Crediting zero is a no-op.
+func (s *AccountState) Credit(address types.Address, amount uint64) {
+	if amount == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.recordWithoutLock(address)
+	if s.accounts[address] == nil {
+		s.accounts[address] = &Account{
+			Address: address,
+		}
+	}
+
+	s.accounts[address].Balance += amount
+}
+
+func (s *AccountState) recordWithoutLock(address types.Address) {
+	s.journal.record(StateKindAccount, string(address.ToSlice()), s.encodedWithoutLock(address))
+}
+
+func (s *AccountState) encodedWithoutLock(address types.Address) []byte {
+	account, ok := s.accounts[address]
+	if !ok {
+		return nil
+	}
+
+	return account.Bytes()
+}
+
+func (s *AccountState) getEncoded(key string) []byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.encodedWithoutLock(types.AddressFromBytes([]byte(key)))
+}
+
+func (s *AccountState) setEncoded(key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	address := types.AddressFromBytes([]byte(key))
+	if value == nil {
+		delete(s.accounts, address)
+		return nil
+	}
+
+	account, err := decodeAccount(address, value)
+	if err != nil {
+		return err
+	}
+
+	// Keep pointers handed out by GetAccount valid.
+	if existing, ok := s.accounts[address]; ok {
+		*existing = *account
+		return nil
+	}
+	s.accounts[address] = account
+
+	return nil
+}
+
+func (s *AccountState) forEachEncoded(fn func(key string, value []byte)) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for address, account := range s.accounts {
+		fn(string(address.ToSlice()), account.Bytes())
+	}
+}
@@ -66,3 +66,16 @@ func TestAccountState(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, uint64(0), bobBalance)
 }
+
+func TestAccountStateUseNonce(t *testing.T) {
+	state := NewAccountState()
+	address := crypto.GeneratePrivateKey().PublicKey().Address()
+
+	assert.Equal(t, uint64(0), state.GetNonce(address))
+	assert.Equal(t, ErrNonceTooHigh, state.UseNonce(address, 1))
+	assert.Nil(t, state.UseNonce(address, 0))
+	assert.Equal(t, uint64(1), state.GetNonce(address))
+	assert.Equal(t, ErrNonceTooLow, state.UseNonce(address, 0))
+	assert.Nil(t, state.UseNonce(address, 1))
+	assert.Equal(t, uint64(2), state.GetNonce(address))
+}
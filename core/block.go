@@ -1,9 +1,6 @@
 package core
 
 import (
-	"bytes"
-	"crypto/sha256"
-	"encoding/gob"
 	"fmt"
 	"time"
 
@@ -12,20 +9,26 @@ import (
 )
 
 type Header struct {
-	Version       uint32
-	DataHash      types.Hash
+	Version   uint32
+	ChainID   uint64
+	DataHash  types.Hash
+	StateRoot types.Hash
+	// ReceiptsRoot is the Merkle root over the receipts of the transactions.
+	ReceiptsRoot  types.Hash
 	PrevBlockHash types.Hash
 	Timestamp     int64
 	Height        uint32
 	Nonce         uint64
 }
 
+// Bytes returns the canonical encoding of the header, which is what the
+// block hash commits to.
 func (h *Header) Bytes() []byte {
-	buf := &bytes.Buffer{}
-	enc := gob.NewEncoder(buf)
-	enc.Encode(h)
+	w := &codecWriter{}
+	w.u8(CodecVersion)
+	writeHeader(w, h)
 
-	return buf.Bytes()
+	return w.buf.Bytes()
 }
 
 type Block struct {
@@ -52,8 +55,10 @@ func NewBlockFromPrevHeader(prevHeader *Header, txx []*Transaction) (*Block, err
 	}
 	header := &Header{
 		Version:       1,
+		ChainID:       prevHeader.ChainID,
 		Height:        prevHeader.Height + 1,
 		DataHash:      dataHash,
+		StateRoot:     prevHeader.StateRoot,
 		PrevBlockHash: BlockHasher{}.Hash(prevHeader),
 		Timestamp:     time.Now().UnixNano(),
 	}
@@ -67,8 +72,12 @@ func (b *Block) AddTransaction(tx *Transaction) {
 	b.DataHash = hash
 }
 
+// Sign signs the header hash. ECDSA only looks at as many bytes of the input
+// as the curve order has, so signing the raw header bytes would leave most of
+// the header unsigned.
 func (b *Block) Sign(privKey crypto.PrivateKey) error {
-	sig, err := privKey.Sign(b.Header.Bytes())
+	hash := BlockHasher{}.Hash(b.Header)
+	sig, err := privKey.Sign(hash.ToSlice())
 	if err != nil {
 		return err
 	}
@@ -81,12 +90,12 @@ func (b *Block) Sign(privKey crypto.PrivateKey) error {
 
 func (b *Block) Verify() error {
 	if b.Signature == nil {
-		return fmt.Errorf("block has no signature")
+		return fmt.Errorf("%w: block has no signature", ErrInvalidSignature)
 	}
 
-	fmt.Printf("%+v\n", b.Header)
-	if !b.Signature.Verify(b.Validator, b.Header.Bytes()) {
-		return fmt.Errorf("invalid block signature")
+	hash := BlockHasher{}.Hash(b.Header)
+	if !b.Signature.Verify(b.Validator, hash.ToSlice()) {
+		return fmt.Errorf("%w: block (%s)", ErrInvalidSignature, hash)
 	}
 
 	for _, tx := range b.Transactions {
@@ -101,7 +110,7 @@ func (b *Block) Verify() error {
 	}
 
 	if dataHash != b.DataHash {
-		return fmt.Errorf("block (%s) has an invalid data hash", b.Hash(BlockHasher{}))
+		return fmt.Errorf("%w: block (%s)", ErrInvalidDataHash, b.Hash(BlockHasher{}))
 	}
 
 	return nil
@@ -123,16 +132,12 @@ func (b *Block) Hash(hasher Hasher[*Header]) types.Hash {
 	return b.hash
 }
 
+// CalculateDataHash returns the Merkle root over the hashes of txx.
 func CalculateDataHash(txx []*Transaction) (hash types.Hash, err error) {
-	buf := &bytes.Buffer{}
-
-	for _, tx := range txx {
-		if err = tx.Encode(NewGobTxEncoder(buf)); err != nil {
-			return
-		}
+	hashes := make([]types.Hash, len(txx))
+	for i, tx := range txx {
+		hashes[i] = tx.Hash(TxHasher{})
 	}
 
-	hash = sha256.Sum256(buf.Bytes())
-
-	return
+	return MerkleRoot(hashes), nil
 }
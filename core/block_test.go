@@ -2,6 +2,7 @@ package core
 
 import (
 	"bytes"
+	"errors"
 	"testing"
 	"time"
 
@@ -38,17 +39,17 @@ func TestVerifyBlockTamperValidator(t *testing.T) {
 
 	otherPrivKey := crypto.GeneratePrivateKey()
 	b.Validator = otherPrivKey.PublicKey()
-	assert.NotNil(t, b.Verify())
+	assert.True(t, errors.Is(b.Verify(), ErrInvalidSignature))
 }
 
 func TestDecodeEncodeBlock(t *testing.T) {
 	b := randomBlock(t, 0, types.Hash{})
 	buf := &bytes.Buffer{}
 
-	assert.Nil(t, b.Encode(NewGobBlockEncoder(buf)))
+	assert.Nil(t, b.Encode(NewBinaryBlockEncoder(buf)))
 
 	bDecode := new(Block)
-	assert.Nil(t, bDecode.Decode(NewGobBlockDecoder(buf)))
+	assert.Nil(t, bDecode.Decode(NewBinaryBlockDecoder(buf)))
 
 	assert.Equal(t, b.Header, bDecode.Header)
 	for i := 0; i < len(b.Transactions); i++ {
@@ -2,6 +2,8 @@ package core
 
 import (
 	"fmt"
+	"path/filepath"
+	"reflect"
 	"sync"
 
 	"github.com/go-kit/log"
@@ -10,94 +12,267 @@ import (
 )
 
 type Blockchain struct {
-	logger     log.Logger
-	store      Storage
-	lock       sync.RWMutex
-	headers    []*Header
-	blocks     []*Block
-	txStore    map[types.Hash]*Transaction
-	blockStore map[types.Hash]*Block
+	logger  log.Logger
+	store   Storage
+	lock    sync.RWMutex
+	headers []*Header
+	txStore map[types.Hash]*Transaction
+	// height of the block every stored transaction was included in
+	txHeights map[types.Hash]uint32
 
 	accountState *AccountState
 
-	stateLock       sync.RWMutex
-	collectionState map[types.Hash]*CollectionTx
-	mintState       map[types.Hash]*MintTx
-	validator       Validator
-	contractState   *State
+	stateLock     sync.RWMutex
+	nftState      *NFTState
+	validator     Validator
+	contractState *State
+	// code and storage of deployed contracts
+	contractCode    *State
+	contractStorage *State
+	journal         *journal
+	// stateTree is the sparse Merkle tree over the state as of the last
+	// state root computation.
+	stateTree *smtNode
+	wal       *WAL
+	// events emitted by the transaction being executed
+	events       []Event
+	maxCallDepth int
+	// tracer is set while TraceTransaction executes the traced transaction
+	tracer Tracer
+	// traceSnapshots are the states TraceTransaction replays from, taken
+	// every traceSnapshotInterval blocks once EnableTracing was called.
+	traceSnapshots []*stateSnapshot
+	tracing        bool
 }
 
 func NewBlockchain(l log.Logger, genesis *Block) (*Blockchain, error) {
+	return NewBlockchainWithStorage(l, NewMemoryStore(), genesis)
+}
+
+func NewBlockchainWithStorage(l log.Logger, store Storage, genesis *Block) (*Blockchain, error) {
+	return newBlockchain(l, store, nil, genesis)
+}
+
+// OpenBlockchain opens (or creates) a blockchain persisted in dataDir. When
+// the directory already holds blocks the chain is replayed up to the stored
+// head and genesis is ignored. A block that was left half-applied by a crash
+// is completed from the write-ahead log.
+func OpenBlockchain(l log.Logger, dataDir string, genesis *Block) (*Blockchain, error) {
+	store, err := NewFileStore(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	wal, err := OpenWAL(filepath.Join(dataDir, "wal.dat"))
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
 
-	accountState := NewAccountState()
+	bc, err := newBlockchain(l, store, wal, genesis)
+	if err != nil {
+		store.Close()
+		wal.Close()
+		return nil, err
+	}
+
+	return bc, nil
+}
+
+func newBlockchain(l log.Logger, store Storage, wal *WAL, genesis *Block) (*Blockchain, error) {
+	bc := newEmptyBlockchain(l, store, wal)
 
 	coinbase := crypto.PublicKey{}
-	accountState.CreateAccountWithBalance(coinbase.Address(), 1_000_000_000)
+	bc.accountState.CreateAccountWithBalance(coinbase.Address(), 1_000_000_000)
 
+	pending, err := bc.recoverPendingBlock()
+	if err != nil {
+		return nil, err
+	}
+
+	replayed := 0
+	err = store.Iterate(func(b *Block) error {
+		replayed++
+		return bc.replayBlock(b, pending)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if pending != nil {
+		if err := bc.wal.Clear(); err != nil {
+			return nil, err
+		}
+	}
+
+	if replayed > 0 {
+		bc.logger.Log("msg", "replayed stored blocks", "height", bc.Height())
+		return bc, nil
+	}
+
+	return bc, bc.addBlockWithoutValidation(genesis)
+}
+
+// newEmptyBlockchain returns a chain without any blocks or state.
+func newEmptyBlockchain(l log.Logger, store Storage, wal *WAL) *Blockchain {
 	bc := &Blockchain{
 		headers:         []*Header{},
-		blocks:          []*Block{},
-		store:           NewMemoryStore(),
+		store:           store,
 		logger:          l,
 		contractState:   NewState(),
-		accountState:    accountState,
-		collectionState: make(map[types.Hash]*CollectionTx),
-		mintState:       make(map[types.Hash]*MintTx),
-		blockStore:      make(map[types.Hash]*Block),
+		contractCode:    newStateOfKind(StateKindCode),
+		contractStorage: newStateOfKind(StateKindStorage),
+		accountState:    NewAccountState(),
+		nftState:        NewNFTState(),
 		txStore:         make(map[types.Hash]*Transaction),
+		txHeights:       make(map[types.Hash]uint32),
+		journal:         newJournal(),
+		wal:             wal,
 	}
 	bc.validator = NewBlockValidator(bc)
-	err := bc.addBlockWithoutValidation(genesis)
 
-	return bc, err
+	bc.accountState.journal = bc.journal
+	bc.contractState.journal = bc.journal
+	bc.nftState.journal = bc.journal
+	bc.contractCode.journal = bc.journal
+	bc.contractStorage.journal = bc.journal
+	bc.journal.register(StateKindAccount, bc.accountState)
+	bc.journal.register(StateKindContract, bc.contractState)
+	bc.journal.register(StateKindCollection, nftStateView{bc.nftState, StateKindCollection})
+	bc.journal.register(StateKindMint, nftStateView{bc.nftState, StateKindMint})
+	bc.journal.register(StateKindCode, bc.contractCode)
+	bc.journal.register(StateKindStorage, bc.contractStorage)
+
+	return bc
+}
+
+// recoverPendingBlock completes a block that was journaled in the WAL but
+// never made it into the storage. Its record is returned so the replay can
+// check that re-executing the block yields the journaled state diff.
+func (bc *Blockchain) recoverPendingBlock() (*WALRecord, error) {
+	if bc.wal == nil {
+		return nil, nil
+	}
+
+	rec, err := bc.wal.Pending()
+	if err != nil || rec == nil {
+		return nil, err
+	}
+
+	hash := rec.Block.Hash(BlockHasher{})
+	if !bc.store.Has(hash) {
+		bc.logger.Log("msg", "completing half-applied block", "hash", hash, "height", rec.Block.Height)
+
+		if err := bc.store.Put(rec.Block, rec.Receipts); err != nil {
+			return nil, fmt.Errorf("failed to recover block (%s): %w", hash, err)
+		}
+	}
+
+	return rec, nil
+}
+
+// replayBlock re-executes a stored block and checks the resulting state
+// against its header, so a corrupted storage cannot silently diverge the
+// state. The genesis block is exempt, it is added without a state root.
+func (bc *Blockchain) replayBlock(b *Block, pending *WALRecord) error {
+	bc.stateLock.Lock()
+	defer bc.stateLock.Unlock()
+
+	bc.journal.begin()
+	defer bc.journal.finish()
+
+	receipts, err := bc.executeBlock(b)
+	if err != nil {
+		return err
+	}
+
+	if b.Height > 0 {
+		if err := bc.validator.ValidateState(b, bc.stateRootWithoutLock(), CalculateReceiptsRoot(receipts)); err != nil {
+			return fmt.Errorf("failed to replay stored block (%d): %w", b.Height, err)
+		}
+	}
+
+	if pending != nil && pending.Block.Hash(BlockHasher{}) == b.Hash(BlockHasher{}) {
+		if !reflect.DeepEqual(bc.journal.changes(), pending.Changes) {
+			return fmt.Errorf("state diff of recovered block (%s) does not match the journal", b.Hash(BlockHasher{}))
+		}
+	}
+
+	bc.appendBlock(b)
+	bc.snapshotForTracing(b.Height)
+
+	return nil
+}
+
+func (bc *Blockchain) Close() error {
+	if bc.wal != nil {
+		bc.wal.Close()
+	}
+
+	return bc.store.Close()
 }
 
 func (bc *Blockchain) SetValidator(v Validator) {
 	bc.validator = v
 }
 
+// SetMaxCallDepth limits how deep contracts may call each other, zero means
+// DefaultMaxCallDepth. Every node of a network has to use the same limit.
+func (bc *Blockchain) SetMaxCallDepth(depth int) {
+	bc.stateLock.Lock()
+	defer bc.stateLock.Unlock()
+
+	bc.maxCallDepth = depth
+}
+
 func (bc *Blockchain) AddBlock(b *Block) error {
 	if err := bc.validator.ValidateBlock(b); err != nil {
 		return err
 	}
 
-	return bc.addBlockWithoutValidation(b)
+	return bc.addBlock(b, true)
 }
 
-func (bc *Blockchain) handleNativeTransfer(tx *Transaction) error {
-	bc.logger.Log("msg", "handle native token transfer", "from", tx.From, "to", tx.To, "value", tx.Value)
-
-	return bc.accountState.Transfer(tx.From.Address(), tx.To.Address(), tx.Value)
-}
+// PrepareBlock executes b on top of the current state without committing
+// anything. Transactions that fail are dropped and the data hash, state root
+// and receipts root of the header are filled in, so the block is ready to be
+// signed.
+// b.Validator has to be set already since fees are credited to it.
+func (bc *Blockchain) PrepareBlock(b *Block) error {
+	bc.stateLock.Lock()
+	defer bc.stateLock.Unlock()
 
-func (bc *Blockchain) handleNativeNFT(tx *Transaction) error {
-	hash := tx.Hash(TxHasher{})
-	switch t := tx.TxInner.(type) {
-	case CollectionTx:
-		bc.collectionState[hash] = &t
+	bc.journal.begin()
+	defer bc.journal.finish()
 
-		bc.logger.Log("msg", "created new NFT collections", "hash", hash)
-	case MintTx:
-		_, ok := bc.collectionState[t.Collection]
-		if !ok {
-			return fmt.Errorf("collection (%s) does not exist on the blockchain", t.Collection)
-		}
+	txx, receipts, err := bc.selectTransactions(b)
+	if err != nil {
+		return err
+	}
+	b.Transactions = txx
 
-		bc.mintState[hash] = &t
-		bc.logger.Log("msg", "created new NFT mint", "NFT", t.NFT, "collection", t.Collection)
-	default:
-		return fmt.Errorf("unspported tx type: %v", t)
+	dataHash, err := CalculateDataHash(b.Transactions)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	b.DataHash = dataHash
+	b.StateRoot = bc.stateRootWithoutLock()
+	b.ReceiptsRoot = CalculateReceiptsRoot(receipts)
+	b.hash = types.Hash{}
+
+	return bc.journal.revertToSnapshot(0)
 }
 
-func (bc *Blockchain) GetBlockByHash(hash types.Hash) (*Block, error) {
-	bc.lock.Lock()
-	defer bc.lock.Unlock()
+func (bc *Blockchain) handleNativeTransfer(tx *Transaction) error {
+	bc.logger.Log("msg", "handle native token transfer", "from", tx.From, "to", tx.To, "value", tx.Value)
 
-	block, ok := bc.blockStore[hash]
-	if !ok {
+	return bc.accountState.Transfer(tx.From.Address(), tx.To.Address(), tx.Value)
+}
+
+func (bc *Blockchain) GetBlockByHash(hash types.Hash) (*Block, error) {
+	block, err := bc.store.GetByHash(hash)
+	if err != nil {
 		return nil, fmt.Errorf("block with hash (%s) not found", hash)
 	}
 
@@ -109,10 +284,7 @@ func (bc *Blockchain) GetBlock(height uint32) (*Block, error) {
 		return nil, fmt.Errorf("given height (%d) too high", height)
 	}
 
-	bc.lock.Lock()
-	defer bc.lock.Unlock()
-
-	return bc.blocks[height], nil
+	return bc.store.Get(height)
 }
 
 func (bc *Blockchain) GetHeader(height uint32) (*Header, error) {
@@ -138,10 +310,73 @@ func (bc *Blockchain) GetTxByHash(hash types.Hash) (*Transaction, error) {
 	return tx, nil
 }
 
+// TxProof proves that a transaction is part of the block with the given
+// header through a Merkle branch leading to the header's DataHash.
+type TxProof struct {
+	TxHash      types.Hash
+	BlockHash   types.Hash
+	BlockHeight uint32
+	DataHash    types.Hash
+	Proof       *MerkleProof
+}
+
+func (p *TxProof) Verify() bool {
+	return p.Proof.Verify(p.DataHash, p.TxHash)
+}
+
+func (bc *Blockchain) GetTxProof(hash types.Hash) (*TxProof, error) {
+	bc.lock.RLock()
+	height, ok := bc.txHeights[hash]
+	bc.lock.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("transaction with hash (%s) not found", hash)
+	}
+
+	block, err := bc.GetBlock(height)
+	if err != nil {
+		return nil, err
+	}
+
+	index := -1
+	hashes := make([]types.Hash, len(block.Transactions))
+	for i, tx := range block.Transactions {
+		hashes[i] = tx.Hash(TxHasher{})
+		if hashes[i] == hash {
+			index = i
+		}
+	}
+
+	proof, err := NewMerkleProof(hashes, index)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TxProof{
+		TxHash:      hash,
+		BlockHash:   block.Hash(BlockHasher{}),
+		BlockHeight: height,
+		DataHash:    block.DataHash,
+		Proof:       proof,
+	}, nil
+}
+
 func (bc *Blockchain) HasBlock(height uint32) bool {
 	return height <= bc.Height()
 }
 
+// ChainID returns the chain id set in the genesis header.
+func (bc *Blockchain) ChainID() uint64 {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	if len(bc.headers) == 0 {
+		return 0
+	}
+
+	return bc.headers[0].ChainID
+}
+
 func (bc *Blockchain) Height() uint32 {
 	bc.lock.RLock()
 	defer bc.lock.RUnlock()
@@ -149,58 +384,174 @@ func (bc *Blockchain) Height() uint32 {
 	return uint32(len(bc.headers) - 1)
 }
 
-func (bc *Blockchain) handleTransaction(tx *Transaction) error {
+// GetBalance returns the balance of address, zero for unknown accounts.
+func (bc *Blockchain) GetBalance(address types.Address) uint64 {
+	bc.stateLock.RLock()
+	defer bc.stateLock.RUnlock()
+
+	balance, err := bc.accountState.GetBalance(address)
+	if err != nil {
+		return 0
+	}
+
+	return balance
+}
+
+func (bc *Blockchain) GetNonce(address types.Address) uint64 {
+	bc.stateLock.RLock()
+	defer bc.stateLock.RUnlock()
+
+	return bc.accountState.GetNonce(address)
+}
+
+// handleTransaction applies tx as part of b and returns its receipt. A
+// transaction that cannot pay for its gas returns an error. Once paid for, a
+// failing execution is reverted but the transaction is kept, its gas is
+// charged and its receipt records the failure. The gas fee and the payload
+// fee are credited to the validator of b. Transactions of the genesis block
+// are free.
+func (bc *Blockchain) handleTransaction(tx *Transaction, b *Block) (*Receipt, error) {
+	if len(bc.headers) > 0 && tx.ChainID != bc.headers[0].ChainID {
+		return nil, fmt.Errorf("%w: transaction (%s) with chain id (%d)", ErrInvalidChainID, tx.Hash(TxHasher{}), tx.ChainID)
+	}
+
+	if b.Height == 0 {
+		if err := bc.accountState.UseNonce(tx.From.Address(), tx.Nonce); err != nil {
+			return nil, err
+		}
+		gasUsed, err := bc.executeTransaction(tx, bc.newVMContext(tx, b), tx.GasLimit)
+		if err != nil {
+			return nil, err
+		}
+		return newReceipt(tx, gasUsed, bc.events, nil), nil
+	}
+
+	gasUsed, err := checkGas(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	cost, err := tx.Cost()
+	if err != nil {
+		return nil, err
+	}
+
+	from := tx.From.Address()
+	if cost > 0 {
+		balance, err := bc.accountState.GetBalance(from)
+		if err != nil || balance < cost {
+			return nil, fmt.Errorf("%w: transaction (%s) costs (%d)", ErrInsufficientBalance, tx.Hash(TxHasher{}), cost)
+		}
+	}
+
+	if err := bc.accountState.UseNonce(from, tx.Nonce); err != nil {
+		return nil, fmt.Errorf("transaction (%s) from (%s) with nonce (%d): %w", tx.Hash(TxHasher{}), from, tx.Nonce, err)
+	}
+
+	fee, err := tx.Fee()
+	if err != nil {
+		return nil, err
+	}
+	if err := bc.accountState.Charge(from, tx.GasLimit*tx.GasPrice+fee); err != nil {
+		return nil, err
+	}
+
+	snapshot := bc.journal.snapshot()
+	codeGas, execErr := bc.executeTransaction(tx, bc.newVMContext(tx, b), tx.GasLimit-gasUsed)
+	gasUsed += codeGas
+	if execErr != nil {
+		bc.logger.Log("msg", "transaction execution failed", "hash", tx.Hash(TxHasher{}), "err", execErr)
+
+		if err := bc.journal.revertToSnapshot(snapshot); err != nil {
+			return nil, err
+		}
+	}
+
+	bc.accountState.Credit(from, (tx.GasLimit-gasUsed)*tx.GasPrice)
+	if len(b.Validator) > 0 {
+		bc.accountState.Credit(b.Validator.Address(), gasUsed*tx.GasPrice+fee)
+	}
+
+	return newReceipt(tx, gasUsed, bc.events, execErr), nil
+}
+
+// executeTransaction runs the code, payload and transfer of tx in ctx. The
+// code may use up to gas units of gas, the gas it used is returned. The
+// events emitted by the code are collected in bc.events.
+func (bc *Blockchain) executeTransaction(tx *Transaction, ctx VMContext, gas uint64) (uint64, error) {
+	bc.events = nil
+
+	var gasUsed uint64
 	if len(tx.Data) > 0 {
 		bc.logger.Log("msg", "executing code", "len", len(tx.Data), "hash", tx.Hash(&TxHasher{}))
 
-		vm := NewVM(tx.Data, bc.contractState)
-		if err := vm.Run(); err != nil {
-			return err
+		vm := NewVM(tx.Data, bc.contractState, gas, ctx)
+		err := vm.Run()
+		gasUsed = vm.GasUsed()
+		if err != nil {
+			return gasUsed, err
 		}
+		bc.events = append(bc.events, vm.Events()...)
 	}
 
 	if tx.TxInner != nil {
-		if err := bc.handleNativeNFT(tx); err != nil {
-			return err
+		kind, err := LookupTxKind(tx.TxInner.Type())
+		if err != nil {
+			return gasUsed, err
+		}
+
+		payloadGas, err := kind.Execute(bc, tx, ctx, gas-gasUsed)
+		gasUsed += payloadGas
+		if err != nil {
+			return gasUsed, err
 		}
 	}
 
-	if tx.Value > 0 {
+	// Calls transfer their value to the contract themselves.
+	if tx.Value > 0 && tx.Type() != TxTypeCall {
 		if err := bc.handleNativeTransfer(tx); err != nil {
-			return err
+			return gasUsed, err
 		}
 	}
 
-	return nil
+	return gasUsed, nil
 }
 
 func (bc *Blockchain) addBlockWithoutValidation(b *Block) error {
-	bc.stateLock.Lock()
+	return bc.addBlock(b, false)
+}
 
-	for i := 0; i < len(b.Transactions); i++ {
-		tx := b.Transactions[i]
-		if err := bc.handleTransaction(tx); err != nil {
-			bc.logger.Log("error", err)
+// addBlock applies b atomically: the state changes are journaled together
+// with the block in the WAL before the block is stored, and reverted if
+// anything fails on the way, including a state root that does not match the
+// header when validateState is set.
+func (bc *Blockchain) addBlock(b *Block, validateState bool) error {
+	bc.stateLock.Lock()
+	defer bc.stateLock.Unlock()
 
-			b.Transactions[i] = b.Transactions[len(b.Transactions)-1]
-			b.Transactions = b.Transactions[:len(b.Transactions)-1]
+	bc.journal.begin()
+	defer bc.journal.finish()
 
-			continue
-		}
+	receipts, err := bc.executeBlock(b)
+	if err != nil {
+		return err
 	}
 
-	defer bc.stateLock.Unlock()
-
-	bc.lock.Lock()
-	bc.headers = append(bc.headers, b.Header)
-	bc.blocks = append(bc.blocks, b)
-	bc.blockStore[b.Hash(BlockHasher{})] = b
-
-	for _, tx := range b.Transactions {
-		bc.txStore[tx.Hash(TxHasher{})] = tx
+	if validateState {
+		err = bc.validator.ValidateState(b, bc.stateRootWithoutLock(), CalculateReceiptsRoot(receipts))
+	}
+	if err == nil {
+		err = bc.commitBlock(b, receipts)
+	}
+	if err != nil {
+		if rerr := bc.journal.revertToSnapshot(0); rerr != nil {
+			return fmt.Errorf("failed to revert block (%s) after %s: %w", b.Hash(BlockHasher{}), err, rerr)
+		}
+		return err
 	}
 
-	defer bc.lock.Unlock()
+	bc.appendBlock(b)
+	bc.snapshotForTracing(b.Height)
 
 	bc.logger.Log(
 		"msg", "new block",
@@ -209,5 +560,94 @@ func (bc *Blockchain) addBlockWithoutValidation(b *Block) error {
 		"transactions", len(b.Transactions),
 	)
 
-	return bc.store.Put(b)
+	return nil
+}
+
+func (bc *Blockchain) commitBlock(b *Block, receipts []*Receipt) error {
+	if bc.wal != nil {
+		if err := bc.wal.Write(&WALRecord{Block: b, Changes: bc.journal.changes(), Receipts: receipts}); err != nil {
+			return err
+		}
+	}
+
+	if err := bc.store.Put(b, receipts); err != nil {
+		if bc.wal != nil {
+			if werr := bc.wal.Clear(); werr != nil {
+				bc.logger.Log("error", "failed to clear WAL", "err", werr)
+			}
+		}
+		return err
+	}
+
+	// The block is durable at this point. Should clearing fail, recovery
+	// finds the block in the storage and only verifies it.
+	if bc.wal != nil {
+		if err := bc.wal.Clear(); err != nil {
+			bc.logger.Log("error", "failed to clear WAL", "err", err)
+		}
+	}
+
+	return nil
+}
+
+// executeBlock runs the transactions of b against the current state and
+// returns their receipts. A transaction that cannot be paid for makes the
+// whole block invalid, the transactions of a block are covered by its data
+// hash and never change after it was built. Must be called with the
+// stateLock held and the journal active.
+func (bc *Blockchain) executeBlock(b *Block) ([]*Receipt, error) {
+	receipts := make([]*Receipt, 0, len(b.Transactions))
+
+	for _, tx := range b.Transactions {
+		receipt, err := bc.handleTransaction(tx, b)
+		if err != nil {
+			return nil, fmt.Errorf("%w (%s) in block (%s): %w", ErrInvalidTransaction, tx.Hash(TxHasher{}), b.Hash(BlockHasher{}), err)
+		}
+
+		receipts = append(receipts, receipt)
+	}
+
+	return receipts, nil
+}
+
+// selectTransactions runs the transactions of a block that is being built
+// and drops the ones that cannot be paid for, reverting their state changes.
+// It returns the remaining transactions and their receipts. Must be called
+// with the stateLock held and the journal active.
+func (bc *Blockchain) selectTransactions(b *Block) ([]*Transaction, []*Receipt, error) {
+	// Never filter in place, the slice may be shared with the mempool.
+	txx := make([]*Transaction, 0, len(b.Transactions))
+	receipts := make([]*Receipt, 0, len(b.Transactions))
+
+	for _, tx := range b.Transactions {
+		snapshot := bc.journal.snapshot()
+
+		receipt, err := bc.handleTransaction(tx, b)
+		if err != nil {
+			bc.logger.Log("msg", "dropping transaction", "hash", tx.Hash(TxHasher{}), "err", err)
+
+			if err := bc.journal.revertToSnapshot(snapshot); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+
+		txx = append(txx, tx)
+		receipts = append(receipts, receipt)
+	}
+
+	return txx, receipts, nil
+}
+
+func (bc *Blockchain) appendBlock(b *Block) {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	bc.headers = append(bc.headers, b.Header)
+
+	for _, tx := range b.Transactions {
+		hash := tx.Hash(TxHasher{})
+		bc.txStore[hash] = tx
+		bc.txHeights[hash] = b.Height
+	}
 }
@@ -1,6 +1,7 @@
 package core
 
 import (
+	"errors"
 	"os"
 	"testing"
 
@@ -23,6 +24,7 @@ func TestSendNativeTransferTampered(t *testing.T) {
 	bc.accountState.CreateAccountWithBalance(alice.PublicKey().Address(), 1_000_000)
 
 	tx := NewTransaction(nil)
+	tx.GasLimit = TxBaseGas
 	tx.From = alice.PublicKey()
 	tx.To = bob.PublicKey()
 	tx.Value = 1_000
@@ -34,7 +36,7 @@ func TestSendNativeTransferTampered(t *testing.T) {
 	tx.To = hacker.PublicKey()
 
 	block.AddTransaction(tx)
-	assert.NotNil(t, bc.AddBlock(block))
+	assert.NotNil(t, bc.AddBlock(prepareBlock(t, bc, block)))
 
 	_, err := bc.accountState.GetAccount(bob.PublicKey().Address())
 	assert.EqualError(t, ErrAccountNotFound, err.Error())
@@ -53,19 +55,65 @@ func TestSendNativeTransferSuccess(t *testing.T) {
 	bc.accountState.CreateAccountWithBalance(alice.PublicKey().Address(), 1_000_000)
 
 	tx := NewTransaction(nil)
+	tx.GasLimit = TxBaseGas
 	tx.From = alice.PublicKey()
 	tx.To = bob.PublicKey()
 	tx.Value = 1_000
 
 	assert.Nil(t, tx.Sign(alice))
 	block.AddTransaction(tx)
-	assert.Nil(t, bc.AddBlock(block))
+	assert.Nil(t, bc.AddBlock(prepareBlock(t, bc, block)))
 
 	balance, err := bc.accountState.GetBalance(bob.PublicKey().Address())
 	assert.Nil(t, err)
 	assert.Equal(t, uint64(1_000), balance)
 }
 
+func TestSendNativeTransferReplay(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+
+	bob := crypto.GeneratePrivateKey()
+	alice := crypto.GeneratePrivateKey()
+
+	bc.accountState.CreateAccountWithBalance(alice.PublicKey().Address(), 1_000_000)
+
+	tx := NewTransaction(nil)
+	tx.GasLimit = TxBaseGas
+	tx.To = bob.PublicKey()
+	tx.Value = 1_000
+	assert.Nil(t, tx.Sign(alice))
+
+	block := randomBlock(t, uint32(1), getPrevBlockHash(t, bc, uint32(1)))
+	block.AddTransaction(tx)
+	assert.Nil(t, bc.AddBlock(prepareBlock(t, bc, block)))
+	assert.Equal(t, uint64(1), bc.GetNonce(alice.PublicKey().Address()))
+
+	// Resubmitting the same signed transfer must not move funds again.
+	replay := randomBlock(t, uint32(2), getPrevBlockHash(t, bc, uint32(2)))
+	replay.AddTransaction(tx)
+	assert.Nil(t, bc.AddBlock(prepareBlock(t, bc, replay)))
+	assert.Len(t, replay.Transactions, 1)
+
+	balance, err := bc.accountState.GetBalance(bob.PublicKey().Address())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1_000), balance)
+
+	next := NewTransaction(nil)
+	next.GasLimit = TxBaseGas
+	next.To = bob.PublicKey()
+	next.Value = 1_000
+	next.Nonce = 1
+	assert.Nil(t, next.Sign(alice))
+
+	block = randomBlock(t, uint32(3), getPrevBlockHash(t, bc, uint32(3)))
+	block.AddTransaction(next)
+	assert.Nil(t, bc.AddBlock(prepareBlock(t, bc, block)))
+
+	balance, err = bc.accountState.GetBalance(bob.PublicKey().Address())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2_000), balance)
+}
+
 func TestSendNativeTransferFailNotFound(t *testing.T) {
 	bc := newBlockchainWithGenesis(t)
 
@@ -79,13 +127,14 @@ func TestSendNativeTransferFailNotFound(t *testing.T) {
 	// bc.accountState.CreateAccountWithBalance(alice.PublicKey().Address(), 1_000_000)
 
 	tx := NewTransaction(nil)
+	tx.GasLimit = TxBaseGas
 	tx.From = alice.PublicKey()
 	tx.To = bob.PublicKey()
 	tx.Value = 2_000
 
 	assert.Nil(t, tx.Sign(alice))
 	block.AddTransaction(tx)
-	assert.Nil(t, bc.AddBlock(block))
+	assert.Nil(t, bc.AddBlock(prepareBlock(t, bc, block)))
 
 	hash := tx.Hash(TxHasher{})
 	_, err := bc.GetTxByHash(hash)
@@ -104,6 +153,7 @@ func TestSendNativeTransferFailInsufficient(t *testing.T) {
 
 	bc.accountState.CreateAccountWithBalance(bob.PublicKey().Address(), 1_000)
 	tx := NewTransaction(nil)
+	tx.GasLimit = TxBaseGas
 	tx.From = bob.PublicKey()
 	tx.To = alice.PublicKey()
 	tx.Value = 2_000
@@ -111,7 +161,7 @@ func TestSendNativeTransferFailInsufficient(t *testing.T) {
 	assert.Nil(t, tx.Sign(bob))
 	tx.hash = types.Hash{}
 	block.AddTransaction(tx)
-	assert.Nil(t, bc.AddBlock(block))
+	assert.Nil(t, bc.AddBlock(prepareBlock(t, bc, block)))
 
 	hash := tx.Hash(TxHasher{})
 	_, err := bc.GetTxByHash(hash)
@@ -124,7 +174,7 @@ func TestAddBlock(t *testing.T) {
 	lenBlocks := 1000
 	for i := 0; i < lenBlocks; i++ {
 		block := randomBlock(t, uint32(i+1), getPrevBlockHash(t, bc, uint32(i+1)))
-		assert.Nil(t, bc.AddBlock(block))
+		assert.Nil(t, bc.AddBlock(prepareBlock(t, bc, block)))
 	}
 
 	assert.Equal(t, bc.Height(), uint32(lenBlocks))
@@ -150,7 +200,7 @@ func TestGetBlock(t *testing.T) {
 	lenBlocks := 100
 	for i := 0; i < lenBlocks; i++ {
 		block := randomBlock(t, uint32(i+1), getPrevBlockHash(t, bc, uint32(i+1)))
-		assert.Nil(t, bc.AddBlock(block))
+		assert.Nil(t, bc.AddBlock(prepareBlock(t, bc, block)))
 		fetchBlock, err := bc.GetBlock(uint32(i + 1))
 		assert.Nil(t, err)
 		assert.Equal(t, fetchBlock, block)
@@ -162,17 +212,89 @@ func TestGetHeader(t *testing.T) {
 	lenBlocks := 1000
 	for i := 0; i < lenBlocks; i++ {
 		block := randomBlock(t, uint32(i+1), getPrevBlockHash(t, bc, uint32(i+1)))
-		assert.Nil(t, bc.AddBlock(block))
+		assert.Nil(t, bc.AddBlock(prepareBlock(t, bc, block)))
 		header, err := bc.GetHeader(uint32(i + 1))
 		assert.Nil(t, err)
 		assert.Equal(t, header, block.Header)
 	}
 }
 
+func TestOpenBlockchainReplay(t *testing.T) {
+	dir := t.TempDir()
+
+	bc, err := OpenBlockchain(log.NewNopLogger(), dir, randomBlock(t, 0, types.Hash{}))
+	assert.Nil(t, err)
+
+	lenBlocks := 10
+	for i := 0; i < lenBlocks; i++ {
+		block := randomBlock(t, uint32(i+1), getPrevBlockHash(t, bc, uint32(i+1)))
+		assert.Nil(t, bc.AddBlock(prepareBlock(t, bc, block)))
+	}
+	head, err := bc.GetHeader(bc.Height())
+	assert.Nil(t, err)
+	assert.Nil(t, bc.Close())
+
+	bc, err = OpenBlockchain(log.NewNopLogger(), dir, randomBlock(t, 0, types.Hash{}))
+	assert.Nil(t, err)
+	defer bc.Close()
+
+	assert.Equal(t, uint32(lenBlocks), bc.Height())
+	replayedHead, err := bc.GetHeader(bc.Height())
+	assert.Nil(t, err)
+	assert.Equal(t, BlockHasher{}.Hash(head), BlockHasher{}.Hash(replayedHead))
+
+	block := randomBlock(t, uint32(lenBlocks+1), getPrevBlockHash(t, bc, uint32(lenBlocks+1)))
+	assert.Nil(t, bc.AddBlock(prepareBlock(t, bc, block)))
+}
+
+func TestReplayRejectsDivergedState(t *testing.T) {
+	store := NewMemoryStore()
+	genesis := randomBlock(t, 0, types.Hash{})
+
+	bc, err := NewBlockchainWithStorage(log.NewNopLogger(), store, genesis)
+	assert.Nil(t, err)
+	for i := uint32(1); i <= 3; i++ {
+		assert.Nil(t, bc.AddBlock(prepareBlock(t, bc, randomBlock(t, i, getPrevBlockHash(t, bc, i)))))
+	}
+
+	_, err = NewBlockchainWithStorage(log.NewNopLogger(), store, genesis)
+	assert.Nil(t, err)
+
+	// The stored block claims a state the replay does not arrive at.
+	b, err := store.Get(2)
+	assert.Nil(t, err)
+	b.StateRoot = types.Hash{0x01}
+
+	_, err = NewBlockchainWithStorage(log.NewNopLogger(), store, genesis)
+	assert.ErrorIs(t, err, ErrInvalidStateRoot)
+}
+
+func TestAddBlockWrongChainID(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+
+	block := randomBlock(t, 1, getPrevBlockHash(t, bc, 1))
+	block.ChainID = 7
+	assert.ErrorIs(t, bc.AddBlock(prepareBlock(t, bc, block)), ErrInvalidChainID)
+
+	tx := NewTransaction([]byte("foo"))
+	tx.GasLimit = intrinsicGas(t, tx)
+	tx.ChainID = 7
+	assert.Nil(t, tx.Sign(crypto.GeneratePrivateKey()))
+
+	block = randomBlock(t, 1, getPrevBlockHash(t, bc, 1))
+	block.AddTransaction(tx)
+	assert.Nil(t, block.Sign(crypto.GeneratePrivateKey()))
+	assert.ErrorIs(t, bc.AddBlock(block), ErrInvalidChainID)
+
+	// A block producer drops transactions of other chains.
+	assert.Nil(t, bc.AddBlock(prepareBlock(t, bc, block)))
+	assert.Len(t, block.Transactions, 1)
+}
+
 func TestAddBlockToHeigh(t *testing.T) {
 	bc := newBlockchainWithGenesis(t)
 
-	assert.Nil(t, bc.AddBlock(randomBlock(t, 1, getPrevBlockHash(t, bc, uint32(1)))))
+	assert.Nil(t, bc.AddBlock(prepareBlock(t, bc, randomBlock(t, 1, getPrevBlockHash(t, bc, uint32(1))))))
 	assert.NotNil(t, bc.AddBlock(randomBlock(t, 3, types.Hash{})))
 }
 
@@ -188,3 +310,129 @@ func getPrevBlockHash(t *testing.T, bc *Blockchain, height uint32) types.Hash {
 
 	return BlockHasher{}.Hash(prevHeader)
 }
+
+func prepareBlock(t *testing.T, bc *Blockchain, b *Block) *Block {
+	privKey := crypto.GeneratePrivateKey()
+	b.Validator = privKey.PublicKey()
+	assert.Nil(t, bc.PrepareBlock(b))
+	assert.Nil(t, b.Sign(privKey))
+
+	return b
+}
+
+func TestTransactionFees(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+
+	bob := crypto.GeneratePrivateKey()
+	alice := crypto.GeneratePrivateKey()
+	bc.accountState.CreateAccountWithBalance(alice.PublicKey().Address(), 1_000_000)
+
+	tx := NewTransaction(nil)
+	tx.To = bob.PublicKey()
+	tx.Value = 1_000
+	tx.GasLimit = 2 * TxBaseGas
+	tx.GasPrice = 3
+	assert.Nil(t, tx.Sign(alice))
+
+	block := randomBlock(t, 1, getPrevBlockHash(t, bc, 1))
+	block.AddTransaction(tx)
+	assert.Nil(t, bc.AddBlock(prepareBlock(t, bc, block)))
+
+	// Only the intrinsic gas is used, the rest of the limit is refunded.
+	assert.Equal(t, uint64(1_000_000-1_000-3*TxBaseGas), bc.GetBalance(alice.PublicKey().Address()))
+	assert.Equal(t, uint64(1_000), bc.GetBalance(bob.PublicKey().Address()))
+	assert.Equal(t, uint64(3*TxBaseGas), bc.GetBalance(block.Validator.Address()))
+}
+
+func TestTransactionFeesRejectUnpaid(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+
+	alice := crypto.GeneratePrivateKey()
+	bc.accountState.CreateAccountWithBalance(alice.PublicKey().Address(), 1_000)
+
+	tooLow := NewTransaction(nil)
+	tooLow.GasLimit = TxBaseGas - 1
+	assert.Nil(t, tooLow.Sign(alice))
+
+	tooExpensive := NewTransaction(nil)
+	tooExpensive.GasLimit = TxBaseGas
+	tooExpensive.GasPrice = 2
+	assert.Nil(t, tooExpensive.Sign(alice))
+
+	block := randomBlock(t, 1, getPrevBlockHash(t, bc, 1))
+	block.AddTransaction(tooLow)
+	block.AddTransaction(tooExpensive)
+	assert.Nil(t, bc.AddBlock(prepareBlock(t, bc, block)))
+	assert.Len(t, block.Transactions, 1)
+
+	assert.Equal(t, uint64(1_000), bc.GetBalance(alice.PublicKey().Address()))
+	assert.Equal(t, uint64(0), bc.GetNonce(alice.PublicKey().Address()))
+}
+
+func TestAddBlockRejectsUnpaidTransaction(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+
+	alice := crypto.GeneratePrivateKey()
+	tx := NewTransaction(nil)
+	tx.GasLimit = TxBaseGas
+	tx.GasPrice = 1
+	assert.Nil(t, tx.Sign(alice))
+
+	signer := crypto.GeneratePrivateKey()
+	block := randomBlock(t, 1, getPrevBlockHash(t, bc, 1))
+	block.AddTransaction(tx)
+	dataHash, err := CalculateDataHash(block.Transactions)
+	assert.Nil(t, err)
+	block.DataHash = dataHash
+	assert.Nil(t, block.Sign(signer))
+
+	assert.True(t, errors.Is(bc.AddBlock(block), ErrInsufficientBalance))
+	assert.Len(t, block.Transactions, 2)
+	assert.Equal(t, uint32(0), bc.Height())
+}
+
+func TestTransactionFeesChargedOnFailure(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+
+	alice := crypto.GeneratePrivateKey()
+	bc.accountState.CreateAccountWithBalance(alice.PublicKey().Address(), 100_000)
+
+	// Minting into a collection that does not exist fails during execution.
+	tx := &Transaction{
+		TxInner: MintTx{Fee: 50, NFT: types.Hash{0x01}, Collection: types.Hash{0x02}},
+	}
+	tx.GasLimit = intrinsicGas(t, tx)
+	tx.GasPrice = 1
+	assert.Nil(t, tx.Sign(alice))
+
+	block := randomBlock(t, 1, getPrevBlockHash(t, bc, 1))
+	block.AddTransaction(tx)
+	assert.Nil(t, bc.AddBlock(prepareBlock(t, bc, block)))
+	assert.Len(t, block.Transactions, 2)
+
+	_, ok := bc.nftState.GetMint(tx.Hash(TxHasher{}))
+	assert.False(t, ok)
+	assert.Equal(t, uint64(100_000-50-tx.GasLimit), bc.GetBalance(alice.PublicKey().Address()))
+	assert.Equal(t, uint64(50+tx.GasLimit), bc.GetBalance(block.Validator.Address()))
+}
+
+func TestTransactionOutOfGasChargesGasLimit(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+
+	alice := crypto.GeneratePrivateKey()
+	bc.accountState.CreateAccountWithBalance(alice.PublicKey().Address(), 100_000)
+
+	tx := contractBlock(t, 1, getPrevBlockHash(t, bc, 1)).Transactions[0]
+	tx.GasLimit = intrinsicGas(t, tx) + 10
+	tx.GasPrice = 1
+	assert.Nil(t, tx.Sign(alice))
+
+	block := randomBlock(t, 1, getPrevBlockHash(t, bc, 1))
+	block.AddTransaction(tx)
+	assert.Nil(t, bc.AddBlock(prepareBlock(t, bc, block)))
+	assert.Len(t, block.Transactions, 2)
+
+	_, err := bc.contractState.Get([]byte("FOO"))
+	assert.NotNil(t, err)
+	assert.Equal(t, uint64(100_000)-tx.GasLimit, bc.GetBalance(alice.PublicKey().Address()))
+}
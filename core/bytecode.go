@@ -0,0 +1,255 @@
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Contract code starting with CodeMagic is versioned: the second byte is the
+// code version and the instructions follow. Anything else is legacy code.
+//
+// Legacy code reads the operand of InstrPushInt and InstrPushByte from the
+// byte before the instruction, and executes every byte, including operands.
+// Version 1 code reads immediates forward from the bytes after the
+// instruction and rejects unknown instructions.
+const (
+	CodeMagic byte = 0xef
+
+	CodeVersionLegacy byte = 0x00
+	CodeVersion1      byte = 0x01
+)
+
+// Version 1 push instructions. InstrPush1 to InstrPush32 push the unsigned
+// big endian integer of their 1 to 32 immediate bytes, which has to fit an
+// int64. InstrPushInt64 pushes a signed 64 bit integer and InstrPushBytes a
+// byte slice prefixed with its length as uint16.
+const (
+	InstrPushInt64 Instruction = 0x21
+	InstrPushBytes Instruction = 0x22
+
+	InstrPush1  Instruction = 0x60
+	InstrPush32 Instruction = 0x7f
+)
+
+var (
+	ErrInvalidCode   = errors.New("invalid contract code")
+	ErrInvalidOpcode = errors.New("invalid instruction")
+)
+
+// NewCode returns versioned code for the given instructions.
+func NewCode(version byte, instrs []byte) []byte {
+	return append([]byte{CodeMagic, version}, instrs...)
+}
+
+// ParseCode splits contract code into its version and instructions.
+func ParseCode(code []byte) (byte, []byte, error) {
+	if len(code) < 2 || code[0] != CodeMagic || code[1] == CodeVersionLegacy {
+		return CodeVersionLegacy, code, nil
+	}
+
+	if code[1] != CodeVersion1 {
+		return 0, nil, fmt.Errorf("%w: unknown version (%d)", ErrInvalidCode, code[1])
+	}
+
+	return code[1], code[2:], nil
+}
+
+// ImmediateSize returns the number of immediate bytes following the version
+// 1 instruction at pc.
+func ImmediateSize(code []byte, pc int) (int, error) {
+	instr := Instruction(code[pc])
+
+	switch {
+	case instr >= InstrPush1 && instr <= InstrPush32:
+		return int(instr-InstrPush1) + 1, nil
+	case instr == InstrPushInt64:
+		return 8, nil
+	case instr == InstrPushBytes:
+		if pc+3 > len(code) {
+			return 0, fmt.Errorf("%w: truncated length", ErrInvalidOperand)
+		}
+		return 2 + int(binary.BigEndian.Uint16(code[pc+1:pc+3])), nil
+	}
+
+	return 0, nil
+}
+
+// analyzeJumpDests marks the positions of code that are valid jump
+// destinations. Immediates of version 1 code are never destinations.
+func analyzeJumpDests(version byte, code []byte) []bool {
+	dests := make([]bool, len(code))
+
+	for pc := 0; pc < len(code); pc++ {
+		dests[pc] = Instruction(code[pc]) == InstrJumpDest
+
+		if version == CodeVersionLegacy {
+			continue
+		}
+
+		n, err := ImmediateSize(code, pc)
+		if err != nil {
+			break
+		}
+		pc += n
+	}
+
+	return dests
+}
+
+// immediate returns the n bytes following the current instruction and moves
+// the VM past them.
+func (vm *VM) immediate(n int) ([]byte, error) {
+	start := vm.ip + 1
+	if n < 0 || start+n > len(vm.data) {
+		return nil, fmt.Errorf("%w: truncated immediate", ErrInvalidOperand)
+	}
+
+	vm.next = start + n
+	return vm.data[start : start+n], nil
+}
+
+// execPush runs the version 1 push instructions and reports whether instr
+// was one of them.
+func (vm *VM) execPush(instr Instruction) (bool, error) {
+	switch {
+	case instr >= InstrPush1 && instr <= InstrPush32:
+		b, err := vm.immediate(int(instr-InstrPush1) + 1)
+		if err != nil {
+			return true, err
+		}
+
+		var v uint64
+		for i, c := range b {
+			if len(b)-i > 8 {
+				if c != 0 {
+					return true, fmt.Errorf("%w: immediate overflows int64", ErrInvalidOperand)
+				}
+				continue
+			}
+			v = v<<8 | uint64(c)
+		}
+		if v > 1<<63-1 {
+			return true, fmt.Errorf("%w: immediate overflows int64", ErrInvalidOperand)
+		}
+
+		return true, vm.push(Int(v))
+
+	case instr == InstrPushInt64:
+		b, err := vm.immediate(8)
+		if err != nil {
+			return true, err
+		}
+
+		return true, vm.push(Int(binary.BigEndian.Uint64(b)))
+
+	case instr == InstrPushBytes:
+		n, err := ImmediateSize(vm.data, vm.ip)
+		if err != nil {
+			return true, err
+		}
+		b, err := vm.immediate(n)
+		if err != nil {
+			return true, err
+		}
+		if err := vm.useGas(uint64(n-2) * GasPackByte); err != nil {
+			return true, err
+		}
+
+		return true, vm.push(Bytes(append([]byte(nil), b[2:]...)))
+	}
+
+	return false, nil
+}
+
+var instrNames = map[Instruction]string{
+	InstrPushInt:   "PUSH_INT",
+	InstrAdd:       "ADD",
+	InstrPushByte:  "PUSH_BYTE",
+	InstrPack:      "PACK",
+	InstrSub:       "SUB",
+	InstrStore:     "STORE",
+	InstrGet:       "GET",
+	InstrMul:       "MUL",
+	InstrDiv:       "DIV",
+	InstrEq:        "EQ",
+	InstrLt:        "LT",
+	InstrGt:        "GT",
+	InstrNot:       "NOT",
+	InstrAnd:       "AND",
+	InstrOr:        "OR",
+	InstrJump:      "JUMP",
+	InstrJumpI:     "JUMPI",
+	InstrJumpDest:  "JUMPDEST",
+	InstrDup:       "DUP",
+	InstrSwap:      "SWAP",
+	InstrPop:       "POP",
+	InstrHalt:      "HALT",
+	InstrReturn:    "RETURN",
+	InstrPushInt64: "PUSH_INT64",
+	InstrPushBytes: "PUSH_BYTES",
+
+	InstrCaller:      "CALLER",
+	InstrCallValue:   "CALLVALUE",
+	InstrHeight:      "HEIGHT",
+	InstrTimestamp:   "TIMESTAMP",
+	InstrSelfBalance: "SELFBALANCE",
+	InstrEmit:        "EMIT",
+	InstrCall:        "CALL",
+
+	InstrToInt:   "TOINT",
+	InstrToBytes: "TOBYTES",
+	InstrToBool:  "TOBOOL",
+	InstrTypeOf:  "TYPEOF",
+}
+
+var instrsByName = map[string]Instruction{}
+
+func init() {
+	for instr := InstrPush1; instr <= InstrPush32; instr++ {
+		instrNames[instr] = fmt.Sprintf("PUSH%d", instr-InstrPush1+1)
+	}
+
+	for instr, name := range instrNames {
+		instrsByName[name] = instr
+	}
+}
+
+// String returns the mnemonic of the instruction, or its hex value for
+// unknown instructions.
+func (i Instruction) String() string {
+	if name, ok := instrNames[i]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("0x%02x", byte(i))
+}
+
+// LookupInstruction returns the instruction with the given mnemonic.
+func LookupInstruction(name string) (Instruction, bool) {
+	instr, ok := instrsByName[name]
+	return instr, ok
+}
+
+// IsValid reports whether i is an instruction of the given code version.
+func (i Instruction) IsValid(version byte) bool {
+	if _, ok := instrNames[i]; !ok {
+		return false
+	}
+
+	if version == CodeVersionLegacy {
+		return !isVersion1Instr(i)
+	}
+
+	return i != InstrPushInt && i != InstrPushByte
+}
+
+// isVersion1Instr reports whether instr only exists in version 1 code,
+// which is every instruction after InstrDiv. Legacy code executes its
+// operands as well, so these bytes stay no-ops there.
+func isVersion1Instr(instr Instruction) bool {
+	return (instr >= InstrEq && instr <= InstrReturn) ||
+		instr == InstrPushInt64 || instr == InstrPushBytes ||
+		(instr >= InstrPush1 && instr <= InstrPush32) ||
+		isContextInstr(instr) || isConversionInstr(instr) || instr == InstrEmit || instr == InstrCall
+}
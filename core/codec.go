@@ -0,0 +1,638 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/k0yote/privatechain/crypto"
+	"github.com/k0yote/privatechain/types"
+)
+
+// CodecVersion is the first byte of every top level object written by the
+// binary codec.
+const CodecVersion byte = 2
+
+// MaxEncodedSize bounds the size of a single encoded object so a malformed
+// length prefix cannot make a decoder allocate arbitrary amounts of memory.
+const MaxEncodedSize = 32 << 20
+
+var (
+	ErrCodecVersion = errors.New("unsupported codec version")
+	ErrCodecLength  = errors.New("encoded length out of range")
+)
+
+// The binary codec is a deterministic, length-prefixed encoding: integers are
+// big endian with a fixed width, variable sized fields are prefixed with
+// their length as uint32 and optional fields with a presence byte. Top level
+// objects start with CodecVersion and are themselves prefixed with their
+// length when written to a stream.
+
+type codecWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *codecWriter) u8(v byte) {
+	w.buf.WriteByte(v)
+}
+
+func (w *codecWriter) u32(v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	w.buf.Write(b[:])
+}
+
+func (w *codecWriter) u64(v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	w.buf.Write(b[:])
+}
+
+func (w *codecWriter) bytes(v []byte) {
+	w.u32(uint32(len(v)))
+	w.buf.Write(v)
+}
+
+func (w *codecWriter) hash(h types.Hash) {
+	w.buf.Write(h[:])
+}
+
+func (w *codecWriter) address(a types.Address) {
+	w.buf.Write(a[:])
+}
+
+func (w *codecWriter) bigInt(v *big.Int) {
+	if v == nil {
+		w.u8(0)
+		return
+	}
+
+	w.u8(1)
+	w.bytes(v.Bytes())
+}
+
+func (w *codecWriter) signature(sig *crypto.Signature) {
+	if sig == nil {
+		w.u8(0)
+		return
+	}
+
+	w.u8(1)
+	w.bigInt(sig.R)
+	w.bigInt(sig.S)
+}
+
+type codecReader struct {
+	r   *bytes.Reader
+	err error
+}
+
+func newCodecReader(b []byte) *codecReader {
+	return &codecReader{r: bytes.NewReader(b)}
+}
+
+func (r *codecReader) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+func (r *codecReader) read(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n > r.r.Len() {
+		r.fail(io.ErrUnexpectedEOF)
+		return nil
+	}
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		r.fail(err)
+		return nil
+	}
+
+	return b
+}
+
+func (r *codecReader) u8() byte {
+	b := r.read(1)
+	if b == nil {
+		return 0
+	}
+
+	return b[0]
+}
+
+func (r *codecReader) u32() uint32 {
+	b := r.read(4)
+	if b == nil {
+		return 0
+	}
+
+	return binary.BigEndian.Uint32(b)
+}
+
+func (r *codecReader) u64() uint64 {
+	b := r.read(8)
+	if b == nil {
+		return 0
+	}
+
+	return binary.BigEndian.Uint64(b)
+}
+
+func (r *codecReader) bytes() []byte {
+	n := r.u32()
+	if r.err != nil {
+		return nil
+	}
+	if int64(n) > int64(r.r.Len()) {
+		r.fail(ErrCodecLength)
+		return nil
+	}
+	if n == 0 {
+		return nil
+	}
+
+	return r.read(int(n))
+}
+
+func (r *codecReader) hash() types.Hash {
+	b := r.read(32)
+	if b == nil {
+		return types.Hash{}
+	}
+
+	return types.HashFromBytes(b)
+}
+
+func (r *codecReader) address() types.Address {
+	b := r.read(20)
+	if b == nil {
+		return types.Address{}
+	}
+
+	return types.AddressFromBytes(b)
+}
+
+func (r *codecReader) present() bool {
+	switch r.u8() {
+	case 0:
+		return false
+	case 1:
+		return true
+	default:
+		r.fail(fmt.Errorf("invalid presence flag"))
+		return false
+	}
+}
+
+func (r *codecReader) bigInt() *big.Int {
+	if !r.present() {
+		return nil
+	}
+
+	b := r.bytes()
+	if len(b) > 0 && b[0] == 0 {
+		r.fail(fmt.Errorf("integer with leading zero byte"))
+		return nil
+	}
+
+	return new(big.Int).SetBytes(b)
+}
+
+func (r *codecReader) signature() *crypto.Signature {
+	if !r.present() {
+		return nil
+	}
+
+	sig := &crypto.Signature{
+		R: r.bigInt(),
+		S: r.bigInt(),
+	}
+	if r.err == nil && (sig.R == nil || sig.S == nil) {
+		r.fail(fmt.Errorf("signature without r or s"))
+		return nil
+	}
+
+	return sig
+}
+
+// publicKey reads a public key, which is either empty or a compressed point
+// on the curve.
+func (r *codecReader) publicKey() crypto.PublicKey {
+	k := crypto.PublicKey(r.bytes())
+	if r.err == nil && len(k) > 0 && !k.Valid() {
+		r.fail(fmt.Errorf("invalid public key"))
+		return nil
+	}
+
+	return k
+}
+
+// finish reports trailing bytes as an error, so every valid encoding has
+// exactly one byte representation.
+func (r *codecReader) finish() error {
+	if r.err == nil && r.r.Len() > 0 {
+		r.fail(fmt.Errorf("%d trailing bytes", r.r.Len()))
+	}
+
+	return r.err
+}
+
+func (r *codecReader) version() {
+	if v := r.u8(); r.err == nil && v != CodecVersion {
+		r.fail(fmt.Errorf("%w: %d", ErrCodecVersion, v))
+	}
+}
+
+func writeHeader(w *codecWriter, h *Header) {
+	w.u32(h.Version)
+	w.u64(h.ChainID)
+	w.hash(h.DataHash)
+	w.hash(h.StateRoot)
+	w.hash(h.ReceiptsRoot)
+	w.hash(h.PrevBlockHash)
+	w.u64(uint64(h.Timestamp))
+	w.u32(h.Height)
+	w.u64(h.Nonce)
+}
+
+func readHeader(r *codecReader) *Header {
+	return &Header{
+		Version:       r.u32(),
+		ChainID:       r.u64(),
+		DataHash:      r.hash(),
+		StateRoot:     r.hash(),
+		ReceiptsRoot:  r.hash(),
+		PrevBlockHash: r.hash(),
+		Timestamp:     int64(r.u64()),
+		Height:        r.u32(),
+		Nonce:         r.u64(),
+	}
+}
+
+func writeCollectionTx(w *codecWriter, tx *CollectionTx) {
+	w.u64(uint64(tx.Fee))
+	w.bytes(tx.MetaData)
+}
+
+func readCollectionTx(r *codecReader) CollectionTx {
+	return CollectionTx{
+		Fee:      int64(r.u64()),
+		MetaData: r.bytes(),
+	}
+}
+
+func writeMintTx(w *codecWriter, tx *MintTx) {
+	w.u64(uint64(tx.Fee))
+	w.hash(tx.NFT)
+	w.hash(tx.Collection)
+	w.bytes(tx.MetaData)
+	w.bytes(tx.CollectionOwner)
+	w.bigInt(tx.Signature.R)
+	w.bigInt(tx.Signature.S)
+}
+
+func readMintTx(r *codecReader) MintTx {
+	return MintTx{
+		Fee:             int64(r.u64()),
+		NFT:             r.hash(),
+		Collection:      r.hash(),
+		MetaData:        r.bytes(),
+		CollectionOwner: r.bytes(),
+		Signature: crypto.Signature{
+			R: r.bigInt(),
+			S: r.bigInt(),
+		},
+	}
+}
+
+func writeTransaction(w *codecWriter, tx *Transaction) error {
+	w.u8(byte(tx.Type()))
+	if tx.TxInner != nil {
+		payload, err := marshalPayload(tx.TxInner)
+		if err != nil {
+			return err
+		}
+		w.bytes(payload)
+	}
+
+	w.bytes(tx.Data)
+	w.bytes(tx.To)
+	w.u64(tx.Value)
+	w.bytes(tx.From)
+	w.signature(tx.Signature)
+	w.u64(tx.Nonce)
+	w.u64(tx.ChainID)
+	w.u64(tx.GasLimit)
+	w.u64(tx.GasPrice)
+
+	return nil
+}
+
+func readTransaction(r *codecReader, tx *Transaction) {
+	tx.TxInner = nil
+	if t := TxType(r.u8()); t != TxTypeNone && r.err == nil {
+		kind, err := LookupTxKind(t)
+		if err != nil {
+			r.fail(err)
+			return
+		}
+
+		b := r.bytes()
+		if r.err != nil {
+			return
+		}
+
+		payload, err := kind.Unmarshal(b)
+		if err != nil {
+			r.fail(err)
+			return
+		}
+		tx.TxInner = payload
+	}
+
+	tx.Data = r.bytes()
+	tx.To = r.bytes()
+	tx.Value = r.u64()
+	tx.From = r.publicKey()
+	tx.Signature = r.signature()
+	tx.Nonce = r.u64()
+	tx.ChainID = r.u64()
+	tx.GasLimit = r.u64()
+	tx.GasPrice = r.u64()
+	tx.hash = types.Hash{}
+}
+
+func writeBlock(w *codecWriter, b *Block) error {
+	writeHeader(w, b.Header)
+
+	w.u32(uint32(len(b.Transactions)))
+	for _, tx := range b.Transactions {
+		if err := writeTransaction(w, tx); err != nil {
+			return err
+		}
+	}
+
+	w.bytes(b.Validator)
+	w.signature(b.Signature)
+
+	return nil
+}
+
+func readBlock(r *codecReader, b *Block) {
+	b.Header = readHeader(r)
+
+	n := r.u32()
+	// Every transaction takes more than one byte, which bounds n by the
+	// remaining input.
+	if int64(n) > int64(r.r.Len()) {
+		r.fail(ErrCodecLength)
+		return
+	}
+
+	b.Transactions = nil
+	for i := uint32(0); i < n && r.err == nil; i++ {
+		tx := new(Transaction)
+		readTransaction(r, tx)
+		b.Transactions = append(b.Transactions, tx)
+	}
+
+	b.Validator = r.bytes()
+	b.Signature = r.signature()
+	b.hash = types.Hash{}
+}
+
+// writeReceipt writes the fields of r that are committed to by the receipts
+// root. The stored encoding appends Error.
+func writeReceipt(w *codecWriter, r *Receipt) {
+	w.hash(r.TxHash)
+	w.u8(byte(r.Status))
+	w.u64(r.GasUsed)
+
+	w.u32(uint32(len(r.Events)))
+	for _, e := range r.Events {
+		w.address(e.Address)
+		w.u8(byte(len(e.Topics)))
+		for _, topic := range e.Topics {
+			w.bytes(topic)
+		}
+		w.bytes(e.Data)
+	}
+}
+
+func readReceipt(r *codecReader) *Receipt {
+	receipt := &Receipt{
+		TxHash:  r.hash(),
+		Status:  ReceiptStatus(r.u8()),
+		GasUsed: r.u64(),
+	}
+
+	n := r.u32()
+	// Every event takes more than one byte, which bounds n by the remaining
+	// input.
+	if int64(n) > int64(r.r.Len()) {
+		r.fail(ErrCodecLength)
+		return receipt
+	}
+
+	for i := uint32(0); i < n && r.err == nil; i++ {
+		e := Event{Address: r.address()}
+
+		topics := r.u8()
+		if topics > MaxEventTopics {
+			r.fail(fmt.Errorf("event with %d topics", topics))
+			return receipt
+		}
+		for j := byte(0); j < topics; j++ {
+			e.Topics = append(e.Topics, r.bytes())
+		}
+		e.Data = r.bytes()
+
+		receipt.Events = append(receipt.Events, e)
+	}
+
+	return receipt
+}
+
+// MarshalReceipts returns the canonical encoding of the receipts of a block.
+func MarshalReceipts(receipts []*Receipt) []byte {
+	w := &codecWriter{}
+	w.u8(CodecVersion)
+	w.u32(uint32(len(receipts)))
+	for _, receipt := range receipts {
+		writeReceipt(w, receipt)
+		w.bytes([]byte(receipt.Error))
+	}
+
+	return w.buf.Bytes()
+}
+
+func UnmarshalReceipts(b []byte) ([]*Receipt, error) {
+	r := newCodecReader(b)
+	r.version()
+
+	n := r.u32()
+	if int64(n) > int64(r.r.Len()) {
+		r.fail(ErrCodecLength)
+	}
+
+	var receipts []*Receipt
+	for i := uint32(0); i < n && r.err == nil; i++ {
+		receipt := readReceipt(r)
+		receipt.Error = string(r.bytes())
+		receipts = append(receipts, receipt)
+	}
+
+	return receipts, r.finish()
+}
+
+// MarshalTransaction returns the canonical encoding of tx.
+func MarshalTransaction(tx *Transaction) ([]byte, error) {
+	w := &codecWriter{}
+	w.u8(CodecVersion)
+	if err := writeTransaction(w, tx); err != nil {
+		return nil, err
+	}
+
+	return w.buf.Bytes(), nil
+}
+
+func UnmarshalTransaction(b []byte, tx *Transaction) error {
+	r := newCodecReader(b)
+	r.version()
+	readTransaction(r, tx)
+
+	return r.finish()
+}
+
+// MarshalBlock returns the canonical encoding of b.
+func MarshalBlock(b *Block) ([]byte, error) {
+	w := &codecWriter{}
+	w.u8(CodecVersion)
+	if err := writeBlock(w, b); err != nil {
+		return nil, err
+	}
+
+	return w.buf.Bytes(), nil
+}
+
+func UnmarshalBlock(data []byte, b *Block) error {
+	r := newCodecReader(data)
+	r.version()
+	readBlock(r, b)
+
+	return r.finish()
+}
+
+func writeLengthPrefixed(w io.Writer, payload []byte) error {
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(payload)))
+
+	if _, err := w.Write(length[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+
+	return err
+}
+
+func readLengthPrefixed(r io.Reader) ([]byte, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+
+	n := binary.BigEndian.Uint32(length[:])
+	if n > MaxEncodedSize {
+		return nil, ErrCodecLength
+	}
+
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	return payload, nil
+}
+
+type BinaryTxEncoder struct {
+	w io.Writer
+}
+
+func NewBinaryTxEncoder(w io.Writer) *BinaryTxEncoder {
+	return &BinaryTxEncoder{
+		w: w,
+	}
+}
+
+func (e *BinaryTxEncoder) Encode(tx *Transaction) error {
+	b, err := MarshalTransaction(tx)
+	if err != nil {
+		return err
+	}
+
+	return writeLengthPrefixed(e.w, b)
+}
+
+type BinaryTxDecoder struct {
+	r io.Reader
+}
+
+func NewBinaryTxDecoder(r io.Reader) *BinaryTxDecoder {
+	return &BinaryTxDecoder{
+		r: r,
+	}
+}
+
+func (d *BinaryTxDecoder) Decode(tx *Transaction) error {
+	b, err := readLengthPrefixed(d.r)
+	if err != nil {
+		return err
+	}
+
+	return UnmarshalTransaction(b, tx)
+}
+
+type BinaryBlockEncoder struct {
+	w io.Writer
+}
+
+func NewBinaryBlockEncoder(w io.Writer) *BinaryBlockEncoder {
+	return &BinaryBlockEncoder{
+		w: w,
+	}
+}
+
+func (enc *BinaryBlockEncoder) Encode(b *Block) error {
+	data, err := MarshalBlock(b)
+	if err != nil {
+		return err
+	}
+
+	return writeLengthPrefixed(enc.w, data)
+}
+
+type BinaryBlockDecoder struct {
+	r io.Reader
+}
+
+func NewBinaryBlockDecoder(r io.Reader) *BinaryBlockDecoder {
+	return &BinaryBlockDecoder{
+		r: r,
+	}
+}
+
+func (dec *BinaryBlockDecoder) Decode(b *Block) error {
+	data, err := readLengthPrefixed(dec.r)
+	if err != nil {
+		return err
+	}
+
+	return UnmarshalBlock(data, b)
+}
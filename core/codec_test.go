@@ -0,0 +1,131 @@
+package core
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/k0yote/privatechain/crypto"
+	"github.com/k0yote/privatechain/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeaderBytesGolden(t *testing.T) {
+	h := &Header{
+		Version:       1,
+		ChainID:       7,
+		DataHash:      types.Hash{0x01},
+		StateRoot:     types.Hash{0x02},
+		ReceiptsRoot:  types.Hash{0x04},
+		PrevBlockHash: types.Hash{0x03},
+		Timestamp:     1_700_000_000,
+		Height:        42,
+		Nonce:         9,
+	}
+
+	expected := "02" + "00000001" + "0000000000000007" +
+		"01" + zeroHex(31) +
+		"02" + zeroHex(31) +
+		"04" + zeroHex(31) +
+		"03" + zeroHex(31) +
+		"000000006553f100" + "0000002a" + "0000000000000009"
+
+	assert.Equal(t, expected, hex.EncodeToString(h.Bytes()))
+}
+
+func TestCodecTxRoundTrip(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+
+	txs := []*Transaction{
+		randomTxWithSignature(t),
+		{
+			TxInner: CollectionTx{Fee: 200, MetaData: []byte("collection")},
+			Nonce:   1,
+			ChainID: 3,
+		},
+		{
+			TxInner: MintTx{
+				Fee:             100,
+				NFT:             types.Hash{0xaa},
+				Collection:      types.Hash{0xbb},
+				MetaData:        []byte("mint"),
+				CollectionOwner: privKey.PublicKey(),
+			},
+			To:    privKey.PublicKey(),
+			Value: 10,
+		},
+	}
+
+	for _, tx := range txs {
+		if tx.Signature == nil {
+			assert.Nil(t, tx.Sign(privKey))
+		}
+
+		buf := &bytes.Buffer{}
+		assert.Nil(t, tx.Encode(NewBinaryTxEncoder(buf)))
+		encoded := append([]byte(nil), buf.Bytes()...)
+
+		txDecoded := new(Transaction)
+		assert.Nil(t, txDecoded.Decode(NewBinaryTxDecoder(buf)))
+		assert.Equal(t, tx.Hash(TxHasher{}), txDecoded.Hash(TxHasher{}))
+		assert.Nil(t, txDecoded.Verify())
+
+		// Re-encoding the decoded transaction yields the same bytes.
+		buf.Reset()
+		assert.Nil(t, txDecoded.Encode(NewBinaryTxEncoder(buf)))
+		assert.Equal(t, encoded, buf.Bytes())
+	}
+}
+
+func TestCodecBlockRoundTrip(t *testing.T) {
+	b := randomBlock(t, 3, types.Hash{0x01})
+
+	buf := &bytes.Buffer{}
+	assert.Nil(t, b.Encode(NewBinaryBlockEncoder(buf)))
+
+	bDecode := new(Block)
+	assert.Nil(t, bDecode.Decode(NewBinaryBlockDecoder(buf)))
+	assert.Equal(t, b.Header, bDecode.Header)
+	assert.Equal(t, b.Hash(BlockHasher{}), bDecode.Hash(BlockHasher{}))
+	assert.Equal(t, len(b.Transactions), len(bDecode.Transactions))
+	assert.Nil(t, bDecode.Verify())
+}
+
+func TestCodecRejectsMalformedInput(t *testing.T) {
+	tx := randomTxWithSignature(t)
+	data, err := MarshalTransaction(tx)
+	assert.Nil(t, err)
+
+	assert.Nil(t, UnmarshalTransaction(data, new(Transaction)))
+	assert.NotNil(t, UnmarshalTransaction(append(data, 0x00), new(Transaction)))
+	assert.NotNil(t, UnmarshalTransaction(data[:len(data)-1], new(Transaction)))
+
+	badVersion := append([]byte(nil), data...)
+	badVersion[0] = CodecVersion + 1
+	assert.ErrorIs(t, UnmarshalTransaction(badVersion, new(Transaction)), ErrCodecVersion)
+
+	oversized := []byte{0xff, 0xff, 0xff, 0xff}
+	assert.ErrorIs(t, new(Transaction).Decode(NewBinaryTxDecoder(bytes.NewReader(oversized))), ErrCodecLength)
+}
+
+func zeroHex(n int) string {
+	return hex.EncodeToString(make([]byte, n))
+}
+
+func TestCodecTxRejectsMalformedKeyAndSignature(t *testing.T) {
+	tx := randomTxWithSignature(t)
+
+	malformed := *tx
+	malformed.From = append(crypto.PublicKey{0x02}, bytes.Repeat([]byte{0xff}, 32)...)
+	b, err := MarshalTransaction(&malformed)
+	assert.Nil(t, err)
+	assert.NotNil(t, UnmarshalTransaction(b, new(Transaction)))
+	assert.NotNil(t, malformed.Verify())
+
+	malformed = *tx
+	malformed.Signature = &crypto.Signature{S: tx.Signature.S}
+	b, err = MarshalTransaction(&malformed)
+	assert.Nil(t, err)
+	assert.NotNil(t, UnmarshalTransaction(b, new(Transaction)))
+	assert.NotNil(t, malformed.Verify())
+}
@@ -0,0 +1,228 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/k0yote/privatechain/types"
+)
+
+const (
+	deployGas uint64 = 10_000
+	callGas   uint64 = 1_000
+)
+
+var (
+	ErrContractExists   = errors.New("contract already exists")
+	ErrContractNotFound = errors.New("contract not found")
+)
+
+// ContractStorage is the key value store a VM runs against.
+type ContractStorage interface {
+	Get(key []byte) ([]byte, error)
+	Put(key, value []byte) error
+}
+
+// DeployTx stores Code under the address returned by ContractAddress.
+type DeployTx struct {
+	Code []byte
+}
+
+func (DeployTx) Type() TxType { return TxTypeDeploy }
+
+// CallTx runs the code of the contract at Contract against its own storage.
+// The value of the transaction is transferred to the contract.
+type CallTx struct {
+	Contract types.Address
+}
+
+func (CallTx) Type() TxType { return TxTypeCall }
+
+// ContractAddress returns the address of the contract deployed by from with
+// the given transaction nonce.
+func ContractAddress(from types.Address, nonce uint64) types.Address {
+	buf := make([]byte, 28)
+	copy(buf, from[:])
+	binary.BigEndian.PutUint64(buf[20:], nonce)
+
+	h := sha256.Sum256(buf)
+	return types.AddressFromBytes(h[len(h)-20:])
+}
+
+// contractStorage is the storage namespace of a single contract.
+type contractStorage struct {
+	state    *State
+	contract types.Address
+}
+
+func (s contractStorage) key(k []byte) []byte {
+	return append(s.contract.ToSlice(), k...)
+}
+
+func (s contractStorage) Get(k []byte) ([]byte, error) {
+	return s.state.Get(s.key(k))
+}
+
+func (s contractStorage) Put(k, v []byte) error {
+	return s.state.Put(s.key(k), v)
+}
+
+// vmHost gives the VM access to the contracts of bc. It is only used while
+// executing blocks, with the stateLock held.
+type vmHost struct {
+	bc *Blockchain
+}
+
+func (h vmHost) ContractCode(address types.Address) ([]byte, error) {
+	code, err := h.bc.contractCode.Get(address.ToSlice())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrContractNotFound, address)
+	}
+
+	return code, nil
+}
+
+func (h vmHost) ContractStorage(address types.Address) ContractStorage {
+	return contractStorage{h.bc.contractStorage, address}
+}
+
+func (h vmHost) Balance(address types.Address) uint64 {
+	balance, _ := h.bc.accountState.GetBalance(address)
+	return balance
+}
+
+// GetContractCode returns the code of the contract at address.
+func (bc *Blockchain) GetContractCode(address types.Address) ([]byte, error) {
+	bc.stateLock.RLock()
+	defer bc.stateLock.RUnlock()
+
+	code, err := bc.contractCode.Get(address.ToSlice())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrContractNotFound, address)
+	}
+
+	return code, nil
+}
+
+// GetContractStorage returns the value stored under key by the contract at
+// address.
+func (bc *Blockchain) GetContractStorage(address types.Address, key []byte) ([]byte, error) {
+	bc.stateLock.RLock()
+	defer bc.stateLock.RUnlock()
+
+	return contractStorage{bc.contractStorage, address}.Get(key)
+}
+
+func init() {
+	RegisterTxKind(&TxKind{
+		Type: TxTypeDeploy,
+		Name: "deploy",
+		Gas:  deployGas,
+		Marshal: func(p TxPayload) ([]byte, error) {
+			d, ok := p.(DeployTx)
+			if !ok {
+				return nil, fmt.Errorf("expected DeployTx, got %T", p)
+			}
+			w := &codecWriter{}
+			w.bytes(d.Code)
+			return w.buf.Bytes(), nil
+		},
+		Unmarshal: func(b []byte) (TxPayload, error) {
+			r := newCodecReader(b)
+			d := DeployTx{Code: r.bytes()}
+			return d, r.finish()
+		},
+		Validate: validateDeployTx,
+		Execute:  executeDeployTx,
+	})
+
+	RegisterTxKind(&TxKind{
+		Type: TxTypeCall,
+		Name: "call",
+		Gas:  callGas,
+		Marshal: func(p TxPayload) ([]byte, error) {
+			c, ok := p.(CallTx)
+			if !ok {
+				return nil, fmt.Errorf("expected CallTx, got %T", p)
+			}
+			w := &codecWriter{}
+			w.address(c.Contract)
+			return w.buf.Bytes(), nil
+		},
+		Unmarshal: func(b []byte) (TxPayload, error) {
+			r := newCodecReader(b)
+			c := CallTx{Contract: r.address()}
+			return c, r.finish()
+		},
+		Validate: validateCallTx,
+		Execute:  executeCallTx,
+	})
+}
+
+func validateDeployTx(tx *Transaction) error {
+	d := tx.TxInner.(DeployTx)
+	if len(d.Code) == 0 {
+		return fmt.Errorf("deploy without code")
+	}
+	if _, _, err := ParseCode(d.Code); err != nil {
+		return err
+	}
+	if len(tx.Data) > 0 || tx.Value > 0 {
+		return fmt.Errorf("deploy transactions cannot carry data or value")
+	}
+
+	return nil
+}
+
+func executeDeployTx(bc *Blockchain, tx *Transaction, _ VMContext, _ uint64) (uint64, error) {
+	d := tx.TxInner.(DeployTx)
+	address := ContractAddress(tx.From.Address(), tx.Nonce)
+
+	if _, err := bc.contractCode.Get(address.ToSlice()); err == nil {
+		return 0, fmt.Errorf("%w: %s", ErrContractExists, address)
+	}
+
+	if err := bc.contractCode.Put(address.ToSlice(), d.Code); err != nil {
+		return 0, err
+	}
+
+	bc.logger.Log("msg", "deployed contract", "address", address, "size", len(d.Code))
+
+	return 0, nil
+}
+
+func validateCallTx(tx *Transaction) error {
+	if len(tx.Data) > 0 || len(tx.To) > 0 {
+		return fmt.Errorf("call transactions cannot carry data or a recipient")
+	}
+
+	return nil
+}
+
+func executeCallTx(bc *Blockchain, tx *Transaction, ctx VMContext, gas uint64) (uint64, error) {
+	c := tx.TxInner.(CallTx)
+
+	code, err := bc.contractCode.Get(c.Contract.ToSlice())
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrContractNotFound, c.Contract)
+	}
+
+	if tx.Value > 0 {
+		if err := bc.accountState.Transfer(tx.From.Address(), c.Contract, tx.Value); err != nil {
+			return 0, err
+		}
+	}
+
+	ctx.Address = c.Contract
+	ctx.Balance, _ = bc.accountState.GetBalance(c.Contract)
+
+	vm := NewVM(code, contractStorage{bc.contractStorage, c.Contract}, gas, ctx)
+	if err := vm.Run(); err != nil {
+		return vm.GasUsed(), err
+	}
+	bc.events = append(bc.events, vm.Events()...)
+
+	return vm.GasUsed(), nil
+}
@@ -0,0 +1,268 @@
+package core
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/k0yote/privatechain/crypto"
+	"github.com/k0yote/privatechain/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// counterCode returns the value stored under "N".
+var counterCode = NewCode(CodeVersion1, []byte{
+	0x22, 0x00, 0x01, 'N', // PUSH_BYTES "N"
+	0x10, // GET
+	0x20, // RETURN
+})
+
+func contractTx(t *testing.T, privKey crypto.PrivateKey, nonce uint64, payload TxPayload) *Transaction {
+	tx := &Transaction{
+		TxInner: payload,
+		Nonce:   nonce,
+	}
+	tx.GasLimit = intrinsicGas(t, tx) + 10_000
+	assert.Nil(t, tx.Sign(privKey))
+
+	return tx
+}
+
+func TestDeployAndCallContract(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	privKey := crypto.GeneratePrivateKey()
+	from := privKey.PublicKey().Address()
+
+	// Stores 7 under "N".
+	store := NewCode(CodeVersion1, []byte{
+		0x60, 0x07, // PUSH1 7
+		0x22, 0x00, 0x01, 'N', // PUSH_BYTES "N"
+		0x0f, // STORE
+	})
+
+	block := randomBlock(t, 1, getPrevBlockHash(t, bc, 1))
+	block.AddTransaction(contractTx(t, privKey, 0, DeployTx{Code: store}))
+	block.AddTransaction(contractTx(t, privKey, 1, DeployTx{Code: store}))
+	assert.Nil(t, bc.AddBlock(prepareBlock(t, bc, block)))
+
+	first, second := ContractAddress(from, 0), ContractAddress(from, 1)
+	assert.NotEqual(t, first, second)
+
+	code, err := bc.GetContractCode(first)
+	assert.Nil(t, err)
+	assert.Equal(t, store, code)
+
+	block = randomBlock(t, 2, getPrevBlockHash(t, bc, 2))
+	block.AddTransaction(contractTx(t, privKey, 2, CallTx{Contract: first}))
+	assert.Nil(t, bc.AddBlock(prepareBlock(t, bc, block)))
+
+	// Only the storage of the called contract is written.
+	value, err := bc.GetContractStorage(first, []byte("N"))
+	assert.Nil(t, err)
+	assert.Equal(t, EncodeValue(Int(7)), value)
+
+	_, err = bc.GetContractStorage(second, []byte("N"))
+	assert.NotNil(t, err)
+	_, err = bc.contractState.Get([]byte("N"))
+	assert.NotNil(t, err)
+}
+
+func TestCallMissingContract(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	privKey := crypto.GeneratePrivateKey()
+
+	tx := contractTx(t, privKey, 0, CallTx{Contract: ContractAddress(privKey.PublicKey().Address(), 0)})
+	block := randomBlock(t, 1, getPrevBlockHash(t, bc, 1))
+	block.AddTransaction(tx)
+	assert.Nil(t, bc.AddBlock(prepareBlock(t, bc, block)))
+
+	// The call is paid for, so it stays in the block even though it failed.
+	assert.Len(t, block.Transactions, 2)
+	assert.Equal(t, uint64(1), bc.GetNonce(privKey.PublicKey().Address()))
+}
+
+func TestDeployTxValidation(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+
+	assert.NotNil(t, contractTx(t, privKey, 0, DeployTx{}).Verify())
+	assert.NotNil(t, contractTx(t, privKey, 0, DeployTx{Code: []byte{CodeMagic, 0x09}}).Verify())
+	assert.Nil(t, contractTx(t, privKey, 0, DeployTx{Code: counterCode}).Verify())
+}
+
+func TestCallContractContext(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	privKey := crypto.GeneratePrivateKey()
+	bc.accountState.CreateAccountWithBalance(privKey.PublicKey().Address(), 1_000_000)
+
+	// Stores the value, the balance and the block height of the call.
+	code := NewCode(CodeVersion1, []byte{
+		0x31,                  // CALLVALUE
+		0x22, 0x00, 0x01, 'V', // PUSH_BYTES "V"
+		0x0f,                  // STORE
+		0x34,                  // SELFBALANCE
+		0x22, 0x00, 0x01, 'B', // PUSH_BYTES "B"
+		0x0f,                  // STORE
+		0x32,                  // HEIGHT
+		0x22, 0x00, 0x01, 'H', // PUSH_BYTES "H"
+		0x0f, // STORE
+	})
+
+	block := randomBlock(t, 1, getPrevBlockHash(t, bc, 1))
+	block.AddTransaction(contractTx(t, privKey, 0, DeployTx{Code: code}))
+	assert.Nil(t, bc.AddBlock(prepareBlock(t, bc, block)))
+
+	contract := ContractAddress(privKey.PublicKey().Address(), 0)
+
+	tx := &Transaction{TxInner: CallTx{Contract: contract}, Nonce: 1, Value: 5}
+	tx.GasLimit = intrinsicGas(t, tx) + 10_000
+	assert.Nil(t, tx.Sign(privKey))
+
+	block = randomBlock(t, 2, getPrevBlockHash(t, bc, 2))
+	block.AddTransaction(tx)
+	assert.Nil(t, bc.AddBlock(prepareBlock(t, bc, block)))
+
+	for key, want := range map[string]Int{"V": 5, "B": 5, "H": 2} {
+		value, err := bc.GetContractStorage(contract, []byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, EncodeValue(want), value, key)
+	}
+}
+
+func TestContractCallsContract(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	privKey := crypto.GeneratePrivateKey()
+	from := privKey.PublicKey().Address()
+	callee := ContractAddress(from, 0)
+
+	block := randomBlock(t, 1, getPrevBlockHash(t, bc, 1))
+	block.AddTransaction(contractTx(t, privKey, 0, DeployTx{Code: doubleCode}))
+	block.AddTransaction(contractTx(t, privKey, 1, DeployTx{Code: callCode(callee, 21)}))
+	block.AddTransaction(contractTx(t, privKey, 2, CallTx{Contract: ContractAddress(from, 1)}))
+	assert.Nil(t, bc.AddBlock(prepareBlock(t, bc, block)))
+
+	value, err := bc.GetContractStorage(ContractAddress(from, 1), []byte("R"))
+	assert.Nil(t, err)
+	assert.Equal(t, EncodeValue(Int(42)), value)
+
+	value, err = bc.GetContractStorage(callee, []byte("X"))
+	assert.Nil(t, err)
+	assert.Equal(t, EncodeValue(Int(21)), value)
+}
+
+func TestTxDataCallerIsSender(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	privKey := crypto.GeneratePrivateKey()
+	from := privKey.PublicKey().Address()
+	callee := ContractAddress(from, 0)
+
+	// Stores its caller under "C".
+	callerCode := NewCode(CodeVersion1, []byte{
+		0x30,                  // CALLER
+		0x22, 0x00, 0x01, 'C', // PUSH_BYTES "C"
+		0x0f, // STORE
+	})
+
+	call := &Transaction{Data: callCode(callee, 0), Nonce: 1}
+	call.GasLimit = intrinsicGas(t, call) + 50_000
+	assert.Nil(t, call.Sign(privKey))
+
+	block := randomBlock(t, 1, getPrevBlockHash(t, bc, 1))
+	block.AddTransaction(contractTx(t, privKey, 0, DeployTx{Code: callerCode}))
+	block.AddTransaction(call)
+	assert.Nil(t, bc.AddBlock(prepareBlock(t, bc, block)))
+
+	value, err := bc.GetContractStorage(callee, []byte("C"))
+	assert.Nil(t, err)
+	assert.Equal(t, EncodeValue(Bytes(from.ToSlice())), value)
+}
+
+func TestTraceTransaction(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	bc.EnableTracing()
+	privKey := crypto.GeneratePrivateKey()
+	from := privKey.PublicKey().Address()
+	contract := ContractAddress(from, 0)
+
+	block := randomBlock(t, 1, getPrevBlockHash(t, bc, 1))
+	block.AddTransaction(contractTx(t, privKey, 0, DeployTx{Code: doubleCode}))
+	block.AddTransaction(contractTx(t, privKey, 1, DeployTx{Code: callCode(contract, 21)}))
+	assert.Nil(t, bc.AddBlock(prepareBlock(t, bc, block)))
+
+	tx := contractTx(t, privKey, 2, CallTx{Contract: ContractAddress(from, 1)})
+	block = randomBlock(t, 2, getPrevBlockHash(t, bc, 2))
+	block.AddTransaction(tx)
+	assert.Nil(t, bc.AddBlock(prepareBlock(t, bc, block)))
+
+	hash := tx.Hash(TxHasher{})
+	trace, err := bc.TraceTransaction(hash)
+	assert.Nil(t, err)
+
+	receipt, err := bc.GetReceipt(hash)
+	assert.Nil(t, err)
+	assert.Equal(t, receipt, trace.Receipt)
+
+	depths := map[int]bool{}
+	for _, step := range trace.Steps {
+		depths[step.Depth] = true
+	}
+	assert.Equal(t, map[int]bool{0: true, 1: true}, depths)
+	assert.NotEmpty(t, trace.Storage)
+
+	// Tracing does not change the chain.
+	value, err := bc.GetContractStorage(ContractAddress(from, 1), []byte("R"))
+	assert.Nil(t, err)
+	assert.Equal(t, EncodeValue(Int(42)), value)
+
+	_, err = bc.TraceTransaction(types.Hash{})
+	assert.NotNil(t, err)
+}
+
+func TestTraceTransactionDisabled(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	privKey := crypto.GeneratePrivateKey()
+
+	tx := contractTx(t, privKey, 0, DeployTx{Code: doubleCode})
+	block := randomBlock(t, 1, getPrevBlockHash(t, bc, 1))
+	block.AddTransaction(tx)
+	assert.Nil(t, bc.AddBlock(prepareBlock(t, bc, block)))
+
+	_, err := bc.TraceTransaction(tx.Hash(TxHasher{}))
+	assert.True(t, errors.Is(err, ErrTracingDisabled), err)
+}
+
+func TestTraceTransactionFromSnapshot(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	privKey := crypto.GeneratePrivateKey()
+	from := privKey.PublicKey().Address()
+	contract := ContractAddress(from, 0)
+
+	block := randomBlock(t, 1, getPrevBlockHash(t, bc, 1))
+	block.AddTransaction(contractTx(t, privKey, 0, DeployTx{Code: doubleCode}))
+	block.AddTransaction(contractTx(t, privKey, 1, DeployTx{Code: callCode(contract, 21)}))
+	assert.Nil(t, bc.AddBlock(prepareBlock(t, bc, block)))
+	bc.EnableTracing()
+
+	nonce := uint64(2)
+	txs := []*Transaction{}
+	for h := uint32(2); h <= (maxTraceSnapshots+1)*traceSnapshotInterval; h++ {
+		tx := contractTx(t, privKey, nonce, CallTx{Contract: ContractAddress(from, 1)})
+		nonce++
+		txs = append(txs, tx)
+
+		block := randomBlock(t, h, getPrevBlockHash(t, bc, h))
+		block.AddTransaction(tx)
+		assert.Nil(t, bc.AddBlock(prepareBlock(t, bc, block)))
+	}
+	assert.Len(t, bc.traceSnapshots, maxTraceSnapshots)
+
+	// The snapshot taken when tracing was enabled was dropped.
+	_, err := bc.TraceTransaction(txs[0].Hash(TxHasher{}))
+	assert.NotNil(t, err)
+
+	hash := txs[len(txs)-1].Hash(TxHasher{})
+	trace, err := bc.TraceTransaction(hash)
+	assert.Nil(t, err)
+	receipt, err := bc.GetReceipt(hash)
+	assert.Nil(t, err)
+	assert.Equal(t, receipt, trace.Receipt)
+	assert.NotEmpty(t, trace.Steps)
+}
@@ -0,0 +1,381 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/k0yote/privatechain/types"
+)
+
+const (
+	defaultMaxSegmentSize = 64 << 20
+
+	indexFileName = "index.dat"
+	// hash (32) + segment (4) + offset (8) + length (4)
+	indexEntrySize = 48
+	// length (4) + crc32 checksum (4)
+	recordHeaderSize = 8
+)
+
+var ErrCorruptRecord = errors.New("corrupt block record")
+
+type blockLocation struct {
+	hash    types.Hash
+	segment uint32
+	offset  int64
+	length  uint32
+}
+
+// FileStore keeps blocks in append-only segment files inside dir. Every
+// stored block gets a fixed size entry in the index file, so the entry of
+// block n lives at offset n*indexEntrySize. A record holds the length
+// prefixed block followed by its length prefixed receipts.
+type FileStore struct {
+	mu             sync.RWMutex
+	dir            string
+	maxSegmentSize int64
+
+	index       *os.File
+	segment     *os.File
+	segmentID   uint32
+	segmentSize int64
+
+	locations []blockLocation
+	hashes    map[types.Hash]uint32
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	return NewFileStoreWithSegmentSize(dir, defaultMaxSegmentSize)
+}
+
+func NewFileStoreWithSegmentSize(dir string, maxSegmentSize int64) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	s := &FileStore{
+		dir:            dir,
+		maxSegmentSize: maxSegmentSize,
+		locations:      []blockLocation{},
+		hashes:         make(map[types.Hash]uint32),
+	}
+
+	if err := s.load(); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func segmentFileName(id uint32) string {
+	return fmt.Sprintf("blocks-%06d.dat", id)
+}
+
+func (s *FileStore) segmentPath(id uint32) string {
+	return filepath.Join(s.dir, segmentFileName(id))
+}
+
+// load reads the index and drops every entry that does not point to a
+// complete record. Anything written after the last indexed record was never
+// acknowledged by Put and is truncated away.
+func (s *FileStore) load() error {
+	index, err := os.OpenFile(filepath.Join(s.dir, indexFileName), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	s.index = index
+
+	raw, err := io.ReadAll(index)
+	if err != nil {
+		return err
+	}
+
+	segmentSizes := make(map[uint32]int64)
+	for i := 0; i+indexEntrySize <= len(raw); i += indexEntrySize {
+		loc := decodeIndexEntry(raw[i : i+indexEntrySize])
+
+		size, ok := segmentSizes[loc.segment]
+		if !ok {
+			info, err := os.Stat(s.segmentPath(loc.segment))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			if err == nil {
+				size = info.Size()
+			}
+			segmentSizes[loc.segment] = size
+		}
+
+		if loc.offset+recordHeaderSize+int64(loc.length) > size {
+			break
+		}
+
+		s.hashes[loc.hash] = uint32(len(s.locations))
+		s.locations = append(s.locations, loc)
+	}
+
+	if err := index.Truncate(int64(len(s.locations) * indexEntrySize)); err != nil {
+		return err
+	}
+	if _, err := index.Seek(0, io.SeekEnd); err != nil {
+		return err
+	}
+
+	if len(s.locations) > 0 {
+		last := s.locations[len(s.locations)-1]
+		s.segmentID = last.segment
+		s.segmentSize = last.offset + recordHeaderSize + int64(last.length)
+	}
+
+	segment, err := os.OpenFile(s.segmentPath(s.segmentID), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	s.segment = segment
+
+	if err := segment.Truncate(s.segmentSize); err != nil {
+		return err
+	}
+	_, err = segment.Seek(s.segmentSize, io.SeekStart)
+
+	return err
+}
+
+func (s *FileStore) Put(b *Block, receipts []*Receipt) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if int(b.Height) != len(s.locations) {
+		return fmt.Errorf("cannot store block with height (%d) => next height (%d)", b.Height, len(s.locations))
+	}
+
+	buf := &bytes.Buffer{}
+	if err := b.Encode(NewBinaryBlockEncoder(buf)); err != nil {
+		return err
+	}
+	if err := writeLengthPrefixed(buf, MarshalReceipts(receipts)); err != nil {
+		return err
+	}
+
+	if s.segmentSize > 0 && s.segmentSize+recordHeaderSize+int64(buf.Len()) > s.maxSegmentSize {
+		if err := s.rollSegment(); err != nil {
+			return err
+		}
+	}
+
+	record := make([]byte, recordHeaderSize+buf.Len())
+	binary.BigEndian.PutUint32(record[0:4], uint32(buf.Len()))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(buf.Bytes()))
+	copy(record[recordHeaderSize:], buf.Bytes())
+
+	loc := blockLocation{
+		hash:    b.Hash(BlockHasher{}),
+		segment: s.segmentID,
+		offset:  s.segmentSize,
+		length:  uint32(buf.Len()),
+	}
+
+	if err := s.write(record, loc); err != nil {
+		// Drop whatever made it to disk, so a record that was never
+		// acknowledged is not found in the index after a restart.
+		s.segment.Truncate(s.segmentSize)
+		s.index.Truncate(int64(len(s.locations) * indexEntrySize))
+		return err
+	}
+
+	s.segmentSize += int64(len(record))
+	s.hashes[loc.hash] = b.Height
+	s.locations = append(s.locations, loc)
+
+	return nil
+}
+
+// write stores the record at the end of the current segment and its index
+// entry after the last one. Both are written at explicit offsets, so a
+// failed write is overwritten by the next one.
+func (s *FileStore) write(record []byte, loc blockLocation) error {
+	if _, err := s.segment.WriteAt(record, loc.offset); err != nil {
+		return err
+	}
+	if err := s.segment.Sync(); err != nil {
+		return err
+	}
+
+	if _, err := s.index.WriteAt(encodeIndexEntry(loc), int64(len(s.locations)*indexEntrySize)); err != nil {
+		return err
+	}
+
+	return s.index.Sync()
+}
+
+func (s *FileStore) rollSegment() error {
+	if err := s.segment.Close(); err != nil {
+		return err
+	}
+
+	segment, err := os.OpenFile(s.segmentPath(s.segmentID+1), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+
+	s.segment = segment
+	s.segmentID++
+	s.segmentSize = 0
+
+	return nil
+}
+
+func (s *FileStore) Get(height uint32) (*Block, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if int(height) >= len(s.locations) {
+		return nil, ErrBlockNotFound
+	}
+
+	return s.read(s.locations[height])
+}
+
+func (s *FileStore) GetReceipts(height uint32) ([]*Receipt, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if int(height) >= len(s.locations) {
+		return nil, ErrBlockNotFound
+	}
+
+	payload, err := s.readRecord(s.locations[height])
+	if err != nil {
+		return nil, err
+	}
+
+	r := bytes.NewReader(payload)
+	if _, err := readLengthPrefixed(r); err != nil {
+		return nil, err
+	}
+
+	b, err := readLengthPrefixed(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return UnmarshalReceipts(b)
+}
+
+func (s *FileStore) GetByHash(hash types.Hash) (*Block, error) {
+	s.mu.RLock()
+	height, ok := s.hashes[hash]
+	s.mu.RUnlock()
+
+	if !ok {
+		return nil, ErrBlockNotFound
+	}
+
+	return s.Get(height)
+}
+
+func (s *FileStore) Has(hash types.Hash) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.hashes[hash]
+	return ok
+}
+
+func (s *FileStore) Iterate(fn func(*Block) error) error {
+	s.mu.RLock()
+	locations := make([]blockLocation, len(s.locations))
+	copy(locations, s.locations)
+	s.mu.RUnlock()
+
+	for _, loc := range locations {
+		b, err := s.read(loc)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(b); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *FileStore) Close() error {
+	var err error
+
+	if s.segment != nil {
+		err = s.segment.Close()
+	}
+	if s.index != nil {
+		if cerr := s.index.Close(); err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
+
+func (s *FileStore) read(loc blockLocation) (*Block, error) {
+	payload, err := s.readRecord(loc)
+	if err != nil {
+		return nil, err
+	}
+
+	b := new(Block)
+	if err := b.Decode(NewBinaryBlockDecoder(bytes.NewReader(payload))); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// readRecord returns the payload of the record at loc.
+func (s *FileStore) readRecord(loc blockLocation) ([]byte, error) {
+	f, err := os.Open(s.segmentPath(loc.segment))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	record := make([]byte, recordHeaderSize+int(loc.length))
+	if _, err := f.ReadAt(record, loc.offset); err != nil {
+		return nil, err
+	}
+
+	payload := record[recordHeaderSize:]
+	if binary.BigEndian.Uint32(record[0:4]) != loc.length ||
+		binary.BigEndian.Uint32(record[4:8]) != crc32.ChecksumIEEE(payload) {
+		return nil, ErrCorruptRecord
+	}
+
+	return payload, nil
+}
+
+func encodeIndexEntry(loc blockLocation) []byte {
+	buf := make([]byte, indexEntrySize)
+	copy(buf[0:32], loc.hash.ToSlice())
+	binary.BigEndian.PutUint32(buf[32:36], loc.segment)
+	binary.BigEndian.PutUint64(buf[36:44], uint64(loc.offset))
+	binary.BigEndian.PutUint32(buf[44:48], loc.length)
+
+	return buf
+}
+
+func decodeIndexEntry(buf []byte) blockLocation {
+	return blockLocation{
+		hash:    types.HashFromBytes(buf[0:32]),
+		segment: binary.BigEndian.Uint32(buf[32:36]),
+		offset:  int64(binary.BigEndian.Uint64(buf[36:44])),
+		length:  binary.BigEndian.Uint32(buf[44:48]),
+	}
+}
@@ -0,0 +1,169 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/k0yote/privatechain/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileStorePutGet(t *testing.T) {
+	s, err := NewFileStore(t.TempDir())
+	assert.Nil(t, err)
+	defer s.Close()
+
+	blocks := putRandomBlocks(t, s, 10)
+
+	for i, b := range blocks {
+		fetched, err := s.Get(uint32(i))
+		assert.Nil(t, err)
+		assert.Equal(t, b.Hash(BlockHasher{}), fetched.Hash(BlockHasher{}))
+
+		hash := b.Hash(BlockHasher{})
+		assert.True(t, s.Has(hash))
+		fetched, err = s.GetByHash(hash)
+		assert.Nil(t, err)
+		assert.Equal(t, b.Height, fetched.Height)
+	}
+
+	_, err = s.Get(10)
+	assert.Equal(t, ErrBlockNotFound, err)
+	assert.False(t, s.Has(types.Hash{}))
+	assert.NotNil(t, s.Put(randomBlock(t, 20, types.Hash{}), nil))
+}
+
+func TestFileStoreReopen(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := NewFileStoreWithSegmentSize(dir, 1024)
+	assert.Nil(t, err)
+	blocks := putRandomBlocks(t, s, 20)
+	assert.Nil(t, s.Close())
+
+	segments, err := filepath.Glob(filepath.Join(dir, "blocks-*.dat"))
+	assert.Nil(t, err)
+	assert.Greater(t, len(segments), 1)
+
+	s, err = NewFileStoreWithSegmentSize(dir, 1024)
+	assert.Nil(t, err)
+	defer s.Close()
+
+	height := 0
+	assert.Nil(t, s.Iterate(func(b *Block) error {
+		assert.Equal(t, blocks[height].Hash(BlockHasher{}), b.Hash(BlockHasher{}))
+		height++
+		return nil
+	}))
+	assert.Equal(t, len(blocks), height)
+
+	b := randomBlock(t, 20, blocks[19].Hash(BlockHasher{}))
+	assert.Nil(t, s.Put(b, nil))
+	assert.True(t, s.Has(b.Hash(BlockHasher{})))
+}
+
+func TestFileStoreReceipts(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := NewFileStore(dir)
+	assert.Nil(t, err)
+
+	receipts := []*Receipt{{
+		TxHash:  types.Hash{0x01},
+		Status:  ReceiptSuccess,
+		GasUsed: 1_000,
+		Events:  []Event{{Topics: [][]byte{[]byte("topic")}, Data: []byte("data")}},
+	}}
+	assert.Nil(t, s.Put(randomBlock(t, 0, types.Hash{}), receipts))
+	assert.Nil(t, s.Close())
+
+	s, err = NewFileStore(dir)
+	assert.Nil(t, err)
+	defer s.Close()
+
+	stored, err := s.GetReceipts(0)
+	assert.Nil(t, err)
+	assert.Equal(t, receipts, stored)
+
+	_, err = s.GetReceipts(1)
+	assert.ErrorIs(t, err, ErrBlockNotFound)
+}
+
+func TestFileStoreTruncatedRecord(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := NewFileStore(dir)
+	assert.Nil(t, err)
+	putRandomBlocks(t, s, 3)
+	assert.Nil(t, s.Close())
+
+	// Simulate a crash in the middle of writing the last record.
+	segment := filepath.Join(dir, segmentFileName(0))
+	info, err := os.Stat(segment)
+	assert.Nil(t, err)
+	assert.Nil(t, os.Truncate(segment, info.Size()-10))
+
+	s, err = NewFileStore(dir)
+	assert.Nil(t, err)
+	defer s.Close()
+
+	_, err = s.Get(2)
+	assert.Equal(t, ErrBlockNotFound, err)
+	_, err = s.Get(1)
+	assert.Nil(t, err)
+}
+
+func TestFileStoreFailedPut(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := NewFileStore(dir)
+	assert.Nil(t, err)
+	blocks := putRandomBlocks(t, s, 2)
+
+	segment := filepath.Join(dir, segmentFileName(0))
+	before, err := os.Stat(segment)
+	assert.Nil(t, err)
+
+	// Writing the index entry fails after the record was written.
+	index := s.index
+	s.index, err = os.Open(filepath.Join(dir, indexFileName))
+	assert.Nil(t, err)
+
+	b := randomBlock(t, 2, blocks[1].Hash(BlockHasher{}))
+	assert.NotNil(t, s.Put(b, nil))
+
+	after, err := os.Stat(segment)
+	assert.Nil(t, err)
+	assert.Equal(t, before.Size(), after.Size())
+
+	s.index.Close()
+	s.index = index
+	b = randomBlock(t, 2, blocks[1].Hash(BlockHasher{}))
+	assert.Nil(t, s.Put(b, nil))
+	assert.Nil(t, s.Close())
+
+	s, err = NewFileStore(dir)
+	assert.Nil(t, err)
+	defer s.Close()
+
+	fetched, err := s.Get(2)
+	assert.Nil(t, err)
+	assert.Equal(t, b.Hash(BlockHasher{}), fetched.Hash(BlockHasher{}))
+	_, err = s.Get(3)
+	assert.Equal(t, ErrBlockNotFound, err)
+}
+
+func putRandomBlocks(t *testing.T, s Storage, n int) []*Block {
+	blocks := []*Block{}
+	prevHash := types.Hash{}
+
+	for i := 0; i < n; i++ {
+		b := randomBlock(t, uint32(i), prevHash)
+		assert.Nil(t, s.Put(b, nil))
+		prevHash = b.Hash(BlockHasher{})
+		blocks = append(blocks, b)
+	}
+
+	return blocks
+}
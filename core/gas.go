@@ -0,0 +1,100 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+)
+
+const (
+	// TxBaseGas is used by every transaction.
+	TxBaseGas uint64 = 1_000
+	// TxDataByteGas is used per byte of code and payload.
+	TxDataByteGas uint64 = 10
+)
+
+var (
+	ErrIntrinsicGas = errors.New("gas limit below intrinsic gas")
+	ErrFeeOverflow  = errors.New("transaction cost overflows")
+)
+
+// IntrinsicGas returns the gas tx uses before any of its code is run.
+func IntrinsicGas(tx *Transaction) (uint64, error) {
+	size := uint64(len(tx.Data))
+	gas := TxBaseGas
+
+	if tx.TxInner != nil {
+		kind, err := LookupTxKind(tx.TxInner.Type())
+		if err != nil {
+			return 0, err
+		}
+
+		payload, err := kind.Marshal(tx.TxInner)
+		if err != nil {
+			return 0, err
+		}
+
+		size += uint64(len(payload))
+		gas += kind.Gas
+	}
+
+	return gas + size*TxDataByteGas, nil
+}
+
+// Fee returns the flat fee of the payload of tx, which is charged on top of
+// the gas.
+func (tx *Transaction) Fee() (uint64, error) {
+	if tx.TxInner == nil {
+		return 0, nil
+	}
+
+	kind, err := LookupTxKind(tx.TxInner.Type())
+	if err != nil {
+		return 0, err
+	}
+	if kind.Fee == nil {
+		return 0, nil
+	}
+
+	return kind.Fee(tx), nil
+}
+
+// Cost returns the balance the sender needs to pay for tx: the maximum gas
+// fee, the payload fee and the transferred value.
+func (tx *Transaction) Cost() (uint64, error) {
+	hi, gasFee := bits.Mul64(tx.GasLimit, tx.GasPrice)
+	if hi != 0 {
+		return 0, ErrFeeOverflow
+	}
+
+	fee, err := tx.Fee()
+	if err != nil {
+		return 0, err
+	}
+
+	cost, carry := bits.Add64(gasFee, fee, 0)
+	if carry != 0 {
+		return 0, ErrFeeOverflow
+	}
+	cost, carry = bits.Add64(cost, tx.Value, 0)
+	if carry != 0 {
+		return 0, ErrFeeOverflow
+	}
+
+	return cost, nil
+}
+
+// checkGas verifies that the gas limit of tx covers its intrinsic gas and
+// returns the intrinsic gas.
+func checkGas(tx *Transaction) (uint64, error) {
+	gas, err := IntrinsicGas(tx)
+	if err != nil {
+		return 0, err
+	}
+
+	if tx.GasLimit < gas {
+		return 0, fmt.Errorf("%w: gas limit (%d) => intrinsic gas (%d)", ErrIntrinsicGas, tx.GasLimit, gas)
+	}
+
+	return gas, nil
+}
@@ -1,9 +1,7 @@
 package core
 
 import (
-	"bytes"
 	"crypto/sha256"
-	"encoding/binary"
 
 	"github.com/k0yote/privatechain/types"
 )
@@ -21,14 +19,18 @@ func (BlockHasher) Hash(b *Header) types.Hash {
 
 type TxHasher struct{}
 
+// Hash returns the hash of the binary encoding of tx without its signature,
+// which is what the sender signs. The encoding is length prefixed, so
+// different transactions never share an encoding.
 func (TxHasher) Hash(tx *Transaction) types.Hash {
-	buf := new(bytes.Buffer)
+	unsigned := *tx
+	unsigned.Signature = nil
 
-	_ = binary.Write(buf, binary.LittleEndian, tx.Data)
-	_ = binary.Write(buf, binary.LittleEndian, tx.To)
-	_ = binary.Write(buf, binary.LittleEndian, tx.Value)
-	_ = binary.Write(buf, binary.LittleEndian, tx.From)
-	_ = binary.Write(buf, binary.LittleEndian, tx.Nonce)
+	w := &codecWriter{}
+	w.u8(CodecVersion)
+	// Payloads of unknown types cannot be encoded and are left out, Verify
+	// rejects those anyway.
+	_ = writeTransaction(w, &unsigned)
 
-	return types.Hash(sha256.Sum256(buf.Bytes()))
+	return types.Hash(sha256.Sum256(w.buf.Bytes()))
 }
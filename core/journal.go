@@ -0,0 +1,199 @@
+package core
+
+import (
+	"fmt"
+	"sync"
+)
+
+type StateKind byte
+
+const (
+	StateKindAccount StateKind = iota + 1
+	StateKindContract
+	StateKindCollection
+	StateKindMint
+	// StateKindCode maps contract addresses to their code and
+	// StateKindStorage holds the storage of every contract, keyed by the
+	// contract address followed by the storage key.
+	StateKindCode
+	StateKindStorage
+)
+
+// StateChange describes the effect a block had on a single state key. A nil
+// Prev means the key did not exist before, a nil Next means it was removed.
+type StateChange struct {
+	Kind StateKind
+	Key  []byte
+	Prev []byte
+	Next []byte
+}
+
+// journaledState is implemented by every piece of state that can be
+// reverted by the journal. Values are exchanged in their encoded form and
+// setEncoded must not record into the journal itself.
+type journaledState interface {
+	getEncoded(key string) []byte
+	setEncoded(key string, value []byte) error
+}
+
+type stateKey struct {
+	kind StateKind
+	key  string
+}
+
+type journalEntry struct {
+	kind StateKind
+	key  string
+	prev []byte
+}
+
+// journal records the previous value of every state key that is written
+// while it is active, so that state can be reverted to any snapshot taken
+// since begin was called. Independent of that it collects the keys written
+// since the state root was last updated.
+type journal struct {
+	mu      sync.Mutex
+	active  bool
+	entries []journalEntry
+	touched map[stateKey]struct{}
+	states  map[StateKind]journaledState
+}
+
+func newJournal() *journal {
+	return &journal{
+		entries: []journalEntry{},
+		touched: make(map[stateKey]struct{}),
+		states:  make(map[StateKind]journaledState),
+	}
+}
+
+func (j *journal) register(kind StateKind, s journaledState) {
+	j.states[kind] = s
+}
+
+func (j *journal) begin() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.active = true
+	j.entries = j.entries[:0]
+}
+
+func (j *journal) finish() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.active = false
+	j.entries = j.entries[:0]
+}
+
+// record must be called by a state before it overwrites key.
+func (j *journal) record(kind StateKind, key string, prev []byte) {
+	if j == nil {
+		return
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.touched[stateKey{kind, key}] = struct{}{}
+	if !j.active {
+		return
+	}
+
+	j.entries = append(j.entries, journalEntry{
+		kind: kind,
+		key:  key,
+		prev: prev,
+	})
+}
+
+func (j *journal) snapshot() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return len(j.entries)
+}
+
+func (j *journal) revertToSnapshot(id int) error {
+	j.mu.Lock()
+	entries := make([]journalEntry, len(j.entries)-id)
+	copy(entries, j.entries[id:])
+	j.entries = j.entries[:id]
+	for _, entry := range entries {
+		j.touched[stateKey{entry.kind, entry.key}] = struct{}{}
+	}
+	j.mu.Unlock()
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+
+		state, ok := j.states[entry.kind]
+		if !ok {
+			return fmt.Errorf("journal: unknown state kind (%d)", entry.kind)
+		}
+
+		if err := state.setEncoded(entry.key, entry.prev); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// restore sets key of the state of the given kind without journaling it.
+func (j *journal) restore(kind StateKind, key string, value []byte) error {
+	state, ok := j.states[kind]
+	if !ok {
+		return fmt.Errorf("journal: unknown state kind (%d)", kind)
+	}
+
+	j.mu.Lock()
+	j.touched[stateKey{kind, key}] = struct{}{}
+	j.mu.Unlock()
+
+	return state.setEncoded(key, value)
+}
+
+// takeTouched returns the keys written since the last call.
+func (j *journal) takeTouched() []stateKey {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	keys := make([]stateKey, 0, len(j.touched))
+	for k := range j.touched {
+		keys = append(keys, k)
+	}
+	j.touched = make(map[stateKey]struct{})
+
+	return keys
+}
+
+// changes returns the net effect of all recorded writes, one change per key
+// in the order the keys were first touched.
+func (j *journal) changes() []StateChange {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var (
+		seen    = make(map[stateKey]bool)
+		changes = []StateChange{}
+	)
+
+	for _, entry := range j.entries {
+		k := stateKey{entry.kind, entry.key}
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+
+		changes = append(changes, StateChange{
+			Kind: entry.kind,
+			Key:  []byte(entry.key),
+			Prev: entry.prev,
+			Next: j.states[entry.kind].getEncoded(entry.key),
+		})
+	}
+
+	return changes
+}
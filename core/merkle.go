@@ -0,0 +1,116 @@
+package core
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/k0yote/privatechain/types"
+)
+
+var (
+	merkleLeafPrefix = []byte{0x00}
+	merkleNodePrefix = []byte{0x01}
+)
+
+// MerkleProofNode is one step of a Merkle branch. Left tells whether the
+// sibling sits on the left of the running hash.
+type MerkleProofNode struct {
+	Hash types.Hash
+	Left bool
+}
+
+type MerkleProof struct {
+	Index    int
+	Siblings []MerkleProofNode
+}
+
+// MerkleRoot returns the root of a binary Merkle tree over leaves. Leaves and
+// inner nodes are hashed with different prefixes and an odd node at the end
+// of a level is promoted to the next level unchanged. The root of an empty
+// tree is the zero hash.
+func MerkleRoot(leaves []types.Hash) types.Hash {
+	if len(leaves) == 0 {
+		return types.Hash{}
+	}
+
+	level := merkleLeaves(leaves)
+	for len(level) > 1 {
+		level = merkleNextLevel(level)
+	}
+
+	return level[0]
+}
+
+func NewMerkleProof(leaves []types.Hash, index int) (*MerkleProof, error) {
+	if index < 0 || index >= len(leaves) {
+		return nil, fmt.Errorf("leaf index (%d) out of range [0, %d)", index, len(leaves))
+	}
+
+	proof := &MerkleProof{
+		Index:    index,
+		Siblings: []MerkleProofNode{},
+	}
+
+	level := merkleLeaves(leaves)
+	for len(level) > 1 {
+		if index%2 == 1 {
+			proof.Siblings = append(proof.Siblings, MerkleProofNode{Hash: level[index-1], Left: true})
+		} else if index+1 < len(level) {
+			proof.Siblings = append(proof.Siblings, MerkleProofNode{Hash: level[index+1], Left: false})
+		}
+
+		level = merkleNextLevel(level)
+		index /= 2
+	}
+
+	return proof, nil
+}
+
+// Verify checks that leaf is part of the tree with the given root.
+func (p *MerkleProof) Verify(root, leaf types.Hash) bool {
+	hash := merkleHash(merkleLeafPrefix, leaf[:])
+
+	for _, sibling := range p.Siblings {
+		if sibling.Left {
+			hash = merkleHash(merkleNodePrefix, sibling.Hash[:], hash[:])
+		} else {
+			hash = merkleHash(merkleNodePrefix, hash[:], sibling.Hash[:])
+		}
+	}
+
+	return hash == root
+}
+
+func merkleLeaves(leaves []types.Hash) []types.Hash {
+	level := make([]types.Hash, len(leaves))
+	for i, leaf := range leaves {
+		level[i] = merkleHash(merkleLeafPrefix, leaf[:])
+	}
+
+	return level
+}
+
+func merkleNextLevel(level []types.Hash) []types.Hash {
+	next := make([]types.Hash, 0, (len(level)+1)/2)
+
+	for i := 0; i < len(level); i += 2 {
+		if i+1 == len(level) {
+			next = append(next, level[i])
+			continue
+		}
+
+		next = append(next, merkleHash(merkleNodePrefix, level[i][:], level[i+1][:]))
+	}
+
+	return next
+}
+
+func merkleHash(prefix []byte, parts ...[]byte) types.Hash {
+	h := sha256.New()
+	h.Write(prefix)
+	for _, part := range parts {
+		h.Write(part)
+	}
+
+	return types.HashFromBytes(h.Sum(nil))
+}
@@ -0,0 +1,67 @@
+package core
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"github.com/k0yote/privatechain/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMerkleRootEmpty(t *testing.T) {
+	assert.True(t, MerkleRoot(nil).IsZero())
+}
+
+func TestMerkleProofs(t *testing.T) {
+	for n := 1; n <= 17; n++ {
+		leaves := make([]types.Hash, n)
+		for i := range leaves {
+			leaves[i] = randomHash(t)
+		}
+
+		root := MerkleRoot(leaves)
+		for i, leaf := range leaves {
+			proof, err := NewMerkleProof(leaves, i)
+			assert.Nil(t, err)
+			assert.True(t, proof.Verify(root, leaf))
+			assert.False(t, proof.Verify(root, randomHash(t)))
+		}
+	}
+
+	_, err := NewMerkleProof([]types.Hash{randomHash(t)}, 1)
+	assert.NotNil(t, err)
+}
+
+func TestMerkleRootOrderMatters(t *testing.T) {
+	a, b := randomHash(t), randomHash(t)
+	assert.NotEqual(t, MerkleRoot([]types.Hash{a, b}), MerkleRoot([]types.Hash{b, a}))
+}
+
+func TestGetTxProof(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+
+	block := randomBlock(t, 1, getPrevBlockHash(t, bc, 1))
+	for i := 0; i < 4; i++ {
+		block.AddTransaction(randomTxWithSignature(t))
+	}
+	assert.Nil(t, bc.AddBlock(prepareBlock(t, bc, block)))
+
+	for _, tx := range block.Transactions {
+		proof, err := bc.GetTxProof(tx.Hash(TxHasher{}))
+		assert.Nil(t, err)
+		assert.True(t, proof.Verify())
+		assert.Equal(t, block.DataHash, proof.DataHash)
+		assert.Equal(t, block.Hash(BlockHasher{}), proof.BlockHash)
+	}
+
+	_, err := bc.GetTxProof(randomHash(t))
+	assert.NotNil(t, err)
+}
+
+func randomHash(t *testing.T) types.Hash {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	assert.Nil(t, err)
+
+	return types.HashFromBytes(b)
+}
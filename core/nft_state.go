@@ -0,0 +1,163 @@
+package core
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/k0yote/privatechain/types"
+)
+
+type NFTState struct {
+	mu          sync.RWMutex
+	collections map[types.Hash]*CollectionTx
+	mints       map[types.Hash]*MintTx
+	journal     *journal
+}
+
+func NewNFTState() *NFTState {
+	return &NFTState{
+		collections: make(map[types.Hash]*CollectionTx),
+		mints:       make(map[types.Hash]*MintTx),
+	}
+}
+
+func (s *NFTState) AddCollection(hash types.Hash, c *CollectionTx) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.journal.record(StateKindCollection, string(hash.ToSlice()), encodeNFTValue(s.collections[hash]))
+	s.collections[hash] = c
+}
+
+func (s *NFTState) GetCollection(hash types.Hash) (*CollectionTx, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c, ok := s.collections[hash]
+	return c, ok
+}
+
+func (s *NFTState) AddMint(hash types.Hash, m *MintTx) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.journal.record(StateKindMint, string(hash.ToSlice()), encodeNFTValue(s.mints[hash]))
+	s.mints[hash] = m
+}
+
+func (s *NFTState) GetMint(hash types.Hash) (*MintTx, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	m, ok := s.mints[hash]
+	return m, ok
+}
+
+// encodeNFTValue returns nil for missing values so the journal can tell
+// them apart from existing ones.
+func encodeNFTValue[T CollectionTx | MintTx](v *T) []byte {
+	if v == nil {
+		return nil
+	}
+
+	w := &codecWriter{}
+	w.u8(CodecVersion)
+	switch v := any(v).(type) {
+	case *CollectionTx:
+		writeCollectionTx(w, v)
+	case *MintTx:
+		writeMintTx(w, v)
+	}
+
+	return w.buf.Bytes()
+}
+
+func decodeNFTValue[T CollectionTx | MintTx](b []byte) (*T, error) {
+	v := new(T)
+
+	r := newCodecReader(b)
+	r.version()
+	switch v := any(v).(type) {
+	case *CollectionTx:
+		*v = readCollectionTx(r)
+	case *MintTx:
+		*v = readMintTx(r)
+	default:
+		return nil, fmt.Errorf("cannot decode %T", v)
+	}
+
+	if err := r.finish(); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// nftStateView exposes one of the maps of NFTState to the journal.
+type nftStateView struct {
+	s    *NFTState
+	kind StateKind
+}
+
+func (v nftStateView) getEncoded(key string) []byte {
+	v.s.mu.RLock()
+	defer v.s.mu.RUnlock()
+
+	hash := types.HashFromBytes([]byte(key))
+	if v.kind == StateKindCollection {
+		return encodeNFTValue(v.s.collections[hash])
+	}
+
+	return encodeNFTValue(v.s.mints[hash])
+}
+
+func (v nftStateView) setEncoded(key string, value []byte) error {
+	v.s.mu.Lock()
+	defer v.s.mu.Unlock()
+
+	hash := types.HashFromBytes([]byte(key))
+
+	if v.kind == StateKindCollection {
+		if value == nil {
+			delete(v.s.collections, hash)
+			return nil
+		}
+
+		c, err := decodeNFTValue[CollectionTx](value)
+		if err != nil {
+			return err
+		}
+		v.s.collections[hash] = c
+
+		return nil
+	}
+
+	if value == nil {
+		delete(v.s.mints, hash)
+		return nil
+	}
+
+	m, err := decodeNFTValue[MintTx](value)
+	if err != nil {
+		return err
+	}
+	v.s.mints[hash] = m
+
+	return nil
+}
+
+func (v nftStateView) forEachEncoded(fn func(key string, value []byte)) {
+	v.s.mu.RLock()
+	defer v.s.mu.RUnlock()
+
+	if v.kind == StateKindCollection {
+		for hash, c := range v.s.collections {
+			fn(string(hash.ToSlice()), encodeNFTValue(c))
+		}
+		return
+	}
+
+	for hash, m := range v.s.mints {
+		fn(string(hash.ToSlice()), encodeNFTValue(m))
+	}
+}
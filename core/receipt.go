@@ -0,0 +1,111 @@
+package core
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/k0yote/privatechain/types"
+)
+
+// MaxEventTopics is the maximum number of topics of a single event.
+const MaxEventTopics = 4
+
+// Event is emitted by contract code with InstrEmit. Topics are meant to be
+// indexed, Data is opaque.
+type Event struct {
+	// Address is the contract emitting the event, zero for transaction data.
+	Address types.Address
+	Topics  [][]byte
+	Data    []byte
+}
+
+type ReceiptStatus byte
+
+const (
+	ReceiptFailed ReceiptStatus = iota
+	ReceiptSuccess
+)
+
+func (s ReceiptStatus) String() string {
+	switch s {
+	case ReceiptFailed:
+		return "failed"
+	case ReceiptSuccess:
+		return "success"
+	default:
+		return fmt.Sprintf("ReceiptStatus(%d)", byte(s))
+	}
+}
+
+// Receipt records the outcome of a transaction included in a block. Failed
+// transactions have no events and the reason of the failure in Error.
+type Receipt struct {
+	TxHash  types.Hash
+	Status  ReceiptStatus
+	GasUsed uint64
+	Events  []Event
+	Error   string
+}
+
+// newReceipt returns the receipt of tx, which failed if err is set.
+func newReceipt(tx *Transaction, gasUsed uint64, events []Event, err error) *Receipt {
+	r := &Receipt{
+		TxHash:  tx.Hash(TxHasher{}),
+		Status:  ReceiptSuccess,
+		GasUsed: gasUsed,
+		Events:  events,
+	}
+
+	if err != nil {
+		r.Status = ReceiptFailed
+		r.Events = nil
+		r.Error = err.Error()
+	}
+
+	return r
+}
+
+// Hash returns the hash of the canonical encoding of r. Error only explains
+// a failure to humans and is left out, so the hash does not depend on the
+// wording of error messages.
+func (r *Receipt) Hash() types.Hash {
+	w := &codecWriter{}
+	writeReceipt(w, r)
+
+	return types.Hash(sha256.Sum256(w.buf.Bytes()))
+}
+
+// CalculateReceiptsRoot returns the Merkle root over the hashes of
+// receipts.
+func CalculateReceiptsRoot(receipts []*Receipt) types.Hash {
+	hashes := make([]types.Hash, len(receipts))
+	for i, r := range receipts {
+		hashes[i] = r.Hash()
+	}
+
+	return MerkleRoot(hashes)
+}
+
+// GetReceipt returns the receipt of the transaction with the given hash.
+func (bc *Blockchain) GetReceipt(hash types.Hash) (*Receipt, error) {
+	bc.lock.RLock()
+	height, ok := bc.txHeights[hash]
+	bc.lock.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("transaction with hash (%s) not found", hash)
+	}
+
+	receipts, err := bc.store.GetReceipts(height)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range receipts {
+		if r.TxHash == hash {
+			return r, nil
+		}
+	}
+
+	return nil, fmt.Errorf("receipt of transaction (%s) not found", hash)
+}
@@ -0,0 +1,122 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/k0yote/privatechain/crypto"
+	"github.com/k0yote/privatechain/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// emitCode emits the call value under the topic "PAID" and fails for calls
+// without value.
+var emitCode = NewCode(CodeVersion1, []byte{
+	0x31,       // CALLVALUE
+	0x60, 0x0a, // PUSH1 10
+	0x1a,       // JUMPI
+	0x60, 0x00, // PUSH1 0
+	0x60, 0x00, // PUSH1 0
+	0x12,                                 // DIV
+	0x1f,                                 // HALT
+	0x1b,                                 // JUMPDEST
+	0x31,                                 // CALLVALUE
+	0x22, 0x00, 0x04, 'P', 'A', 'I', 'D', // PUSH_BYTES "PAID"
+	0x60, 0x01, // PUSH1 1
+	0x38, // EMIT
+})
+
+func TestReceiptsCodecRoundTrip(t *testing.T) {
+	receipts := []*Receipt{
+		{
+			TxHash:  types.Hash{0x01},
+			Status:  ReceiptSuccess,
+			GasUsed: 1_500,
+			Events: []Event{
+				{Address: types.Address{0xaa}, Topics: [][]byte{[]byte("a"), []byte("b")}, Data: []byte{1, 2}},
+				{Data: []byte{3}},
+			},
+		},
+		{
+			TxHash:  types.Hash{0x02},
+			Status:  ReceiptFailed,
+			GasUsed: 2_000,
+			Error:   "out of gas",
+		},
+	}
+
+	decoded, err := UnmarshalReceipts(MarshalReceipts(receipts))
+	assert.Nil(t, err)
+	assert.Equal(t, receipts, decoded)
+	assert.Equal(t, CalculateReceiptsRoot(receipts), CalculateReceiptsRoot(decoded))
+
+	_, err = UnmarshalReceipts(MarshalReceipts(receipts)[:20])
+	assert.NotNil(t, err)
+}
+
+func TestReceiptHashIgnoresError(t *testing.T) {
+	a := &Receipt{TxHash: types.Hash{0x01}, Status: ReceiptFailed, GasUsed: 2_000, Error: "vm fault: runtime error: index out of range [3] with length 3"}
+	b := *a
+	b.Error = "vm fault: runtime error: index out of range"
+
+	assert.Equal(t, a.Hash(), b.Hash())
+	assert.Equal(t, CalculateReceiptsRoot([]*Receipt{a}), CalculateReceiptsRoot([]*Receipt{&b}))
+
+	b.GasUsed++
+	assert.NotEqual(t, a.Hash(), b.Hash())
+}
+
+func TestTransactionReceipts(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	privKey := crypto.GeneratePrivateKey()
+	bc.accountState.CreateAccountWithBalance(privKey.PublicKey().Address(), 1_000_000)
+
+	block := randomBlock(t, 1, getPrevBlockHash(t, bc, 1))
+	block.AddTransaction(contractTx(t, privKey, 0, DeployTx{Code: emitCode}))
+	assert.Nil(t, bc.AddBlock(prepareBlock(t, bc, block)))
+
+	contract := ContractAddress(privKey.PublicKey().Address(), 0)
+
+	paid := &Transaction{TxInner: CallTx{Contract: contract}, Nonce: 1, Value: 7}
+	paid.GasLimit = intrinsicGas(t, paid) + 10_000
+	assert.Nil(t, paid.Sign(privKey))
+	unpaid := contractTx(t, privKey, 2, CallTx{Contract: contract})
+
+	block = randomBlock(t, 2, getPrevBlockHash(t, bc, 2))
+	block.AddTransaction(paid)
+	block.AddTransaction(unpaid)
+	assert.Nil(t, bc.AddBlock(prepareBlock(t, bc, block)))
+	assert.False(t, block.ReceiptsRoot.IsZero())
+
+	receipt, err := bc.GetReceipt(paid.Hash(TxHasher{}))
+	assert.Nil(t, err)
+	assert.Equal(t, ReceiptSuccess, receipt.Status)
+	assert.Equal(t, []Event{{
+		Address: contract,
+		Topics:  [][]byte{[]byte("PAID")},
+		Data:    EncodeValue(Int(7)),
+	}}, receipt.Events)
+
+	// The failed call is kept, charged and has no events.
+	receipt, err = bc.GetReceipt(unpaid.Hash(TxHasher{}))
+	assert.Nil(t, err)
+	assert.Equal(t, ReceiptFailed, receipt.Status)
+	assert.Empty(t, receipt.Events)
+	assert.Contains(t, receipt.Error, ErrDivisionByZero.Error())
+	assert.Greater(t, receipt.GasUsed, intrinsicGas(t, unpaid))
+}
+
+func TestAddBlockInvalidReceiptsRoot(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	privKey := crypto.GeneratePrivateKey()
+
+	block := randomBlock(t, 1, getPrevBlockHash(t, bc, 1))
+	block.AddTransaction(contractTx(t, privKey, 0, DeployTx{Code: emitCode}))
+	block.Validator = privKey.PublicKey()
+	assert.Nil(t, bc.PrepareBlock(block))
+
+	block.ReceiptsRoot = types.Hash{0x01}
+	assert.Nil(t, block.Sign(privKey))
+
+	assert.NotNil(t, bc.AddBlock(block))
+	assert.Equal(t, uint32(0), bc.Height())
+}
@@ -5,22 +5,31 @@ import (
 )
 
 type State struct {
-	data map[string][]byte
+	kind    StateKind
+	data    map[string][]byte
+	journal *journal
 }
 
 func NewState() *State {
+	return newStateOfKind(StateKindContract)
+}
+
+func newStateOfKind(kind StateKind) *State {
 	return &State{
+		kind: kind,
 		data: make(map[string][]byte),
 	}
 }
 
 func (s *State) Put(k, v []byte) error {
+	s.journal.record(s.kind, string(k), s.data[string(k)])
 	s.data[string(k)] = v
 
 	return nil
 }
 
 func (s *State) Delete(k []byte) error {
+	s.journal.record(s.kind, string(k), s.data[string(k)])
 	delete(s.data, string(k))
 
 	return nil
@@ -34,3 +43,23 @@ func (s *State) Get(k []byte) ([]byte, error) {
 	}
 	return value, nil
 }
+
+func (s *State) getEncoded(key string) []byte {
+	return s.data[key]
+}
+
+func (s *State) setEncoded(key string, value []byte) error {
+	if value == nil {
+		delete(s.data, key)
+		return nil
+	}
+	s.data[key] = value
+
+	return nil
+}
+
+func (s *State) forEachEncoded(fn func(key string, value []byte)) {
+	for k, v := range s.data {
+		fn(k, v)
+	}
+}
@@ -0,0 +1,198 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"sort"
+
+	"github.com/k0yote/privatechain/types"
+)
+
+var (
+	smtLeafPrefix = []byte{0x00}
+	smtNodePrefix = []byte{0x01}
+)
+
+// stateIterator is implemented by states that can list all their keys.
+type stateIterator interface {
+	forEachEncoded(fn func(key string, value []byte))
+}
+
+type smtLeaf struct {
+	path  types.Hash
+	value types.Hash
+}
+
+// StateRoot returns the root of a sparse Merkle tree over every key of the
+// account, contract and NFT state.
+func (bc *Blockchain) StateRoot() types.Hash {
+	// Updating the cached tree writes to it, so the read lock is not enough.
+	bc.stateLock.Lock()
+	defer bc.stateLock.Unlock()
+
+	return bc.stateRootWithoutLock()
+}
+
+// stateRootWithoutLock updates the cached tree with the keys written since
+// the last call and returns its root.
+func (bc *Blockchain) stateRootWithoutLock() types.Hash {
+	for _, k := range bc.journal.takeTouched() {
+		state, ok := bc.journal.states[k.kind]
+		if !ok {
+			continue
+		}
+
+		value := state.getEncoded(k.key)
+		if value == nil {
+			bc.stateTree = bc.stateTree.delete(newSMTLeaf(k.kind, []byte(k.key), nil).path, 0)
+			continue
+		}
+		bc.stateTree = bc.stateTree.insert(newSMTLeaf(k.kind, []byte(k.key), value), 0)
+	}
+
+	return bc.stateTree.root()
+}
+
+func newSMTLeaf(kind StateKind, key, value []byte) smtLeaf {
+	path := sha256.New()
+	path.Write([]byte{byte(kind)})
+	path.Write(key)
+
+	leaf := smtLeaf{value: sha256.Sum256(value)}
+	copy(leaf.path[:], path.Sum(nil))
+
+	return leaf
+}
+
+// sparseMerkleRoot computes the root of a compact sparse Merkle tree with
+// 256 bit paths. Subtrees holding a single leaf are collapsed into that leaf
+// and empty subtrees hash to the zero hash. Paths must be unique.
+func sparseMerkleRoot(leaves []smtLeaf) types.Hash {
+	leaves = append([]smtLeaf(nil), leaves...)
+	sort.Slice(leaves, func(i, j int) bool {
+		return bytes.Compare(leaves[i].path[:], leaves[j].path[:]) < 0
+	})
+
+	return smtSubtreeRoot(leaves, 0)
+}
+
+func smtSubtreeRoot(leaves []smtLeaf, depth int) types.Hash {
+	switch len(leaves) {
+	case 0:
+		return types.Hash{}
+	case 1:
+		return smtLeafHash(leaves[0])
+	}
+
+	// Leaves are sorted by path, so all leaves going left come first.
+	split := sort.Search(len(leaves), func(i int) bool {
+		return pathBit(leaves[i].path, depth) == 1
+	})
+
+	return smtBranchHash(smtSubtreeRoot(leaves[:split], depth+1), smtSubtreeRoot(leaves[split:], depth+1))
+}
+
+func smtLeafHash(leaf smtLeaf) types.Hash {
+	h := sha256.New()
+	h.Write(smtLeafPrefix)
+	h.Write(leaf.path[:])
+	h.Write(leaf.value[:])
+
+	return types.HashFromBytes(h.Sum(nil))
+}
+
+func smtBranchHash(left, right types.Hash) types.Hash {
+	h := sha256.New()
+	h.Write(smtNodePrefix)
+	h.Write(left[:])
+	h.Write(right[:])
+
+	return types.HashFromBytes(h.Sum(nil))
+}
+
+func pathBit(path types.Hash, depth int) byte {
+	return (path[depth/8] >> (7 - uint(depth%8))) & 1
+}
+
+// smtNode is a node of the sparse Merkle tree kept by the chain, hashing to
+// the same root as sparseMerkleRoot over its leaves. A nil node is an empty
+// subtree, leaves are the subtrees holding a single leaf and branches cache
+// their hash until a leaf below them changes.
+type smtNode struct {
+	leaf        *smtLeaf
+	left, right *smtNode
+	hash        types.Hash
+	dirty       bool
+}
+
+// insert adds or replaces the leaf in the subtree n at depth and returns the
+// new subtree.
+func (n *smtNode) insert(leaf smtLeaf, depth int) *smtNode {
+	if n == nil || (n.leaf != nil && n.leaf.path == leaf.path) {
+		return &smtNode{leaf: &leaf, hash: smtLeafHash(leaf)}
+	}
+
+	if n.leaf != nil {
+		// Push the existing leaf down into a new branch.
+		branch := &smtNode{}
+		if pathBit(n.leaf.path, depth) == 0 {
+			branch.left = n
+		} else {
+			branch.right = n
+		}
+		n = branch
+	}
+
+	if pathBit(leaf.path, depth) == 0 {
+		n.left = n.left.insert(leaf, depth+1)
+	} else {
+		n.right = n.right.insert(leaf, depth+1)
+	}
+	n.dirty = true
+
+	return n
+}
+
+// delete removes the leaf with the given path from the subtree n at depth
+// and returns the new subtree.
+func (n *smtNode) delete(path types.Hash, depth int) *smtNode {
+	switch {
+	case n == nil:
+		return nil
+	case n.leaf != nil:
+		if n.leaf.path == path {
+			return nil
+		}
+		return n
+	}
+
+	if pathBit(path, depth) == 0 {
+		n.left = n.left.delete(path, depth+1)
+	} else {
+		n.right = n.right.delete(path, depth+1)
+	}
+	n.dirty = true
+
+	// A branch left with a single leaf collapses into it.
+	switch {
+	case n.left == nil && (n.right == nil || n.right.leaf != nil):
+		return n.right
+	case n.right == nil && n.left.leaf != nil:
+		return n.left
+	}
+
+	return n
+}
+
+func (n *smtNode) root() types.Hash {
+	if n == nil {
+		return types.Hash{}
+	}
+
+	if n.dirty {
+		n.hash = smtBranchHash(n.left.root(), n.right.root())
+		n.dirty = false
+	}
+
+	return n.hash
+}
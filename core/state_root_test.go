@@ -0,0 +1,106 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/k0yote/privatechain/crypto"
+	"github.com/k0yote/privatechain/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSparseMerkleRootOrderIndependent(t *testing.T) {
+	leaves := []smtLeaf{}
+	for i := 0; i < 50; i++ {
+		leaves = append(leaves, newSMTLeaf(StateKindContract, []byte{byte(i)}, []byte{byte(i * 2)}))
+	}
+
+	reversed := make([]smtLeaf, len(leaves))
+	for i, leaf := range leaves {
+		reversed[len(leaves)-1-i] = leaf
+	}
+
+	root := sparseMerkleRoot(leaves)
+	assert.Equal(t, root, sparseMerkleRoot(reversed))
+	assert.False(t, root.IsZero())
+	assert.True(t, sparseMerkleRoot(nil).IsZero())
+
+	leaves[10] = newSMTLeaf(StateKindContract, []byte{10}, []byte{0})
+	assert.NotEqual(t, root, sparseMerkleRoot(leaves))
+}
+
+func TestStateRootChangesWithState(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	root := bc.StateRoot()
+
+	assert.Nil(t, bc.contractState.Put([]byte("foo"), []byte("bar")))
+	withContract := bc.StateRoot()
+	assert.NotEqual(t, root, withContract)
+
+	bc.accountState.CreateAccountWithBalance(crypto.GeneratePrivateKey().PublicKey().Address(), 10)
+	assert.NotEqual(t, withContract, bc.StateRoot())
+}
+
+func TestAddBlockInvalidStateRoot(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+
+	block := contractBlock(t, 1, getPrevBlockHash(t, bc, 1))
+	assert.Nil(t, bc.PrepareBlock(block))
+	assert.False(t, block.StateRoot.IsZero())
+
+	block.StateRoot = types.Hash{}
+	assert.Nil(t, block.Sign(crypto.GeneratePrivateKey()))
+	assert.NotNil(t, bc.AddBlock(block))
+
+	assert.Equal(t, uint32(0), bc.Height())
+	_, err := bc.contractState.Get([]byte("FOO"))
+	assert.NotNil(t, err)
+
+	assert.Nil(t, bc.AddBlock(prepareBlock(t, bc, block)))
+	assert.Equal(t, block.StateRoot, bc.StateRoot())
+}
+
+// fullStateRoot rebuilds the state root of bc from all of its keys.
+func fullStateRoot(bc *Blockchain) types.Hash {
+	leaves := []smtLeaf{}
+	for kind, state := range bc.journal.states {
+		state.(stateIterator).forEachEncoded(func(key string, value []byte) {
+			leaves = append(leaves, newSMTLeaf(kind, []byte(key), value))
+		})
+	}
+
+	return sparseMerkleRoot(leaves)
+}
+
+func TestStateRootIncremental(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	assert.Equal(t, fullStateRoot(bc), bc.StateRoot())
+
+	for i := 0; i < 300; i++ {
+		key := []byte{byte(i % 41)}
+		if i%4 == 0 {
+			assert.Nil(t, bc.contractState.Delete(key))
+		} else {
+			assert.Nil(t, bc.contractState.Put(key, []byte{byte(i)}))
+		}
+
+		if i%7 == 0 {
+			assert.Equal(t, fullStateRoot(bc), bc.StateRoot())
+		}
+	}
+
+	// Deleting every contract key collapses the branches again.
+	for i := 0; i < 41; i++ {
+		assert.Nil(t, bc.contractState.Delete([]byte{byte(i)}))
+		assert.Equal(t, fullStateRoot(bc), bc.StateRoot())
+	}
+
+	root := bc.StateRoot()
+	bc.journal.begin()
+	assert.Nil(t, bc.contractState.Put([]byte("foo"), []byte("bar")))
+	bc.accountState.Credit(crypto.GeneratePrivateKey().PublicKey().Address(), 10)
+	assert.Equal(t, fullStateRoot(bc), bc.StateRoot())
+	assert.Nil(t, bc.journal.revertToSnapshot(0))
+	bc.journal.finish()
+	assert.Equal(t, root, bc.StateRoot())
+	assert.Equal(t, fullStateRoot(bc), bc.StateRoot())
+}
@@ -1,15 +1,113 @@
 package core
 
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/k0yote/privatechain/types"
+)
+
+var ErrBlockNotFound = errors.New("block not found")
+
 type Storage interface {
-	Put(*Block) error
+	// Put stores b together with the receipts of its transactions.
+	Put(b *Block, receipts []*Receipt) error
+	Get(height uint32) (*Block, error)
+	GetReceipts(height uint32) ([]*Receipt, error)
+	GetByHash(hash types.Hash) (*Block, error)
+	Has(hash types.Hash) bool
+	// Iterate calls fn for every stored block in ascending height order.
+	Iterate(fn func(*Block) error) error
+	Close() error
 }
 
-type MemoryStore struct{}
+type MemoryStore struct {
+	mu       sync.RWMutex
+	blocks   []*Block
+	receipts [][]*Receipt
+	hashes   map[types.Hash]uint32
+}
 
 func NewMemoryStore() *MemoryStore {
-	return &MemoryStore{}
+	return &MemoryStore{
+		blocks: []*Block{},
+		hashes: make(map[types.Hash]uint32),
+	}
+}
+
+func (s *MemoryStore) Put(block *Block, receipts []*Receipt) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if int(block.Height) != len(s.blocks) {
+		return fmt.Errorf("cannot store block with height (%d) => next height (%d)", block.Height, len(s.blocks))
+	}
+
+	s.blocks = append(s.blocks, block)
+	s.receipts = append(s.receipts, receipts)
+	s.hashes[block.Hash(BlockHasher{})] = block.Height
+
+	return nil
+}
+
+func (s *MemoryStore) Get(height uint32) (*Block, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if int(height) >= len(s.blocks) {
+		return nil, ErrBlockNotFound
+	}
+
+	return s.blocks[height], nil
+}
+
+func (s *MemoryStore) GetReceipts(height uint32) ([]*Receipt, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if int(height) >= len(s.receipts) {
+		return nil, ErrBlockNotFound
+	}
+
+	return s.receipts[height], nil
+}
+
+func (s *MemoryStore) GetByHash(hash types.Hash) (*Block, error) {
+	s.mu.RLock()
+	height, ok := s.hashes[hash]
+	s.mu.RUnlock()
+
+	if !ok {
+		return nil, ErrBlockNotFound
+	}
+
+	return s.Get(height)
+}
+
+func (s *MemoryStore) Has(hash types.Hash) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, ok := s.hashes[hash]
+	return ok
+}
+
+func (s *MemoryStore) Iterate(fn func(*Block) error) error {
+	s.mu.RLock()
+	blocks := make([]*Block, len(s.blocks))
+	copy(blocks, s.blocks)
+	s.mu.RUnlock()
+
+	for _, b := range blocks {
+		if err := fn(b); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
-func (s *MemoryStore) Put(block *Block) error {
+func (s *MemoryStore) Close() error {
 	return nil
 }
@@ -1,19 +1,24 @@
 package core
 
 import (
-	"encoding/gob"
 	"fmt"
-	"math/rand"
 
 	"github.com/k0yote/privatechain/crypto"
 	"github.com/k0yote/privatechain/types"
 )
 
+// TxType is the first byte of the transaction envelope and selects the
+// TxKind that handles the payload.
 type TxType byte
 
 const (
-	TxTypeCollection TxType = iota
+	// TxTypeNone is used by transactions without payload, like native
+	// transfers and contract calls.
+	TxTypeNone TxType = iota
+	TxTypeCollection
 	TxTypeMint
+	TxTypeDeploy
+	TxTypeCall
 )
 
 type CollectionTx struct {
@@ -21,6 +26,8 @@ type CollectionTx struct {
 	MetaData []byte
 }
 
+func (CollectionTx) Type() TxType { return TxTypeCollection }
+
 type MintTx struct {
 	Fee             int64
 	NFT             types.Hash
@@ -30,26 +37,42 @@ type MintTx struct {
 	Signature       crypto.Signature
 }
 
+func (MintTx) Type() TxType { return TxTypeMint }
+
 type Transaction struct {
-	// Type      TxType
-	TxInner   any
+	TxInner   TxPayload
 	Data      []byte
 	To        crypto.PublicKey
 	Value     uint64
 	From      crypto.PublicKey
 	Signature *crypto.Signature
-	Nonce     int64
+	// Nonce has to match the nonce of the sender account, see Account.Nonce.
+	Nonce uint64
+	// ChainID binds the signature to a single chain, see Header.ChainID.
+	ChainID uint64
+	// GasLimit is the maximum gas the transaction may use and GasPrice the
+	// amount paid per unit of gas, see Transaction.Cost.
+	GasLimit uint64
+	GasPrice uint64
 
 	hash types.Hash
 }
 
 func NewTransaction(data []byte) *Transaction {
 	return &Transaction{
-		Data:  data,
-		Nonce: rand.Int63n(1000000000000000000),
+		Data: data,
 	}
 }
 
+// Type returns the type of the payload, or TxTypeNone without payload.
+func (tx *Transaction) Type() TxType {
+	if tx.TxInner == nil {
+		return TxTypeNone
+	}
+
+	return tx.TxInner.Type()
+}
+
 func (tx *Transaction) Hash(hasher Hasher[*Transaction]) types.Hash {
 	if tx.hash.IsZero() {
 		tx.hash = hasher.Hash(tx)
@@ -58,13 +81,16 @@ func (tx *Transaction) Hash(hasher Hasher[*Transaction]) types.Hash {
 }
 
 func (tx *Transaction) Sign(privKey crypto.PrivateKey) error {
+	// From is part of the signed hash, so it has to be set before hashing.
+	tx.From = privKey.PublicKey()
+	tx.hash = types.Hash{}
+
 	hash := tx.Hash(TxHasher{})
 	sig, err := privKey.Sign(hash.ToSlice())
 	if err != nil {
 		return err
 	}
 
-	tx.From = privKey.PublicKey()
 	tx.Signature = sig
 
 	return nil
@@ -72,12 +98,24 @@ func (tx *Transaction) Sign(privKey crypto.PrivateKey) error {
 
 func (tx *Transaction) Verify() error {
 	if tx.Signature == nil {
-		return fmt.Errorf("transaction has no signature")
+		return fmt.Errorf("%w: transaction has no signature", ErrInvalidSignature)
+	}
+
+	if tx.TxInner != nil {
+		kind, err := LookupTxKind(tx.TxInner.Type())
+		if err != nil {
+			return err
+		}
+		if kind.Validate != nil {
+			if err := kind.Validate(tx); err != nil {
+				return err
+			}
+		}
 	}
 
 	hash := tx.Hash(TxHasher{})
 	if !tx.Signature.Verify(tx.From, hash.ToSlice()) {
-		return fmt.Errorf("invalid transaction signature")
+		return fmt.Errorf("%w: transaction (%s)", ErrInvalidSignature, hash)
 	}
 
 	return nil
@@ -90,8 +128,3 @@ func (tx *Transaction) Decode(dec Decoder[*Transaction]) error {
 func (tx *Transaction) Encode(enc Encoder[*Transaction]) error {
 	return enc.Encode(tx)
 }
-
-func init() {
-	gob.Register(CollectionTx{})
-	gob.Register(MintTx{})
-}
@@ -2,7 +2,6 @@ package core
 
 import (
 	"bytes"
-	"encoding/gob"
 	"fmt"
 	"testing"
 
@@ -55,11 +54,11 @@ func TestNFTTransaction(t *testing.T) {
 
 	tx.Sign(privKey)
 	buf := new(bytes.Buffer)
-	assert.Nil(t, gob.NewEncoder(buf).Encode(tx))
+	assert.Nil(t, tx.Encode(NewBinaryTxEncoder(buf)))
 	tx.hash = types.Hash{}
 
 	txDecoded := &Transaction{}
-	assert.Nil(t, gob.NewDecoder(buf).Decode(txDecoded))
+	assert.Nil(t, txDecoded.Decode(NewBinaryTxDecoder(buf)))
 	assert.Equal(t, tx, txDecoded)
 }
 
@@ -88,14 +87,43 @@ func TestVerifyTransaction(t *testing.T) {
 	assert.NotNil(t, tx.Verify())
 }
 
+func TestChainIDInSignedHash(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+	tx := &Transaction{
+		Data:    []byte("foo"),
+		ChainID: 1,
+	}
+	assert.Nil(t, tx.Sign(privKey))
+	assert.Nil(t, tx.Verify())
+
+	tx.ChainID = 2
+	tx.hash = types.Hash{}
+	assert.NotNil(t, tx.Verify())
+}
+
+func TestTxHashInjective(t *testing.T) {
+	a := &Transaction{Data: []byte("ab"), To: crypto.PublicKey("c")}
+	b := &Transaction{Data: []byte("a"), To: crypto.PublicKey("bc")}
+	assert.NotEqual(t, TxHasher{}.Hash(a), TxHasher{}.Hash(b))
+
+	// The signature is not part of the hash.
+	privKey := crypto.GeneratePrivateKey()
+	hash := TxHasher{}.Hash(a)
+	a.From = privKey.PublicKey()
+	assert.NotEqual(t, hash, TxHasher{}.Hash(a))
+	hash = TxHasher{}.Hash(a)
+	assert.Nil(t, a.Sign(privKey))
+	assert.Equal(t, hash, TxHasher{}.Hash(a))
+}
+
 func TestTxEncodeDecode(t *testing.T) {
 	tx := randomTxWithSignature(t)
 	buf := &bytes.Buffer{}
-	assert.Nil(t, tx.Encode(NewGobTxEncoder(buf)))
+	assert.Nil(t, tx.Encode(NewBinaryTxEncoder(buf)))
 	tx.hash = types.Hash{}
 
 	txDecoded := new(Transaction)
-	assert.Nil(t, txDecoded.Decode(NewGobTxDecoder(buf)))
+	assert.Nil(t, txDecoded.Decode(NewBinaryTxDecoder(buf)))
 	assert.Equal(t, tx, txDecoded)
 }
 
@@ -104,7 +132,51 @@ func randomTxWithSignature(t *testing.T) *Transaction {
 	tx := Transaction{
 		Data: []byte("foo"),
 	}
+	tx.GasLimit = intrinsicGas(t, &tx) + 100
 
 	assert.Nil(t, tx.Sign(privKey))
 	return &tx
 }
+
+func TestTxPayloadInSignedHash(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+	tx := &Transaction{
+		TxInner: CollectionTx{Fee: 10, MetaData: []byte("collection")},
+	}
+	assert.Nil(t, tx.Sign(privKey))
+	assert.Nil(t, tx.Verify())
+
+	tx.TxInner = CollectionTx{Fee: 10, MetaData: []byte("tampered")}
+	tx.hash = types.Hash{}
+	assert.NotNil(t, tx.Verify())
+}
+
+func TestTxKindValidation(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+	tx := &Transaction{
+		TxInner: MintTx{Fee: 10, NFT: types.Hash{0x01}},
+	}
+	assert.Nil(t, tx.Sign(privKey))
+	assert.NotNil(t, tx.Verify())
+}
+
+func TestTxUnknownType(t *testing.T) {
+	tx := randomTxWithSignature(t)
+	data, err := MarshalTransaction(tx)
+	assert.Nil(t, err)
+
+	// The envelope type directly follows the codec version.
+	data[1] = 0xff
+	assert.ErrorIs(t, UnmarshalTransaction(data, new(Transaction)), ErrUnknownTxType)
+
+	assert.Panics(t, func() {
+		RegisterTxKind(&TxKind{Type: TxTypeMint})
+	})
+}
+
+func intrinsicGas(t *testing.T, tx *Transaction) uint64 {
+	gas, err := IntrinsicGas(tx)
+	assert.Nil(t, err)
+
+	return gas
+}
@@ -0,0 +1,182 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var ErrUnknownTxType = errors.New("unknown transaction type")
+
+const (
+	collectionGas uint64 = 5_000
+	mintGas       uint64 = 5_000
+)
+
+// TxPayload is the typed payload of a transaction envelope. Every payload
+// type has to be registered with RegisterTxKind.
+type TxPayload interface {
+	Type() TxType
+}
+
+// TxKind describes how the payload of one transaction type is encoded,
+// validated and executed.
+type TxKind struct {
+	Type TxType
+	Name string
+	// Gas is added to the intrinsic gas of transactions of this kind.
+	Gas uint64
+
+	// Marshal and Unmarshal convert the payload to and from its canonical
+	// encoding, which is part of the signed transaction hash.
+	Marshal   func(p TxPayload) ([]byte, error)
+	Unmarshal func(b []byte) (TxPayload, error)
+	// Validate runs stateless checks when the transaction is verified.
+	Validate func(tx *Transaction) error
+	// Execute applies the payload to the state of bc. Code run by the
+	// payload runs in ctx and may use up to gas units of gas, the gas used
+	// is returned.
+	Execute func(bc *Blockchain, tx *Transaction, ctx VMContext, gas uint64) (uint64, error)
+	// Fee returns a flat fee charged on top of the gas, may be nil.
+	Fee func(tx *Transaction) uint64
+}
+
+var (
+	txKindsMu sync.RWMutex
+	txKinds   = map[TxType]*TxKind{}
+)
+
+// RegisterTxKind makes a transaction type known to the codec and the
+// blockchain. It panics if the type is already registered.
+func RegisterTxKind(kind *TxKind) {
+	txKindsMu.Lock()
+	defer txKindsMu.Unlock()
+
+	if kind.Type == TxTypeNone {
+		panic("cannot register a kind for TxTypeNone")
+	}
+	if _, ok := txKinds[kind.Type]; ok {
+		panic(fmt.Sprintf("transaction type (%d) already registered", kind.Type))
+	}
+
+	txKinds[kind.Type] = kind
+}
+
+func LookupTxKind(t TxType) (*TxKind, error) {
+	txKindsMu.RLock()
+	defer txKindsMu.RUnlock()
+
+	kind, ok := txKinds[t]
+	if !ok {
+		return nil, fmt.Errorf("%w (%d)", ErrUnknownTxType, t)
+	}
+
+	return kind, nil
+}
+
+func marshalPayload(p TxPayload) ([]byte, error) {
+	kind, err := LookupTxKind(p.Type())
+	if err != nil {
+		return nil, err
+	}
+
+	return kind.Marshal(p)
+}
+
+func init() {
+	RegisterTxKind(&TxKind{
+		Type: TxTypeCollection,
+		Name: "collection",
+		Marshal: func(p TxPayload) ([]byte, error) {
+			c, ok := p.(CollectionTx)
+			if !ok {
+				return nil, fmt.Errorf("expected CollectionTx, got %T", p)
+			}
+			w := &codecWriter{}
+			writeCollectionTx(w, &c)
+			return w.buf.Bytes(), nil
+		},
+		Unmarshal: func(b []byte) (TxPayload, error) {
+			r := newCodecReader(b)
+			c := readCollectionTx(r)
+			return c, r.finish()
+		},
+		Validate: validateCollectionTx,
+		Execute:  executeCollectionTx,
+		Gas:      collectionGas,
+		Fee: func(tx *Transaction) uint64 {
+			return uint64(tx.TxInner.(CollectionTx).Fee)
+		},
+	})
+
+	RegisterTxKind(&TxKind{
+		Type: TxTypeMint,
+		Name: "mint",
+		Marshal: func(p TxPayload) ([]byte, error) {
+			m, ok := p.(MintTx)
+			if !ok {
+				return nil, fmt.Errorf("expected MintTx, got %T", p)
+			}
+			w := &codecWriter{}
+			writeMintTx(w, &m)
+			return w.buf.Bytes(), nil
+		},
+		Unmarshal: func(b []byte) (TxPayload, error) {
+			r := newCodecReader(b)
+			m := readMintTx(r)
+			return m, r.finish()
+		},
+		Validate: validateMintTx,
+		Execute:  executeMintTx,
+		Gas:      mintGas,
+		Fee: func(tx *Transaction) uint64 {
+			return uint64(tx.TxInner.(MintTx).Fee)
+		},
+	})
+}
+
+func validateCollectionTx(tx *Transaction) error {
+	c := tx.TxInner.(CollectionTx)
+	if c.Fee < 0 {
+		return fmt.Errorf("collection with negative fee (%d)", c.Fee)
+	}
+
+	return nil
+}
+
+func executeCollectionTx(bc *Blockchain, tx *Transaction, _ VMContext, _ uint64) (uint64, error) {
+	c := tx.TxInner.(CollectionTx)
+	hash := tx.Hash(TxHasher{})
+	bc.nftState.AddCollection(hash, &c)
+
+	bc.logger.Log("msg", "created new NFT collections", "hash", hash)
+
+	return 0, nil
+}
+
+func validateMintTx(tx *Transaction) error {
+	m := tx.TxInner.(MintTx)
+	if m.Fee < 0 {
+		return fmt.Errorf("mint with negative fee (%d)", m.Fee)
+	}
+	if m.NFT.IsZero() {
+		return fmt.Errorf("mint without NFT hash")
+	}
+	if m.Collection.IsZero() {
+		return fmt.Errorf("mint without collection hash")
+	}
+
+	return nil
+}
+
+func executeMintTx(bc *Blockchain, tx *Transaction, _ VMContext, _ uint64) (uint64, error) {
+	m := tx.TxInner.(MintTx)
+	if _, ok := bc.nftState.GetCollection(m.Collection); !ok {
+		return 0, fmt.Errorf("collection (%s) does not exist on the blockchain", m.Collection)
+	}
+
+	bc.nftState.AddMint(tx.Hash(TxHasher{}), &m)
+	bc.logger.Log("msg", "created new NFT mint", "NFT", m.NFT, "collection", m.Collection)
+
+	return 0, nil
+}
@@ -3,12 +3,28 @@ package core
 import (
 	"errors"
 	"fmt"
+
+	"github.com/k0yote/privatechain/types"
 )
 
-var ErrBlockKnown = errors.New("block already known")
+var (
+	ErrBlockKnown       = errors.New("block already known")
+	ErrBlockTooHigh     = errors.New("block too high")
+	ErrInvalidChainID   = errors.New("invalid chain id")
+	ErrInvalidSignature = errors.New("invalid signature")
+	// The errors below prove a block invalid, independent of the state of
+	// the chain it is added to.
+	ErrInvalidDataHash     = errors.New("invalid data hash")
+	ErrInvalidStateRoot    = errors.New("invalid state root")
+	ErrInvalidReceiptsRoot = errors.New("invalid receipts root")
+	ErrInvalidTransaction  = errors.New("invalid transaction")
+)
 
 type Validator interface {
 	ValidateBlock(*Block) error
+	// ValidateState is called once the block has been executed, with the
+	// root of the resulting state and the root of the receipts.
+	ValidateState(b *Block, stateRoot, receiptsRoot types.Hash) error
 }
 
 type BlockValidator struct {
@@ -22,13 +38,24 @@ func NewBlockValidator(bc *Blockchain) *BlockValidator {
 }
 
 func (v *BlockValidator) ValidateBlock(b *Block) error {
+	chainID := v.bc.ChainID()
+	if b.ChainID != chainID {
+		return fmt.Errorf("%w: block (%s) with chain id (%d) => our chain id (%d)", ErrInvalidChainID, b.Hash(BlockHasher{}), b.ChainID, chainID)
+	}
+
+	for _, tx := range b.Transactions {
+		if tx.ChainID != chainID {
+			return fmt.Errorf("%w: transaction (%s) with chain id (%d) => our chain id (%d)", ErrInvalidChainID, tx.Hash(TxHasher{}), tx.ChainID, chainID)
+		}
+	}
+
 	if v.bc.HasBlock(b.Height) {
 		// return fmt.Errorf("chain already contains block (%d) with hash (%s)", b.Height, b.Hash(BlockHasher{}))
 		return ErrBlockKnown
 	}
 
 	if b.Height != v.bc.Height()+1 {
-		return fmt.Errorf("block  (%s) with height (%d) is too high => current height (%d)", b.Hash(BlockHasher{}), b.Height, v.bc.Height())
+		return fmt.Errorf("%w: block (%s) with height (%d) => current height (%d)", ErrBlockTooHigh, b.Hash(BlockHasher{}), b.Height, v.bc.Height())
 	}
 
 	prevHeader, err := v.bc.GetHeader(b.Height - 1)
@@ -46,3 +73,14 @@ func (v *BlockValidator) ValidateBlock(b *Block) error {
 	}
 	return nil
 }
+
+func (v *BlockValidator) ValidateState(b *Block, stateRoot, receiptsRoot types.Hash) error {
+	if stateRoot != b.StateRoot {
+		return fmt.Errorf("%w: block (%s) with state root (%s) => computed (%s)", ErrInvalidStateRoot, b.Hash(BlockHasher{}), b.StateRoot, stateRoot)
+	}
+	if receiptsRoot != b.ReceiptsRoot {
+		return fmt.Errorf("%w: block (%s) with receipts root (%s) => computed (%s)", ErrInvalidReceiptsRoot, b.Hash(BlockHasher{}), b.ReceiptsRoot, receiptsRoot)
+	}
+
+	return nil
+}
@@ -1,7 +1,14 @@
 package core
 
 import (
+	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/k0yote/privatechain/types"
 )
 
 type Instruction byte
@@ -16,140 +23,726 @@ const (
 	InstrGet      Instruction = 0x10
 	InstrMul      Instruction = 0x11
 	InstrDiv      Instruction = 0x12
+
+	// Comparisons and logic push a Bool. Binary operators compare the
+	// second value against the top one, so "a b LT" pushes a < b. The logic
+	// instructions take Bools, ints and bytes, see toBool.
+	InstrEq  Instruction = 0x13
+	InstrLt  Instruction = 0x14
+	InstrGt  Instruction = 0x15
+	InstrNot Instruction = 0x16
+	InstrAnd Instruction = 0x17
+	InstrOr  Instruction = 0x18
+
+	// InstrJump pops the destination, InstrJumpI pops the destination and
+	// then the condition and only jumps if the condition is true. The
+	// destination has to be an InstrJumpDest.
+	InstrJump     Instruction = 0x19
+	InstrJumpI    Instruction = 0x1a
+	InstrJumpDest Instruction = 0x1b
+
+	InstrDup  Instruction = 0x1c
+	InstrSwap Instruction = 0x1d
+	InstrPop  Instruction = 0x1e
+
+	// InstrHalt stops the execution, InstrReturn stops it as well and pops
+	// the value returned by VM.Result.
+	InstrHalt   Instruction = 0x1f
+	InstrReturn Instruction = 0x20
+
+	// InstrEmit pops the number of topics, the topics and then the data of
+	// an event, so "data topic1 topic2 2 EMIT" emits an event with two
+	// topics. Topics are bytes of up to MaxTopicSize bytes, the data is any
+	// value and encoded with EncodeValue. Only valid in version 1 code.
+	InstrEmit Instruction = 0x38
+)
+
+// MaxTopicSize is the maximum size of an event topic.
+const MaxTopicSize = 32
+
+// MaxStackDepth is the maximum number of values on the VM stack.
+const MaxStackDepth = 1024
+
+// Gas costs of the VM. Every executed byte costs at least GasStep.
+const (
+	GasStep       uint64 = 1
+	GasArithmetic uint64 = 3
+	GasJump       uint64 = 8
+	GasPackByte   uint64 = 1
+	GasGet        uint64 = 50
+	GasStore      uint64 = 200
+	GasStoreByte  uint64 = 5
+	GasEmit       uint64 = 100
+	GasEmitByte   uint64 = 2
+)
+
+var (
+	ErrOutOfGas       = errors.New("out of gas")
+	ErrStackOverflow  = errors.New("stack overflow")
+	ErrStackUnderflow = errors.New("stack underflow")
+	ErrInvalidOperand = errors.New("invalid operand")
+	ErrDivisionByZero = errors.New("division by zero")
+	ErrInvalidJump    = errors.New("invalid jump destination")
+	ErrIntOverflow    = errors.New("integer overflow")
+	ErrVMFault        = errors.New("vm fault")
 )
 
+// VMError is returned for every fault while running code. It wraps one of
+// the VM error values above, or the error of the contract state.
+type VMError struct {
+	IP    int
+	Instr Instruction
+	Err   error
+}
+
+func (e *VMError) Error() string {
+	return fmt.Sprintf("vm: %s at %d (instruction 0x%02x)", e.Err, e.IP, byte(e.Instr))
+}
+
+func (e *VMError) Unwrap() error {
+	return e.Err
+}
+
+// Stack is a fixed capacity stack. sp is the number of values on the stack
+// and the index of the next free slot.
 type Stack struct {
-	data []any
+	data []Value
 	sp   int
 }
 
 func NewStack(size int) *Stack {
 	return &Stack{
-		data: make([]any, size),
+		data: make([]Value, size),
 		sp:   0,
 	}
 }
 
-func (s *Stack) Push(v any) {
-	// s.data[s.sp] = v
-	s.data = append([]any{v}, s.data...)
+func (s *Stack) Push(v Value) error {
+	if s.sp == len(s.data) {
+		return ErrStackOverflow
+	}
+
+	s.data[s.sp] = v
 	s.sp++
+
+	return nil
 }
 
-func (s *Stack) Pop() any {
-	value := s.data[0]
-	s.data = append(s.data[:0], s.data[1:]...)
+func (s *Stack) Pop() (Value, error) {
+	if s.sp == 0 {
+		return nil, ErrStackUnderflow
+	}
+
 	s.sp--
+	value := s.data[s.sp]
+	// Let the value be collected.
+	s.data[s.sp] = nil
 
-	return value
+	return value, nil
+}
+
+func (s *Stack) Len() int {
+	return s.sp
 }
 
 type VM struct {
 	data          []byte
+	version       byte
+	codeErr       error
 	ip            int
+	next          int
+	jumpDests     []bool
+	halted        bool
+	result        Value
 	stack         *Stack
-	contractState *State
+	contractState ContractStorage
+	ctx           VMContext
+	// writes buffers the stores of the contract, and of the contracts it
+	// called, until Run succeeds.
+	writes map[string]pendingWrite
+	// events are emitted once Run succeeds.
+	events []Event
+	// parent is the VM that called this one with InstrCall.
+	parent  *VM
+	gas     uint64
+	gasUsed uint64
 }
 
-func NewVM(data []byte, contractState *State) *VM {
+// NewVM returns a VM running data against contractState in ctx that may use
+// up to gas units of gas. data is either legacy or versioned code, see
+// CodeMagic.
+func NewVM(data []byte, contractState ContractStorage, gas uint64, ctx VMContext) *VM {
+	version, code, err := ParseCode(data)
+
 	return &VM{
-		data:          data,
+		data:          code,
+		version:       version,
+		codeErr:       err,
 		ip:            0,
-		stack:         NewStack(128),
+		jumpDests:     analyzeJumpDests(version, code),
+		stack:         NewStack(MaxStackDepth),
 		contractState: contractState,
+		ctx:           ctx,
+		writes:        make(map[string]pendingWrite),
+		gas:           gas,
 	}
 }
 
+// Result returns the value popped by InstrReturn, or nil.
+func (vm *VM) Result() Value {
+	return vm.result
+}
+
+// Events returns the events emitted by the code. They are only meaningful
+// if Run succeeded.
+func (vm *VM) Events() []Event {
+	return vm.events
+}
+
+// GasUsed returns the gas used so far. After ErrOutOfGas it is the full
+// amount given to NewVM.
+func (vm *VM) GasUsed() uint64 {
+	return vm.gasUsed
+}
+
+// Run executes the code. The stores of the contract are only written to the
+// contract state when the code runs to completion.
 func (vm *VM) Run() error {
-	for {
-		instr := Instruction(vm.data[vm.ip])
+	if err := vm.run(); err != nil {
+		return err
+	}
+
+	// Flush in key order so the journal records the same changes on every
+	// node.
+	keys := make([]string, 0, len(vm.writes))
+	for k := range vm.writes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		w := vm.writes[k]
+		if err := w.storage.Put(w.key, w.value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// run executes the code without writing anything to storage.
+func (vm *VM) run() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = vm.fault(vm.currentInstr(), fmt.Errorf("%w: %v", ErrVMFault, r))
+		}
+	}()
+
+	if vm.codeErr != nil {
+		return vm.fault(0, vm.codeErr)
+	}
+
+	for !vm.halted && vm.ip < len(vm.data) {
+		vm.next = vm.ip + 1
+
+		instr := vm.currentInstr()
+		if err := vm.traceStep(instr); err != nil {
+			return vm.fault(instr, err)
+		}
 		if err := vm.Exec(instr); err != nil {
 			return err
 		}
 
-		vm.ip++
+		vm.ip = vm.next
+	}
+
+	return nil
+}
 
-		if vm.ip > len(vm.data)-1 {
-			break
+// pendingWrite is a store that is not yet written to storage.
+type pendingWrite struct {
+	storage ContractStorage
+	key     []byte
+	value   []byte
+}
+
+func writeKey(address types.Address, key []byte) string {
+	return string(address.ToSlice()) + string(key)
+}
+
+// load returns the value stored under key, looking at the pending writes of
+// this VM and its callers first.
+func (vm *VM) load(key []byte) ([]byte, error) {
+	k := writeKey(vm.ctx.Address, key)
+	for v := vm; v != nil; v = v.parent {
+		if w, ok := v.writes[k]; ok {
+			vm.traceStorage(key, w.value, false)
+			return w.value, nil
 		}
 	}
 
+	value, err := vm.contractState.Get(key)
+	vm.traceStorage(key, value, false)
+
+	return value, err
+}
+
+func (vm *VM) store(key, value []byte) {
+	vm.traceStorage(key, value, true)
+
+	vm.writes[writeKey(vm.ctx.Address, key)] = pendingWrite{
+		storage: vm.contractState,
+		key:     key,
+		value:   value,
+	}
+}
+
+func (vm *VM) currentInstr() Instruction {
+	if vm.ip < len(vm.data) {
+		return Instruction(vm.data[vm.ip])
+	}
+
+	return 0
+}
+
+func (vm *VM) fault(instr Instruction, err error) *VMError {
+	return &VMError{IP: vm.ip, Instr: instr, Err: err}
+}
+
+func (vm *VM) useGas(gas uint64) error {
+	if vm.gas-vm.gasUsed < gas {
+		vm.gasUsed = vm.gas
+		return ErrOutOfGas
+	}
+
+	vm.gasUsed += gas
+	return nil
+}
+
+func (vm *VM) push(v Value) error {
+	return vm.stack.Push(v)
+}
+
+func (vm *VM) pop() (Value, error) {
+	return vm.stack.Pop()
+}
+
+func popAs[T Value](vm *VM) (T, error) {
+	var zero T
+
+	v, err := vm.pop()
+	if err != nil {
+		return zero, err
+	}
+
+	t, ok := v.(T)
+	if !ok {
+		return zero, fmt.Errorf("%w: expected %s, got %s", ErrInvalidOperand, zero.Kind(), v.Kind())
+	}
+
+	return t, nil
+}
+
+func (vm *VM) jump(dest Int) error {
+	if dest < 0 || dest >= Int(len(vm.jumpDests)) || !vm.jumpDests[dest] {
+		return fmt.Errorf("%w (%d)", ErrInvalidJump, dest)
+	}
+
+	vm.next = int(dest)
 	return nil
 }
 
+// popBool pops a value and converts it with toBool.
+func (vm *VM) popBool() (Bool, error) {
+	v, err := vm.pop()
+	if err != nil {
+		return false, err
+	}
+
+	return toBool(v)
+}
+
+// encodeStored encodes a value written with InstrStore. Version 1 code
+// stores any value with EncodeValue, legacy code keeps storing ints as 8
+// byte little endian integers.
+func (vm *VM) encodeStored(value Value) ([]byte, error) {
+	if vm.version != CodeVersionLegacy {
+		return EncodeValue(value), nil
+	}
+
+	n, ok := value.(Int)
+	if !ok {
+		return nil, fmt.Errorf("%w: cannot store %s", ErrInvalidOperand, value.Kind())
+	}
+
+	return serializeInt64(int64(n)), nil
+}
+
+// operand returns the byte preceding the current instruction.
+func (vm *VM) operand() (byte, error) {
+	if vm.ip == 0 {
+		return 0, fmt.Errorf("%w: missing immediate", ErrInvalidOperand)
+	}
+
+	return vm.data[vm.ip-1], nil
+}
+
 func (vm *VM) Exec(instr Instruction) error {
+	if err := vm.exec(instr); err != nil {
+		var vmErr *VMError
+		if errors.As(err, &vmErr) {
+			return err
+		}
+		return vm.fault(instr, err)
+	}
+
+	return nil
+}
+
+func (vm *VM) exec(instr Instruction) error {
+	if vm.version == CodeVersionLegacy && isVersion1Instr(instr) {
+		return vm.useGas(GasStep)
+	}
+
+	if err := vm.useGas(instrGas(instr)); err != nil {
+		return err
+	}
+
+	if vm.version != CodeVersionLegacy {
+		if ok, err := vm.execPush(instr); ok {
+			return err
+		}
+		if ok, err := vm.execContext(instr); ok {
+			return err
+		}
+		if ok, err := vm.execConversion(instr); ok {
+			return err
+		}
+		if instr == InstrPushInt || instr == InstrPushByte {
+			return ErrInvalidOpcode
+		}
+	}
+
 	switch instr {
 	case InstrGet:
+		key, err := popAs[Bytes](vm)
+		if err != nil {
+			return err
+		}
+
+		encoded, err := vm.load(key)
+		if err != nil {
+			return err
+		}
 
-		key := vm.stack.Pop().([]byte)
+		// Legacy code reads back the raw bytes it stored.
+		if vm.version == CodeVersionLegacy {
+			return vm.push(Bytes(encoded))
+		}
 
-		value, err := vm.contractState.Get(key)
+		value, err := DecodeValue(encoded)
 		if err != nil {
 			return err
 		}
 
-		vm.stack.Push(value)
+		return vm.push(value)
 
 	case InstrStore:
-		var (
-			key             = vm.stack.Pop().([]byte)
-			value           = vm.stack.Pop()
-			serializedValue []byte
-		)
-
-		switch v := value.(type) {
-		case int:
-			serializedValue = serializeInt64(int64(v))
-		default:
-			panic("TODO: unknown type")
+		key, err := popAs[Bytes](vm)
+		if err != nil {
+			return err
+		}
+		value, err := vm.pop()
+		if err != nil {
+			return err
 		}
 
-		vm.contractState.Put(key, serializedValue)
+		encoded, err := vm.encodeStored(value)
+		if err != nil {
+			return err
+		}
+		if err := vm.useGas(uint64(len(key)+len(encoded)) * GasStoreByte); err != nil {
+			return err
+		}
 
-	case InstrPushInt:
-		vm.stack.Push(int(vm.data[vm.ip-1]))
+		vm.store(key, encoded)
 
-	case InstrPushByte:
-		vm.stack.Push(byte(vm.data[vm.ip-1]))
+	case InstrPushInt, InstrPushByte:
+		b, err := vm.operand()
+		if err != nil {
+			return err
+		}
+		return vm.push(Int(b))
 
 	case InstrPack:
-		n := vm.stack.Pop().(int)
-		b := make([]byte, n)
+		n, err := popAs[Int](vm)
+		if err != nil {
+			return err
+		}
+		if n < 0 || n > Int(vm.stack.Len()) {
+			return fmt.Errorf("%w: cannot pack %d bytes", ErrStackUnderflow, n)
+		}
+		if err := vm.useGas(uint64(n) * GasPackByte); err != nil {
+			return err
+		}
 
-		for i := 0; i < n; i++ {
-			b[i] = vm.stack.Pop().(byte)
+		b := make(Bytes, n)
+		for i := range b {
+			c, err := popAs[Int](vm)
+			if err != nil {
+				return err
+			}
+			if c < 0 || c > 0xff {
+				return fmt.Errorf("%w: cannot pack %d as a byte", ErrInvalidOperand, c)
+			}
+			b[i] = byte(c)
 		}
 
-		vm.stack.Push(b)
+		return vm.push(b)
 
-	case InstrAdd:
-		a := vm.stack.Pop().(int)
-		b := vm.stack.Pop().(int)
-		c := a + b
-		vm.stack.Push(c)
+	case InstrAdd, InstrSub, InstrMul:
+		a, err := popAs[Int](vm)
+		if err != nil {
+			return err
+		}
+		b, err := popAs[Int](vm)
+		if err != nil {
+			return err
+		}
 
-	case InstrSub:
-		a := vm.stack.Pop().(int)
-		b := vm.stack.Pop().(int)
-		c := a - b
-		vm.stack.Push(c)
+		var r Int
+		switch instr {
+		case InstrAdd:
+			r, err = addInt(a, b)
+		case InstrSub:
+			r, err = subInt(a, b)
+		default:
+			r, err = mulInt(a, b)
+		}
+		if err != nil {
+			return err
+		}
 
-	case InstrMul:
-		a := vm.stack.Pop().(int)
-		b := vm.stack.Pop().(int)
-		c := a * b
-		vm.stack.Push(c)
+		return vm.push(r)
 
 	case InstrDiv:
-		b := vm.stack.Pop().(int)
-		a := vm.stack.Pop().(int)
-		c := a / b
-		vm.stack.Push(c)
+		b, err := popAs[Int](vm)
+		if err != nil {
+			return err
+		}
+		a, err := popAs[Int](vm)
+		if err != nil {
+			return err
+		}
+		if b == 0 {
+			return ErrDivisionByZero
+		}
+		if a == math.MinInt64 && b == -1 {
+			return ErrIntOverflow
+		}
+
+		return vm.push(a / b)
+
+	case InstrEq:
+		b, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		a, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		if a.Kind() != b.Kind() {
+			return fmt.Errorf("%w: cannot compare %s and %s", ErrInvalidOperand, a.Kind(), b.Kind())
+		}
+
+		if a, ok := a.(Bytes); ok {
+			return vm.push(Bool(bytes.Equal(a, b.(Bytes))))
+		}
+
+		return vm.push(Bool(a == b))
+
+	case InstrLt, InstrGt:
+		b, err := popAs[Int](vm)
+		if err != nil {
+			return err
+		}
+		a, err := popAs[Int](vm)
+		if err != nil {
+			return err
+		}
+
+		if instr == InstrLt {
+			return vm.push(Bool(a < b))
+		}
+		return vm.push(Bool(a > b))
+
+	case InstrAnd, InstrOr:
+		b, err := vm.popBool()
+		if err != nil {
+			return err
+		}
+		a, err := vm.popBool()
+		if err != nil {
+			return err
+		}
+
+		if instr == InstrAnd {
+			return vm.push(a && b)
+		}
+		return vm.push(a || b)
+
+	case InstrNot:
+		a, err := vm.popBool()
+		if err != nil {
+			return err
+		}
+
+		return vm.push(!a)
+
+	case InstrJump:
+		dest, err := popAs[Int](vm)
+		if err != nil {
+			return err
+		}
+
+		return vm.jump(dest)
+
+	case InstrJumpI:
+		dest, err := popAs[Int](vm)
+		if err != nil {
+			return err
+		}
+		cond, err := vm.popBool()
+		if err != nil {
+			return err
+		}
+		if !cond {
+			return nil
+		}
+
+		return vm.jump(dest)
+
+	case InstrDup:
+		v, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		if err := vm.push(v); err != nil {
+			return err
+		}
+
+		return vm.push(v)
+
+	case InstrSwap:
+		a, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		b, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		if err := vm.push(a); err != nil {
+			return err
+		}
+
+		return vm.push(b)
+
+	case InstrPop:
+		_, err := vm.pop()
+		return err
+
+	case InstrJumpDest:
+
+	case InstrEmit:
+		return vm.emit()
+
+	case InstrCall:
+		return vm.execCall()
+
+	case InstrHalt:
+		vm.halted = true
+
+	case InstrReturn:
+		v, err := vm.pop()
+		if err != nil {
+			return err
+		}
+		vm.result = v
+		vm.halted = true
+
+	default:
+		if vm.version != CodeVersionLegacy {
+			return ErrInvalidOpcode
+		}
 	}
 
 	return nil
 }
 
+func (vm *VM) emit() error {
+	n, err := popAs[Int](vm)
+	if err != nil {
+		return err
+	}
+	if n < 0 || n > MaxEventTopics {
+		return fmt.Errorf("%w: cannot emit %d topics", ErrInvalidOperand, n)
+	}
+
+	size := 0
+	topics := make([][]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		topic, err := popAs[Bytes](vm)
+		if err != nil {
+			return err
+		}
+		topics[i] = topic
+		if len(topics[i]) > MaxTopicSize {
+			return fmt.Errorf("%w: topic of %d bytes", ErrInvalidOperand, len(topics[i]))
+		}
+		size += len(topics[i])
+	}
+
+	value, err := vm.pop()
+	if err != nil {
+		return err
+	}
+
+	data := EncodeValue(value)
+	if err := vm.useGas(uint64(size+len(data)) * GasEmitByte); err != nil {
+		return err
+	}
+
+	vm.events = append(vm.events, Event{
+		Address: vm.ctx.Address,
+		Topics:  topics,
+		Data:    data,
+	})
+
+	return nil
+}
+
+func instrGas(instr Instruction) uint64 {
+	switch instr {
+	case InstrAdd, InstrSub, InstrMul, InstrDiv, InstrPack,
+		InstrEq, InstrLt, InstrGt, InstrNot, InstrAnd, InstrOr:
+		return GasArithmetic
+	case InstrJump, InstrJumpI:
+		return GasJump
+	case InstrGet:
+		return GasGet
+	case InstrStore:
+		return GasStore
+	case InstrEmit:
+		return GasEmit
+	case InstrCall:
+		return GasCall
+	default:
+		return GasStep
+	}
+}
+
 func serializeInt64(value int64) []byte {
 	buf := make([]byte, 8)
 
@@ -159,6 +752,5 @@ func serializeInt64(value int64) []byte {
 }
 
 func deSerializeInt64(b []byte) int64 {
-
 	return int64(binary.LittleEndian.Uint64(b))
 }
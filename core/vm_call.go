@@ -0,0 +1,137 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/k0yote/privatechain/types"
+)
+
+// InstrCall calls another contract. It pops the gas budget, the contract
+// address, the number of arguments and then the arguments, which start out
+// on the stack of the callee in the same order. It pushes the result of the
+// callee, empty bytes if there is none or the call failed, followed by a
+// Bool reporting whether the call succeeded. A failed callee has its stores
+// and events discarded while the caller keeps running. Only valid in version
+// 1 code.
+const InstrCall Instruction = 0x39
+
+// DefaultMaxCallDepth is the call depth used when VMContext.MaxCallDepth is
+// zero.
+const DefaultMaxCallDepth = 64
+
+const GasCall uint64 = 100
+
+var ErrMaxCallDepth = errors.New("max call depth exceeded")
+
+// ContractHost gives a VM access to other contracts.
+type ContractHost interface {
+	ContractCode(address types.Address) ([]byte, error)
+	ContractStorage(address types.Address) ContractStorage
+	Balance(address types.Address) uint64
+}
+
+func (vm *VM) maxCallDepth() int {
+	if vm.ctx.MaxCallDepth > 0 {
+		return vm.ctx.MaxCallDepth
+	}
+
+	return DefaultMaxCallDepth
+}
+
+func (vm *VM) execCall() error {
+	gas, err := popAs[Int](vm)
+	if err != nil {
+		return err
+	}
+	if gas < 0 {
+		return fmt.Errorf("%w: negative gas (%d)", ErrInvalidOperand, gas)
+	}
+	address, err := popAs[Bytes](vm)
+	if err != nil {
+		return err
+	}
+	if len(address) != 20 {
+		return fmt.Errorf("%w: address of %d bytes", ErrInvalidOperand, len(address))
+	}
+	n, err := popAs[Int](vm)
+	if err != nil {
+		return err
+	}
+	if n < 0 || n > Int(vm.stack.Len()) {
+		return fmt.Errorf("%w: cannot pass %d arguments", ErrStackUnderflow, n)
+	}
+
+	args := make([]Value, n)
+	for i := n - 1; i >= 0; i-- {
+		if args[i], err = vm.pop(); err != nil {
+			return err
+		}
+	}
+
+	if vm.ctx.Depth >= vm.maxCallDepth() {
+		return ErrMaxCallDepth
+	}
+	if vm.ctx.Host == nil {
+		return fmt.Errorf("%w: no contract host", ErrContractNotFound)
+	}
+
+	callee := types.AddressFromBytes(address)
+	code, err := vm.ctx.Host.ContractCode(callee)
+	if err != nil {
+		return vm.pushCallResult(nil, false)
+	}
+
+	// The callee gets at most the gas left to the caller.
+	budget := vm.gas - vm.gasUsed
+	if uint64(gas) < budget {
+		budget = uint64(gas)
+	}
+
+	ctx := vm.ctx
+	ctx.Caller = vm.ctx.Address
+	// Transaction data has no address of its own, its calls are made by
+	// the sender of the transaction.
+	if vm.ctx.Address == (types.Address{}) {
+		ctx.Caller = vm.ctx.Caller
+	}
+	ctx.Value = 0
+	ctx.Address = callee
+	ctx.Balance = vm.ctx.Host.Balance(callee)
+	ctx.Depth++
+
+	child := NewVM(code, vm.ctx.Host.ContractStorage(callee), budget, ctx)
+	child.parent = vm
+	for _, arg := range args {
+		if err := child.push(arg); err != nil {
+			return err
+		}
+	}
+
+	callErr := child.run()
+	if err := vm.useGas(child.GasUsed()); err != nil {
+		return err
+	}
+
+	if callErr != nil {
+		return vm.pushCallResult(nil, false)
+	}
+
+	for k, w := range child.writes {
+		vm.writes[k] = w
+	}
+	vm.events = append(vm.events, child.events...)
+
+	return vm.pushCallResult(child.Result(), true)
+}
+
+func (vm *VM) pushCallResult(result Value, ok bool) error {
+	if result == nil {
+		result = Bytes{}
+	}
+	if err := vm.push(result); err != nil {
+		return err
+	}
+
+	return vm.push(Bool(ok))
+}
@@ -0,0 +1,84 @@
+package core
+
+import (
+	"fmt"
+
+	"github.com/k0yote/privatechain/types"
+)
+
+// Context instructions of version 1 code. They push the caller address as
+// bytes and everything else as an integer.
+const (
+	InstrCaller      Instruction = 0x30
+	InstrCallValue   Instruction = 0x31
+	InstrHeight      Instruction = 0x32
+	InstrTimestamp   Instruction = 0x33
+	InstrSelfBalance Instruction = 0x34
+)
+
+// VMContext describes the transaction and block the code runs in.
+type VMContext struct {
+	// Caller is the sender of the transaction.
+	Caller types.Address
+	// Value is the native value sent along with the transaction.
+	Value     uint64
+	Height    uint32
+	Timestamp int64
+	// Address is the address of the running contract and Balance its
+	// balance when the code starts. Both are zero for transaction data.
+	Address types.Address
+	Balance uint64
+
+	// Host resolves the contracts called with InstrCall. Depth is the
+	// number of calls leading to the running code, limited to
+	// MaxCallDepth, or DefaultMaxCallDepth if that is zero.
+	Host         ContractHost
+	Depth        int
+	MaxCallDepth int
+
+	// Tracer is called for every step and storage access if set.
+	Tracer Tracer
+}
+
+func (bc *Blockchain) newVMContext(tx *Transaction, b *Block) VMContext {
+	return VMContext{
+		Caller:       tx.From.Address(),
+		Value:        tx.Value,
+		Height:       b.Height,
+		Timestamp:    b.Timestamp,
+		Host:         vmHost{bc},
+		MaxCallDepth: bc.maxCallDepth,
+		Tracer:       bc.tracer,
+	}
+}
+
+func isContextInstr(instr Instruction) bool {
+	return instr >= InstrCaller && instr <= InstrSelfBalance
+}
+
+// execContext runs the context instructions and reports whether instr was
+// one of them.
+func (vm *VM) execContext(instr Instruction) (bool, error) {
+	switch instr {
+	case InstrCaller:
+		return true, vm.push(Bytes(vm.ctx.Caller.ToSlice()))
+	case InstrCallValue:
+		return true, vm.pushUint(vm.ctx.Value)
+	case InstrHeight:
+		return true, vm.push(Int(vm.ctx.Height))
+	case InstrTimestamp:
+		return true, vm.push(Int(vm.ctx.Timestamp))
+	case InstrSelfBalance:
+		return true, vm.pushUint(vm.ctx.Balance)
+	}
+
+	return false, nil
+}
+
+func (vm *VM) pushUint(v uint64) error {
+	if v > 1<<63-1 {
+		return fmt.Errorf("%w: %d overflows int64", ErrInvalidOperand, v)
+	}
+
+	return vm.push(Int(v))
+}
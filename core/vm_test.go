@@ -1,26 +1,39 @@
 package core
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"testing"
 
+	"github.com/k0yote/privatechain/types"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestStack(t *testing.T) {
 	s := NewStack(128)
-	s.Push(1)
-	s.Push("a")
+	assert.Nil(t, s.Push(Int(1)))
+	assert.Nil(t, s.Push(Bytes("a")))
+	assert.Equal(t, 2, s.Len())
 
-	assert.Equal(t, "a", s.Pop())
+	v, err := s.Pop()
+	assert.Nil(t, err)
+	assert.Equal(t, Bytes("a"), v)
 
-	assert.Equal(t, 1, s.Pop())
+	v, err = s.Pop()
+	assert.Nil(t, err)
+	assert.Equal(t, Int(1), v)
+
+	_, err = s.Pop()
+	assert.ErrorIs(t, err, ErrStackUnderflow)
 }
 
-func TestStackBytes(t *testing.T) {
-	s := NewStack(128)
-	s.Push(2)
-	s.Push(0x61)
-	s.Push(0x62)
+func TestStackOverflow(t *testing.T) {
+	s := NewStack(2)
+	assert.Nil(t, s.Push(Int(1)))
+	assert.Nil(t, s.Push(Int(2)))
+	assert.ErrorIs(t, s.Push(Int(3)), ErrStackOverflow)
+	assert.Equal(t, 2, s.Len())
 }
 
 func TestVM(t *testing.T) {
@@ -30,14 +43,21 @@ func TestVM(t *testing.T) {
 	data = append(data, pushFoo...)
 
 	contractState := NewState()
-	vm := NewVM(data, contractState)
+	vm := NewVM(data, contractState, 10_000, VMContext{})
 	assert.Nil(t, vm.Run())
 	// fmt.Printf("%+v\n", vm.stack.data)
-	value := vm.stack.Pop().([]byte)
+	result, err := vm.stack.Pop()
+	assert.Nil(t, err)
+	value := result.(Bytes)
 	valueDeSerialize := deSerializeInt64(value)
 
 	assert.Equal(t, valueDeSerialize, int64(5))
 
+	// Legacy code keeps storing 8 byte little endian integers.
+	stored, err := contractState.Get([]byte("FOO"))
+	assert.Nil(t, err)
+	assert.Equal(t, serializeInt64(5), stored)
+
 	// valueBytes, err := contractState.Get([]byte("FOO"))
 	// assert.Nil(t, err)
 
@@ -50,20 +70,685 @@ func TestVMMul(t *testing.T) {
 	data := []byte{0x02, 0x0a, 0x03, 0x0a, 0x11}
 
 	contractState := NewState()
-	vm := NewVM(data, contractState)
+	vm := NewVM(data, contractState, 10_000, VMContext{})
 	assert.Nil(t, vm.Run())
 
-	result := vm.stack.Pop()
-	assert.Equal(t, 6, result)
+	result, err := vm.stack.Pop()
+	assert.Nil(t, err)
+	assert.Equal(t, Int(6), result)
 }
 
 func TestVMDiv(t *testing.T) {
 	data := []byte{0x09, 0x0a, 0x03, 0x0a, 0x12}
 
 	contractState := NewState()
-	vm := NewVM(data, contractState)
+	vm := NewVM(data, contractState, 10_000, VMContext{})
+	assert.Nil(t, vm.Run())
+
+	result, err := vm.stack.Pop()
+	assert.Nil(t, err)
+	assert.Equal(t, Int(3), result)
+}
+
+func TestVMOutOfGas(t *testing.T) {
+	data := []byte{0x02, 0x0a, 0x03, 0x0a, 0x11}
+
+	vm := NewVM(data, NewState(), 4, VMContext{})
+	err := vm.Run()
+	assert.True(t, errors.Is(err, ErrOutOfGas))
+	assert.Equal(t, uint64(4), vm.GasUsed())
+
+	vm = NewVM(data, NewState(), 7, VMContext{})
+	assert.Nil(t, vm.Run())
+	assert.Equal(t, uint64(7), vm.GasUsed())
+}
+
+func TestVMFaults(t *testing.T) {
+	tests := map[string]struct {
+		data []byte
+		err  error
+	}{
+		"division by zero": {[]byte{0x09, 0x0a, 0x00, 0x0a, 0x12}, ErrDivisionByZero},
+		"stack underflow":  {[]byte{0x0b}, ErrStackUnderflow},
+		"missing operand":  {[]byte{0x0a}, ErrInvalidOperand},
+		"invalid operand":  {[]byte{0x4f, 0x0c, 0x01, 0x0a, 0x0d, 0x01, 0x0a, 0x0b}, ErrInvalidOperand},
+		"pack underflow":   {[]byte{0x05, 0x0a, 0x0d}, ErrStackUnderflow},
+		"stack overflow":   {bytes.Repeat([]byte{0x01, 0x0a}, MaxStackDepth+1), ErrStackOverflow},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := NewVM(tc.data, NewState(), 100_000, VMContext{}).Run()
+
+			var vmErr *VMError
+			assert.True(t, errors.As(err, &vmErr))
+			assert.True(t, errors.Is(err, tc.err), err)
+		})
+	}
+}
+
+func TestVMRevertsStoresOnFault(t *testing.T) {
+	// Store 5 under FOO, then divide by zero.
+	data := []byte{0x02, 0x0a, 0x03, 0x0a, 0x0b, 0x4f, 0x0c, 0x4f, 0x0c, 0x46, 0x0c, 0x03, 0x0a, 0x0d, 0x0f}
+	data = append(data, 0x01, 0x0a, 0x00, 0x0a, 0x12)
+
+	contractState := NewState()
+	assert.True(t, errors.Is(NewVM(data, contractState, 100_000, VMContext{}).Run(), ErrDivisionByZero))
+
+	_, err := contractState.Get([]byte("FOO"))
+	assert.NotNil(t, err)
+}
+
+func TestVMLoop(t *testing.T) {
+	// acc := 0; i := 5; do { acc += 2; i-- } while i != 0; return acc
+	data := NewCode(CodeVersion1, []byte{
+		0x60, 0x00, // PUSH1 acc
+		0x60, 0x05, // PUSH1 i
+		0x1b,       // loop: JUMPDEST
+		0x1d,       // SWAP
+		0x60, 0x02, // PUSH1 2
+		0x0b,       // ADD
+		0x1d,       // SWAP
+		0x60, 0x01, // PUSH1 1
+		0x1d,       // SWAP
+		0x0e,       // SUB
+		0x1c,       // DUP
+		0x60, 0x04, // PUSH1 loop
+		0x1a, // JUMPI
+		0x1e, // POP
+		0x20, // RETURN
+	})
+
+	vm := NewVM(data, NewState(), 10_000, VMContext{})
+	assert.Nil(t, vm.Run())
+	assert.Equal(t, Int(10), vm.Result())
+	assert.Equal(t, 0, vm.stack.Len())
+}
+
+func TestVMConditional(t *testing.T) {
+	// if a < b { return 1 } return 2
+	program := func(a, b byte) []byte {
+		return NewCode(CodeVersion1, []byte{
+			0x60, 0x07, // PUSH1 main
+			0x19,       // JUMP
+			0x1b,       // then: JUMPDEST
+			0x60, 0x01, // PUSH1 1
+			0x20,    // RETURN
+			0x1b,    // main: JUMPDEST
+			0x60, a, // PUSH1 a
+			0x60, b, // PUSH1 b
+			0x14,       // LT
+			0x60, 0x03, // PUSH1 then
+			0x1a,       // JUMPI
+			0x60, 0x02, // PUSH1 2
+			0x20, // RETURN
+		})
+	}
+
+	vm := NewVM(program(1, 2), NewState(), 10_000, VMContext{})
+	assert.Nil(t, vm.Run())
+	assert.Equal(t, Int(1), vm.Result())
+
+	vm = NewVM(program(3, 2), NewState(), 10_000, VMContext{})
+	assert.Nil(t, vm.Run())
+	assert.Equal(t, Int(2), vm.Result())
+}
+
+func TestVMLogic(t *testing.T) {
+	tests := []struct {
+		data   []byte
+		result Value
+	}{
+		{[]byte{0x60, 0x03, 0x60, 0x03, 0x13, 0x20}, Bool(true)},
+		{[]byte{0x60, 0x03, 0x60, 0x04, 0x13, 0x20}, Bool(false)},
+		{[]byte{0x60, 0x03, 0x60, 0x02, 0x15, 0x20}, Bool(true)},
+		{[]byte{0x60, 0x00, 0x16, 0x20}, Bool(true)},
+		{[]byte{0x60, 0x01, 0x60, 0x00, 0x17, 0x20}, Bool(false)},
+		{[]byte{0x60, 0x01, 0x60, 0x00, 0x18, 0x20}, Bool(true)},
+	}
+
+	for _, tc := range tests {
+		vm := NewVM(NewCode(CodeVersion1, tc.data), NewState(), 10_000, VMContext{})
+		assert.Nil(t, vm.Run())
+		assert.Equal(t, tc.result, vm.Result())
+	}
+}
+
+func TestVMHalt(t *testing.T) {
+	data := NewCode(CodeVersion1, []byte{0x60, 0x01, 0x1f, 0x60, 0x02})
+
+	vm := NewVM(data, NewState(), 10_000, VMContext{})
+	assert.Nil(t, vm.Run())
+	assert.Nil(t, vm.Result())
+	assert.Equal(t, 1, vm.stack.Len())
+
+	// HALT is a no-op in legacy code.
+	vm = NewVM([]byte{0x01, 0x0a, 0x1f, 0x02, 0x0a}, NewState(), 10_000, VMContext{})
+	assert.Nil(t, vm.Run())
+	assert.Equal(t, 2, vm.stack.Len())
+}
+
+func TestVMInvalidJump(t *testing.T) {
+	// Position 3 holds PUSH1, not JUMPDEST.
+	data := NewCode(CodeVersion1, []byte{0x60, 0x03, 0x19, 0x60, 0x00})
+
+	err := NewVM(data, NewState(), 10_000, VMContext{}).Run()
+	assert.True(t, errors.Is(err, ErrInvalidJump))
+
+	// Infinite loops run out of gas.
+	data = NewCode(CodeVersion1, []byte{0x1b, 0x60, 0x00, 0x19})
+	err = NewVM(data, NewState(), 10_000, VMContext{}).Run()
+	assert.True(t, errors.Is(err, ErrOutOfGas))
+}
+
+func TestVMVersion1Push(t *testing.T) {
+	code := NewCode(CodeVersion1, []byte{
+		0x61, 0x01, 0x2c, // PUSH2 300
+		0x60, 0x05, // PUSH1 5
+		0x0b,                                                 // ADD
+		0x21, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xf9, // PUSH_INT64 -7
+		0x0b, // ADD
+		0x20, // RETURN
+	})
+
+	vm := NewVM(code, NewState(), 10_000, VMContext{})
+	assert.Nil(t, vm.Run())
+	assert.Equal(t, Int(298), vm.Result())
+}
+
+func TestVMVersion1Store(t *testing.T) {
+	code := NewCode(CodeVersion1, []byte{
+		0x61, 0x01, 0x00, // PUSH2 256
+		0x22, 0x00, 0x03, 'F', 'O', 'O', // PUSH_BYTES "FOO"
+		0x0f,                            // STORE
+		0x22, 0x00, 0x03, 'F', 'O', 'O', // PUSH_BYTES "FOO"
+		0x10, // GET
+		0x20, // RETURN
+	})
+
+	contractState := NewState()
+	vm := NewVM(code, contractState, 10_000, VMContext{})
+	assert.Nil(t, vm.Run())
+	assert.Equal(t, Int(256), vm.Result())
+
+	value, err := contractState.Get([]byte("FOO"))
+	assert.Nil(t, err)
+	assert.Equal(t, EncodeValue(Int(256)), value)
+}
+
+func TestVMVersion1Loop(t *testing.T) {
+	// acc := 0; i := 1000; do { acc += 2; i-- } while i != 0; return acc
+	code := NewCode(CodeVersion1, []byte{
+		0x60, 0x00, // PUSH1 0
+		0x61, 0x03, 0xe8, // PUSH2 1000
+		0x1b,       // loop: JUMPDEST
+		0x1d,       // SWAP
+		0x60, 0x02, // PUSH1 2
+		0x0b,       // ADD
+		0x1d,       // SWAP
+		0x60, 0x01, // PUSH1 1
+		0x1d,       // SWAP
+		0x0e,       // SUB
+		0x1c,       // DUP
+		0x60, 0x05, // PUSH1 loop
+		0x1a, // JUMPI
+		0x1e, // POP
+		0x20, // RETURN
+	})
+
+	vm := NewVM(code, NewState(), 100_000, VMContext{})
+	assert.Nil(t, vm.Run())
+	assert.Equal(t, Int(2000), vm.Result())
+}
+
+func TestVMVersion1Faults(t *testing.T) {
+	tests := map[string]struct {
+		code []byte
+		err  error
+	}{
+		"unknown version":     {[]byte{CodeMagic, 0x02, 0x01}, ErrInvalidCode},
+		"legacy push":         {NewCode(CodeVersion1, []byte{0x01, 0x0a}), ErrInvalidOpcode},
+		"unknown instruction": {NewCode(CodeVersion1, []byte{0x50}), ErrInvalidOpcode},
+		"truncated immediate": {NewCode(CodeVersion1, []byte{0x61, 0x01}), ErrInvalidOperand},
+		"truncated bytes":     {NewCode(CodeVersion1, []byte{0x22, 0x00, 0x04, 'F'}), ErrInvalidOperand},
+		"int64 overflow":      {NewCode(CodeVersion1, []byte{0x68, 0x01, 0, 0, 0, 0, 0, 0, 0, 0}), ErrInvalidOperand},
+		// The JUMPDEST byte at 1 is the immediate of PUSH1.
+		"jump into immediate": {NewCode(CodeVersion1, []byte{0x60, 0x1b, 0x60, 0x01, 0x19}), ErrInvalidJump},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := NewVM(tc.code, NewState(), 10_000, VMContext{}).Run()
+			assert.True(t, errors.Is(err, tc.err), err)
+		})
+	}
+}
+
+func TestVMContext(t *testing.T) {
+	ctx := VMContext{
+		Caller:    types.AddressFromBytes(bytes.Repeat([]byte{0xaa}, 20)),
+		Value:     5,
+		Height:    7,
+		Timestamp: 1_700_000_000,
+		Balance:   12,
+	}
+
+	testCases := []struct {
+		instr Instruction
+		want  Value
+	}{
+		{InstrCaller, Bytes(ctx.Caller.ToSlice())},
+		{InstrCallValue, Int(5)},
+		{InstrHeight, Int(7)},
+		{InstrTimestamp, Int(1_700_000_000)},
+		{InstrSelfBalance, Int(12)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.instr.String(), func(t *testing.T) {
+			vm := NewVM(NewCode(CodeVersion1, []byte{byte(tc.instr), 0x20}), NewState(), 10_000, ctx)
+			assert.Nil(t, vm.Run())
+			assert.Equal(t, tc.want, vm.Result())
+		})
+	}
+
+	// Legacy code executes its operands, so the context bytes stay no-ops.
+	vm := NewVM([]byte{0x32}, NewState(), 10_000, ctx)
+	assert.Nil(t, vm.Run())
+	assert.Equal(t, 0, vm.stack.Len())
+
+	ctx.Value = 1 << 63
+	vm = NewVM(NewCode(CodeVersion1, []byte{byte(InstrCallValue)}), NewState(), 10_000, ctx)
+	assert.True(t, errors.Is(vm.Run(), ErrInvalidOperand))
+}
+
+func TestVMEmit(t *testing.T) {
+	ctx := VMContext{Address: types.Address{0x01}}
+	code := NewCode(CodeVersion1, []byte{
+		0x60, 0x2a, // PUSH1 42
+		0x22, 0x00, 0x01, 'A', // PUSH_BYTES "A"
+		0x22, 0x00, 0x01, 'B', // PUSH_BYTES "B"
+		0x60, 0x02, // PUSH1 2
+		0x38, // EMIT
+	})
+
+	vm := NewVM(code, NewState(), 10_000, ctx)
+	assert.Nil(t, vm.Run())
+	assert.Equal(t, []Event{{
+		Address: ctx.Address,
+		Topics:  [][]byte{[]byte("A"), []byte("B")},
+		Data:    EncodeValue(Int(42)),
+	}}, vm.Events())
+
+	// More topics than allowed.
+	code = NewCode(CodeVersion1, []byte{0x60, 0x00, 0x60, 0x05, 0x38})
+	assert.True(t, errors.Is(NewVM(code, NewState(), 10_000, ctx).Run(), ErrInvalidOperand))
+
+	// The byte is a cheap no-op in legacy code.
+	vm = NewVM([]byte{0x38}, NewState(), 10_000, ctx)
+	assert.Nil(t, vm.Run())
+	assert.Equal(t, GasStep, vm.GasUsed())
+	assert.Empty(t, vm.Events())
+}
+
+func TestValueEncoding(t *testing.T) {
+	for _, v := range []Value{Int(-3), Int(1 << 40), Bytes("foo"), Bytes{}, Bool(true), Bool(false)} {
+		decoded, err := DecodeValue(EncodeValue(v))
+		assert.Nil(t, err)
+		assert.Equal(t, v, decoded)
+	}
+
+	for _, b := range [][]byte{nil, {0x09}, {byte(ValueKindInt), 1}, {byte(ValueKindBool), 2}} {
+		_, err := DecodeValue(b)
+		assert.True(t, errors.Is(err, ErrInvalidValue))
+	}
+}
+
+func TestVMConversions(t *testing.T) {
+	tests := map[string]struct {
+		code []byte
+		want Value
+	}{
+		"bytes to int":  {[]byte{0x22, 0x00, 0x02, 0x01, 0x00, 0x28}, Int(256)},
+		"bool to int":   {[]byte{0x60, 0x01, 0x60, 0x01, 0x13, 0x28}, Int(1)},
+		"int to bytes":  {[]byte{0x60, 0x05, 0x29}, Bytes{0, 0, 0, 0, 0, 0, 0, 5}},
+		"int to bool":   {[]byte{0x60, 0x00, 0x2a}, Bool(false)},
+		"bytes to bool": {[]byte{0x22, 0x00, 0x01, 0x00, 0x2a}, Bool(true)},
+		"type of bool":  {[]byte{0x60, 0x00, 0x2a, 0x2b}, Int(ValueKindBool)},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			vm := NewVM(NewCode(CodeVersion1, append(tc.code, 0x20)), NewState(), 10_000, VMContext{})
+			assert.Nil(t, vm.Run())
+			assert.Equal(t, tc.want, vm.Result())
+		})
+	}
+}
+
+func TestVMTypeErrors(t *testing.T) {
+	tests := map[string]struct {
+		code []byte
+		err  error
+	}{
+		"add bytes":       {[]byte{0x60, 0x01, 0x22, 0x00, 0x01, 'a', 0x0b}, ErrInvalidOperand},
+		"compare kinds":   {[]byte{0x60, 0x01, 0x22, 0x00, 0x01, 0x01, 0x13}, ErrInvalidOperand},
+		"jump to bytes":   {[]byte{0x22, 0x00, 0x01, 0x00, 0x19}, ErrInvalidOperand},
+		"long bytes":      {[]byte{0x22, 0x00, 0x09, 1, 2, 3, 4, 5, 6, 7, 8, 9, 0x28}, ErrInvalidOperand},
+		"add overflow":    {[]byte{0x67, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x60, 0x01, 0x0b}, ErrIntOverflow},
+		"stored bad data": {[]byte{0x22, 0x00, 0x03, 'B', 'A', 'D', 0x10}, ErrInvalidValue},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			contractState := NewState()
+			assert.Nil(t, contractState.Put([]byte("BAD"), []byte{0xff}))
+
+			err := NewVM(NewCode(CodeVersion1, tc.code), contractState, 10_000, VMContext{}).Run()
+			assert.True(t, errors.Is(err, tc.err), err)
+		})
+	}
+}
+
+func TestVMStoreTypedValues(t *testing.T) {
+	// Stores a bool and reads it back.
+	code := NewCode(CodeVersion1, []byte{
+		0x60, 0x01, 0x60, 0x02, 0x14, // 1 < 2
+		0x22, 0x00, 0x01, 'B', // PUSH_BYTES "B"
+		0x0f,                  // STORE
+		0x22, 0x00, 0x01, 'B', // PUSH_BYTES "B"
+		0x10, // GET
+		0x20, // RETURN
+	})
+
+	contractState := NewState()
+	vm := NewVM(code, contractState, 10_000, VMContext{})
+	assert.Nil(t, vm.Run())
+	assert.Equal(t, Bool(true), vm.Result())
+
+	value, err := contractState.Get([]byte("B"))
+	assert.Nil(t, err)
+	assert.Equal(t, EncodeValue(Bool(true)), value)
+}
+
+// benchmarkLoopCode returns the loop of TestVMVersion1Loop running n times
+// on top of depth unrelated stack values.
+func benchmarkLoopCode(depth int, n uint16) []byte {
+	code := bytes.Repeat([]byte{0x60, 0x00}, depth)
+	loop := byte(len(code) + 5)
+	code = append(code,
+		0x60, 0x00, // PUSH1 0
+		0x61, byte(n>>8), byte(n), // PUSH2 n
+		0x1b,       // loop: JUMPDEST
+		0x1d,       // SWAP
+		0x60, 0x02, // PUSH1 2
+		0x0b,       // ADD
+		0x1d,       // SWAP
+		0x60, 0x01, // PUSH1 1
+		0x1d,       // SWAP
+		0x0e,       // SUB
+		0x1c,       // DUP
+		0x60, loop, // PUSH1 loop
+		0x1a, // JUMPI
+		0x1e, // POP
+		0x20, // RETURN
+	)
+
+	return NewCode(CodeVersion1, code)
+}
+
+func BenchmarkStack(b *testing.B) {
+	s := NewStack(MaxStackDepth)
+	for i := 0; i < 100; i++ {
+		s.Push(Int(i))
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.Push(Int(i))
+		s.Pop()
+	}
+}
+
+func BenchmarkVMLoop(b *testing.B) {
+	for _, depth := range []int{0, 100} {
+		code := benchmarkLoopCode(depth, 10_000)
+
+		b.Run(fmt.Sprintf("depth-%d", depth), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				vm := NewVM(code, NewState(), 10_000_000, VMContext{})
+				if err := vm.Run(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+type testHost struct {
+	code    map[types.Address][]byte
+	storage map[types.Address]*State
+}
+
+func newTestHost() *testHost {
+	return &testHost{
+		code:    make(map[types.Address][]byte),
+		storage: make(map[types.Address]*State),
+	}
+}
+
+func (h *testHost) deploy(address types.Address, code []byte) {
+	h.code[address] = code
+	h.storage[address] = NewState()
+}
+
+func (h *testHost) ContractCode(address types.Address) ([]byte, error) {
+	code, ok := h.code[address]
+	if !ok {
+		return nil, ErrContractNotFound
+	}
+
+	return code, nil
+}
+
+func (h *testHost) ContractStorage(address types.Address) ContractStorage {
+	return h.storage[address]
+}
+
+func (h *testHost) Balance(types.Address) uint64 {
+	return 0
+}
+
+// doubleCode stores its argument under "X" and returns it doubled. It fails
+// after the store for a zero argument.
+var doubleCode = NewCode(CodeVersion1, []byte{
+	0x1c,                  // DUP
+	0x22, 0x00, 0x01, 'X', // PUSH_BYTES "X"
+	0x0f,       // STORE
+	0x1c,       // DUP
+	0x60, 0x00, // PUSH1 0
+	0x13,       // EQ
+	0x16,       // NOT
+	0x60, 0x13, // PUSH1 ok
+	0x1a,       // JUMPI
+	0x60, 0x00, // PUSH1 0
+	0x60, 0x00, // PUSH1 0
+	0x12,       // DIV
+	0x1b,       // ok: JUMPDEST
+	0x60, 0x02, // PUSH1 2
+	0x11, // MUL
+	0x20, // RETURN
+})
+
+// callCode calls the contract at callee with arg, and stores the result
+// under "R" and the success flag under "OK".
+func callCode(callee types.Address, arg byte) []byte {
+	code := []byte{
+		0x60, arg, // PUSH1 arg
+		0x60, 0x01, // PUSH1 1
+		0x22, 0x00, 0x14, // PUSH_BYTES callee
+	}
+	code = append(code, callee.ToSlice()...)
+	code = append(code,
+		0x61, 0x27, 0x10, // PUSH2 10000
+		0x39,                       // CALL
+		0x22, 0x00, 0x02, 'O', 'K', // PUSH_BYTES "OK"
+		0x0f,                  // STORE
+		0x22, 0x00, 0x01, 'R', // PUSH_BYTES "R"
+		0x0f, // STORE
+	)
+
+	return NewCode(CodeVersion1, code)
+}
+
+func TestVMCall(t *testing.T) {
+	callee := types.Address{0x01}
+
+	tests := map[string]struct {
+		callee types.Address
+		arg    byte
+		result Value
+		ok     Bool
+		stored Value
+	}{
+		"success":          {callee, 5, Int(10), true, Int(5)},
+		"callee fails":     {callee, 0, Bytes{}, false, nil},
+		"missing contract": {types.Address{0x02}, 5, Bytes{}, false, nil},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			host := newTestHost()
+			host.deploy(callee, doubleCode)
+
+			contractState := NewState()
+			vm := NewVM(callCode(tc.callee, tc.arg), contractState, 100_000, VMContext{Host: host})
+			assert.Nil(t, vm.Run())
+
+			for key, want := range map[string]Value{"R": tc.result, "OK": tc.ok} {
+				value, err := contractState.Get([]byte(key))
+				assert.Nil(t, err)
+				assert.Equal(t, EncodeValue(want), value, key)
+			}
+
+			// Only a successful callee keeps its stores.
+			value, err := host.storage[callee].Get([]byte("X"))
+			if tc.stored == nil {
+				assert.NotNil(t, err)
+			} else {
+				assert.Equal(t, EncodeValue(tc.stored), value)
+			}
+		})
+	}
+}
+
+func TestVMCallDepth(t *testing.T) {
+	self := types.Address{0x01}
+
+	// Stores its argument d under "D" and calls itself with d+1.
+	code := []byte{
+		0x1c,                  // DUP
+		0x22, 0x00, 0x01, 'D', // PUSH_BYTES "D"
+		0x0f,       // STORE
+		0x60, 0x01, // PUSH1 1
+		0x0b,       // ADD
+		0x60, 0x01, // PUSH1 1
+		0x22, 0x00, 0x14, // PUSH_BYTES self
+	}
+	code = append(code, self.ToSlice()...)
+	code = append(code, 0x61, 0xff, 0xff, 0x39) // PUSH2 65535 CALL
+
+	for _, maxDepth := range []int{3, 0} {
+		host := newTestHost()
+		host.deploy(self, NewCode(CodeVersion1, code))
+
+		vm := NewVM(host.code[self], host.storage[self], 10_000_000, VMContext{Host: host, Address: self, MaxCallDepth: maxDepth})
+		assert.Nil(t, vm.stack.Push(Int(0)))
+		assert.Nil(t, vm.Run())
+
+		// The call at the maximum depth fails, which discards the store of
+		// the deepest callee.
+		want := maxDepth
+		if want == 0 {
+			want = DefaultMaxCallDepth
+		}
+		value, err := host.storage[self].Get([]byte("D"))
+		assert.Nil(t, err)
+		assert.Equal(t, EncodeValue(Int(want-1)), value)
+	}
+}
+
+func TestVMTracer(t *testing.T) {
+	code := NewCode(CodeVersion1, []byte{
+		0x60, 0x07, // PUSH1 7
+		0x22, 0x00, 0x01, 'N', // PUSH_BYTES "N"
+		0x0f,                  // STORE
+		0x22, 0x00, 0x01, 'N', // PUSH_BYTES "N"
+		0x10, // GET
+		0x20, // RETURN
+	})
+
+	recorder := &TraceRecorder{}
+	vm := NewVM(code, NewState(), 10_000, VMContext{Tracer: recorder})
 	assert.Nil(t, vm.Run())
 
-	result := vm.stack.Pop()
-	assert.Equal(t, 3, result)
+	ips := []int{}
+	for _, step := range recorder.Steps {
+		ips = append(ips, step.IP)
+	}
+	assert.Equal(t, []int{0, 2, 6, 7, 11, 12}, ips)
+	assert.Equal(t, InstrStore, recorder.Steps[2].Instr)
+	assert.Equal(t, []Value{Int(7), Bytes("N")}, recorder.Steps[2].Stack)
+	assert.Equal(t, uint64(10_000), recorder.Steps[0].Gas)
+	assert.Equal(t, []StorageAccess{
+		{Key: []byte("N"), Value: EncodeValue(Int(7)), Write: true},
+		{Key: []byte("N"), Value: EncodeValue(Int(7))},
+	}, recorder.Storage)
+}
+
+func TestTraceRecorderLimits(t *testing.T) {
+	code := NewCode(CodeVersion1, []byte{
+		0x60, 0x07, // PUSH1 7
+		0x22, 0x00, 0x01, 'N', // PUSH_BYTES "N"
+		0x0f,                  // STORE
+		0x22, 0x00, 0x01, 'N', // PUSH_BYTES "N"
+		0x10, // GET
+		0x20, // RETURN
+	})
+
+	recorder := &TraceRecorder{MaxSteps: 3, MaxStackBytes: 1}
+	vm := NewVM(code, NewState(), 10_000, VMContext{Tracer: recorder})
+	assert.Nil(t, vm.Run())
+
+	assert.True(t, recorder.Truncated)
+	assert.Len(t, recorder.Steps, 3)
+	// Only the top of the stack fits into a single byte.
+	assert.Equal(t, []Value{Bytes("N")}, recorder.Steps[2].Stack)
+	assert.Len(t, recorder.Storage, 2)
+}
+
+type stopTracer struct {
+	TraceRecorder
+	after int
+}
+
+func (s *stopTracer) OnStep(step TraceStep) error {
+	if len(s.Steps) == s.after {
+		return errors.New("stop")
+	}
+
+	return s.TraceRecorder.OnStep(step)
+}
+
+func TestVMTracerStops(t *testing.T) {
+	contractState := NewState()
+	code := NewCode(CodeVersion1, []byte{0x60, 0x07, 0x22, 0x00, 0x01, 'N', 0x0f})
+
+	tracer := &stopTracer{after: 2}
+	err := NewVM(code, contractState, 10_000, VMContext{Tracer: tracer}).Run()
+	assert.NotNil(t, err)
+	assert.Len(t, tracer.Steps, 2)
+
+	_, err = contractState.Get([]byte("N"))
+	assert.NotNil(t, err)
 }
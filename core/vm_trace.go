@@ -0,0 +1,311 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/go-kit/log"
+	"github.com/k0yote/privatechain/types"
+)
+
+// TraceStep is the state of a VM right before it executes an instruction.
+type TraceStep struct {
+	// Depth is the call depth of the VM, zero for the code of the
+	// transaction.
+	Depth   int
+	Address types.Address
+	IP      int
+	Instr   Instruction
+	// Gas is the gas left before the instruction.
+	Gas uint64
+	// Stack holds the values on the stack, the top one last.
+	Stack []Value
+}
+
+// StorageAccess is a read or write of contract storage. Reads of missing
+// keys have a nil Value.
+type StorageAccess struct {
+	Depth   int
+	Address types.Address
+	Key     []byte
+	Value   []byte
+	Write   bool
+}
+
+// Tracer is called by the VM while it executes code. An error returned by
+// OnStep stops the execution with that error.
+type Tracer interface {
+	OnStep(step TraceStep) error
+	OnStorage(access StorageAccess)
+}
+
+const (
+	// DefaultMaxTraceSteps and DefaultMaxTraceStackBytes bound the traces
+	// returned by TraceTransaction.
+	DefaultMaxTraceSteps      = 100_000
+	DefaultMaxTraceStackBytes = 1024
+
+	// A state snapshot for tracing is taken every traceSnapshotInterval
+	// blocks and the last maxTraceSnapshots of them are kept.
+	traceSnapshotInterval = 64
+	maxTraceSnapshots     = 4
+)
+
+var ErrTracingDisabled = errors.New("tracing is disabled")
+
+// TraceRecorder is a Tracer that records everything it sees, up to
+// MaxSteps steps and storage accesses and MaxStackBytes bytes of stack
+// values per step. Zero means no limit. Only the top of a stack that does
+// not fit is recorded. Truncated is set whenever anything was left out.
+type TraceRecorder struct {
+	MaxSteps      int
+	MaxStackBytes int
+	Truncated     bool
+	Steps         []TraceStep
+	Storage       []StorageAccess
+}
+
+func (r *TraceRecorder) OnStep(step TraceStep) error {
+	if r.MaxSteps > 0 && len(r.Steps) >= r.MaxSteps {
+		r.Truncated = true
+		return nil
+	}
+
+	if r.MaxStackBytes > 0 {
+		size := 0
+		for i := len(step.Stack) - 1; i >= 0; i-- {
+			size += valueSize(step.Stack[i])
+			if size > r.MaxStackBytes {
+				step.Stack = step.Stack[i+1:]
+				r.Truncated = true
+				break
+			}
+		}
+	}
+
+	r.Steps = append(r.Steps, step)
+	return nil
+}
+
+func (r *TraceRecorder) OnStorage(access StorageAccess) {
+	if r.MaxSteps > 0 && len(r.Storage) >= r.MaxSteps {
+		r.Truncated = true
+		return
+	}
+
+	r.Storage = append(r.Storage, access)
+}
+
+func valueSize(v Value) int {
+	switch v := v.(type) {
+	case Bytes:
+		return len(v)
+	case Int:
+		return 8
+	default:
+		return 1
+	}
+}
+
+func (vm *VM) traceStep(instr Instruction) error {
+	if vm.ctx.Tracer == nil {
+		return nil
+	}
+
+	step := TraceStep{
+		Depth:   vm.ctx.Depth,
+		Address: vm.ctx.Address,
+		IP:      vm.ip,
+		Instr:   instr,
+		Gas:     vm.gas - vm.gasUsed,
+		Stack:   append([]Value(nil), vm.stack.data[:vm.stack.sp]...),
+	}
+
+	return vm.ctx.Tracer.OnStep(step)
+}
+
+func (vm *VM) traceStorage(key, value []byte, write bool) {
+	if vm.ctx.Tracer == nil {
+		return
+	}
+
+	vm.ctx.Tracer.OnStorage(StorageAccess{
+		Depth:   vm.ctx.Depth,
+		Address: vm.ctx.Address,
+		Key:     append([]byte(nil), key...),
+		Value:   append([]byte(nil), value...),
+		Write:   write,
+	})
+}
+
+// Trace is the result of re-executing a transaction with a TraceRecorder.
+type Trace struct {
+	TxHash  types.Hash
+	Receipt *Receipt
+	TraceRecorder
+}
+
+// stateSnapshot is the encoded state of every state kind after the block
+// at height was applied.
+type stateSnapshot struct {
+	height uint32
+	states map[StateKind]map[string][]byte
+}
+
+// EnableTracing makes the chain keep the state snapshots TraceTransaction
+// needs. Only transactions included after the current block can be traced.
+func (bc *Blockchain) EnableTracing() {
+	bc.stateLock.Lock()
+	defer bc.stateLock.Unlock()
+
+	if bc.tracing {
+		return
+	}
+
+	bc.tracing = true
+	bc.takeStateSnapshot(bc.Height())
+}
+
+// snapshotForTracing must be called with the stateLock held after the block
+// at height was applied.
+func (bc *Blockchain) snapshotForTracing(height uint32) {
+	if bc.tracing && height%traceSnapshotInterval == 0 {
+		bc.takeStateSnapshot(height)
+	}
+}
+
+func (bc *Blockchain) takeStateSnapshot(height uint32) {
+	snapshot := &stateSnapshot{
+		height: height,
+		states: make(map[StateKind]map[string][]byte),
+	}
+
+	for kind, state := range bc.journal.states {
+		it, ok := state.(stateIterator)
+		if !ok {
+			continue
+		}
+
+		values := make(map[string][]byte)
+		it.forEachEncoded(func(key string, value []byte) {
+			values[key] = value
+		})
+		snapshot.states[kind] = values
+	}
+
+	bc.traceSnapshots = append(bc.traceSnapshots, snapshot)
+	if len(bc.traceSnapshots) > maxTraceSnapshots {
+		bc.traceSnapshots = bc.traceSnapshots[1:]
+	}
+}
+
+// TraceTransaction re-executes the transaction with the given hash on top of
+// the state it was originally executed on and records its execution. The
+// chain is replayed into a scratch chain from the last state snapshot taken
+// before the block of the transaction, so this is meant for debugging only
+// and requires EnableTracing.
+func (bc *Blockchain) TraceTransaction(hash types.Hash) (*Trace, error) {
+	bc.lock.RLock()
+	height, ok := bc.txHeights[hash]
+	bc.lock.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("transaction with hash (%s) not found", hash)
+	}
+
+	bc.stateLock.RLock()
+	tracing := bc.tracing
+	maxCallDepth := bc.maxCallDepth
+	var snapshot *stateSnapshot
+	for _, s := range bc.traceSnapshots {
+		if s.height < height {
+			snapshot = s
+		}
+	}
+	bc.stateLock.RUnlock()
+
+	if !tracing {
+		return nil, ErrTracingDisabled
+	}
+	if snapshot == nil {
+		return nil, fmt.Errorf("state before block (%d) is no longer kept", height)
+	}
+
+	scratch := newEmptyBlockchain(log.NewNopLogger(), NewMemoryStore(), nil)
+	scratch.maxCallDepth = maxCallDepth
+	for kind, values := range snapshot.states {
+		for key, value := range values {
+			if err := scratch.journal.restore(kind, key, value); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	bc.lock.RLock()
+	scratch.headers = append(scratch.headers, bc.headers[:snapshot.height+1]...)
+	bc.lock.RUnlock()
+
+	for h := snapshot.height + 1; h < height; h++ {
+		b, err := bc.store.Get(h)
+		if err != nil {
+			return nil, err
+		}
+		if err := scratch.replayBlock(copyBlock(b), nil); err != nil {
+			return nil, err
+		}
+	}
+
+	b, err := bc.store.Get(height)
+	if err != nil {
+		return nil, err
+	}
+
+	return scratch.traceInBlock(copyBlock(b), hash)
+}
+
+// traceInBlock executes the transactions of b up to the one with the given
+// hash, which is traced. The state is changed but the block is not stored,
+// so this is only meant for scratch chains.
+func (bc *Blockchain) traceInBlock(b *Block, hash types.Hash) (*Trace, error) {
+	bc.stateLock.Lock()
+	defer bc.stateLock.Unlock()
+
+	bc.journal.begin()
+	defer bc.journal.finish()
+
+	for _, tx := range b.Transactions {
+		if tx.Hash(TxHasher{}) != hash {
+			if _, err := bc.handleTransaction(tx, b); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		trace := &Trace{
+			TxHash: hash,
+			TraceRecorder: TraceRecorder{
+				MaxSteps:      DefaultMaxTraceSteps,
+				MaxStackBytes: DefaultMaxTraceStackBytes,
+			},
+		}
+		bc.tracer = &trace.TraceRecorder
+		receipt, err := bc.handleTransaction(tx, b)
+		bc.tracer = nil
+		if err != nil {
+			return nil, err
+		}
+
+		trace.Receipt = receipt
+		return trace, nil
+	}
+
+	return nil, fmt.Errorf("transaction (%s) not found in block (%s)", hash, b.Hash(BlockHasher{}))
+}
+
+// copyBlock returns a copy of b that can be executed without changing b.
+func copyBlock(b *Block) *Block {
+	cp := *b
+	cp.Transactions = append([]*Transaction(nil), b.Transactions...)
+
+	return &cp
+}
@@ -0,0 +1,235 @@
+package core
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ValueKind is the type of a value on the VM stack.
+type ValueKind byte
+
+const (
+	ValueKindInt ValueKind = iota + 1
+	ValueKindBytes
+	ValueKindBool
+)
+
+func (k ValueKind) String() string {
+	switch k {
+	case ValueKindInt:
+		return "int"
+	case ValueKindBytes:
+		return "bytes"
+	case ValueKindBool:
+		return "bool"
+	default:
+		return fmt.Sprintf("ValueKind(%d)", byte(k))
+	}
+}
+
+var ErrInvalidValue = errors.New("invalid value encoding")
+
+// Value is a value on the VM stack: an Int, Bytes or Bool.
+type Value interface {
+	Kind() ValueKind
+}
+
+type (
+	Int   int64
+	Bytes []byte
+	Bool  bool
+)
+
+func (Int) Kind() ValueKind   { return ValueKindInt }
+func (Bytes) Kind() ValueKind { return ValueKindBytes }
+func (Bool) Kind() ValueKind  { return ValueKindBool }
+
+// String returns the bytes as 0x prefixed hex.
+func (b Bytes) String() string {
+	return "0x" + hex.EncodeToString(b)
+}
+
+// EncodeValue returns the encoding of v used for values stored by version 1
+// code and for event data: the kind followed by an 8 byte big endian
+// integer, the raw bytes or a single 0 or 1 byte.
+func EncodeValue(v Value) []byte {
+	switch v := v.(type) {
+	case Int:
+		b := make([]byte, 9)
+		b[0] = byte(ValueKindInt)
+		binary.BigEndian.PutUint64(b[1:], uint64(v))
+		return b
+	case Bytes:
+		return append([]byte{byte(ValueKindBytes)}, v...)
+	case Bool:
+		if v {
+			return []byte{byte(ValueKindBool), 1}
+		}
+		return []byte{byte(ValueKindBool), 0}
+	}
+
+	return nil
+}
+
+// DecodeValue is the inverse of EncodeValue.
+func DecodeValue(b []byte) (Value, error) {
+	if len(b) == 0 {
+		return nil, fmt.Errorf("%w: empty", ErrInvalidValue)
+	}
+
+	switch ValueKind(b[0]) {
+	case ValueKindInt:
+		if len(b) != 9 {
+			return nil, fmt.Errorf("%w: int of %d bytes", ErrInvalidValue, len(b)-1)
+		}
+		return Int(binary.BigEndian.Uint64(b[1:])), nil
+	case ValueKindBytes:
+		return Bytes(append([]byte{}, b[1:]...)), nil
+	case ValueKindBool:
+		if len(b) != 2 || b[1] > 1 {
+			return nil, fmt.Errorf("%w: malformed bool", ErrInvalidValue)
+		}
+		return Bool(b[1] == 1), nil
+	}
+
+	return nil, fmt.Errorf("%w: unknown kind (%d)", ErrInvalidValue, b[0])
+}
+
+// Conversion instructions of version 1 code. InstrToInt reads bytes as an
+// unsigned big endian integer of at most 8 bytes that has to fit an int64,
+// InstrToBytes writes an int as 8 big endian bytes and a bool as one byte and
+// InstrToBool treats non-zero ints and non-empty bytes as true. InstrTypeOf
+// pushes the ValueKind of the top value, which it consumes.
+const (
+	InstrToInt   Instruction = 0x28
+	InstrToBytes Instruction = 0x29
+	InstrToBool  Instruction = 0x2a
+	InstrTypeOf  Instruction = 0x2b
+)
+
+func isConversionInstr(instr Instruction) bool {
+	return instr >= InstrToInt && instr <= InstrTypeOf
+}
+
+func toInt(v Value) (Int, error) {
+	switch v := v.(type) {
+	case Int:
+		return v, nil
+	case Bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	case Bytes:
+		if len(v) > 8 {
+			return 0, fmt.Errorf("%w: %d bytes overflow int64", ErrInvalidOperand, len(v))
+		}
+
+		var n uint64
+		for _, c := range v {
+			n = n<<8 | uint64(c)
+		}
+		if n > 1<<63-1 {
+			return 0, fmt.Errorf("%w: %d overflows int64", ErrInvalidOperand, n)
+		}
+
+		return Int(n), nil
+	}
+
+	return 0, fmt.Errorf("%w: cannot convert %T to int", ErrInvalidOperand, v)
+}
+
+func toBytes(v Value) (Bytes, error) {
+	switch v := v.(type) {
+	case Bytes:
+		return v, nil
+	case Int:
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, uint64(v))
+		return b, nil
+	case Bool:
+		if v {
+			return Bytes{1}, nil
+		}
+		return Bytes{0}, nil
+	}
+
+	return nil, fmt.Errorf("%w: cannot convert %T to bytes", ErrInvalidOperand, v)
+}
+
+// toBool is also used for the conditions of InstrJumpI and the logic
+// instructions.
+func toBool(v Value) (Bool, error) {
+	switch v := v.(type) {
+	case Bool:
+		return v, nil
+	case Int:
+		return v != 0, nil
+	case Bytes:
+		return len(v) > 0, nil
+	}
+
+	return false, fmt.Errorf("%w: cannot convert %T to bool", ErrInvalidOperand, v)
+}
+
+// execConversion runs the conversion instructions and reports whether instr
+// was one of them.
+func (vm *VM) execConversion(instr Instruction) (bool, error) {
+	if !isConversionInstr(instr) {
+		return false, nil
+	}
+
+	v, err := vm.pop()
+	if err != nil {
+		return true, err
+	}
+
+	var converted Value
+	switch instr {
+	case InstrToInt:
+		converted, err = toInt(v)
+	case InstrToBytes:
+		converted, err = toBytes(v)
+	case InstrToBool:
+		converted, err = toBool(v)
+	case InstrTypeOf:
+		converted = Int(v.Kind())
+	}
+	if err != nil {
+		return true, err
+	}
+
+	return true, vm.push(converted)
+}
+
+func addInt(a, b Int) (Int, error) {
+	if (b > 0 && a > math.MaxInt64-b) || (b < 0 && a < math.MinInt64-b) {
+		return 0, ErrIntOverflow
+	}
+
+	return a + b, nil
+}
+
+func subInt(a, b Int) (Int, error) {
+	if (b < 0 && a > math.MaxInt64+b) || (b > 0 && a < math.MinInt64+b) {
+		return 0, ErrIntOverflow
+	}
+
+	return a - b, nil
+}
+
+func mulInt(a, b Int) (Int, error) {
+	if a == 0 || b == 0 {
+		return 0, nil
+	}
+
+	r := a * b
+	if r/b != a || (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64) {
+		return 0, ErrIntOverflow
+	}
+
+	return r, nil
+}
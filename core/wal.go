@@ -0,0 +1,217 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+var ErrWALCorrupt = errors.New("corrupt write-ahead log record")
+
+// WALRecord is the journal entry for a block that is being applied: the
+// encoded block together with the state changes and receipts it produced.
+type WALRecord struct {
+	Block    *Block
+	Changes  []StateChange
+	Receipts []*Receipt
+}
+
+// WAL is a single record write-ahead log. A record is written and synced
+// before a block is handed to the storage and cleared once the block has been
+// committed, so a non empty log on startup means the node went down while
+// applying that block.
+type WAL struct {
+	f *os.File
+}
+
+func OpenWAL(path string) (*WAL, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &WAL{f: f}, nil
+}
+
+func (w *WAL) Write(rec *WALRecord) error {
+	payload, err := encodeWALRecord(rec)
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, 8+len(payload))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(payload))
+	copy(buf[8:], payload)
+
+	if err := w.f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := w.f.WriteAt(buf, 0); err != nil {
+		return err
+	}
+
+	return w.f.Sync()
+}
+
+// Clear drops the last written record, either because its block has been
+// committed or because it was rolled back.
+func (w *WAL) Clear() error {
+	if err := w.f.Truncate(0); err != nil {
+		return err
+	}
+
+	return w.f.Sync()
+}
+
+// Pending returns the record of a block that was not committed, or nil if
+// there is none. A torn record means the block never reached the storage and
+// is reported as nil as well.
+func (w *WAL) Pending() (*WALRecord, error) {
+	if _, err := w.f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	raw, err := io.ReadAll(w.f)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(raw) < 8 {
+		return nil, nil
+	}
+
+	length := binary.BigEndian.Uint32(raw[0:4])
+	if uint64(len(raw)-8) < uint64(length) {
+		return nil, nil
+	}
+
+	payload := raw[8 : 8+length]
+	if binary.BigEndian.Uint32(raw[4:8]) != crc32.ChecksumIEEE(payload) {
+		return nil, nil
+	}
+
+	return decodeWALRecord(payload)
+}
+
+func (w *WAL) Close() error {
+	return w.f.Close()
+}
+
+func encodeWALRecord(rec *WALRecord) ([]byte, error) {
+	blockBuf := &bytes.Buffer{}
+	if err := rec.Block.Encode(NewBinaryBlockEncoder(blockBuf)); err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	writeWALBytes(buf, blockBuf.Bytes())
+	binary.Write(buf, binary.BigEndian, uint32(len(rec.Changes)))
+
+	for _, change := range rec.Changes {
+		buf.WriteByte(byte(change.Kind))
+		writeWALBytes(buf, change.Key)
+		writeWALBytes(buf, change.Prev)
+		writeWALBytes(buf, change.Next)
+	}
+
+	writeWALBytes(buf, MarshalReceipts(rec.Receipts))
+
+	return buf.Bytes(), nil
+}
+
+func decodeWALRecord(payload []byte) (*WALRecord, error) {
+	r := bytes.NewReader(payload)
+
+	blockBytes, err := readWALBytes(r)
+	if err != nil {
+		return nil, err
+	}
+
+	b := new(Block)
+	if err := b.Decode(NewBinaryBlockDecoder(bytes.NewReader(blockBytes))); err != nil {
+		return nil, err
+	}
+
+	var n uint32
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return nil, ErrWALCorrupt
+	}
+
+	rec := &WALRecord{
+		Block:   b,
+		Changes: make([]StateChange, n),
+	}
+
+	for i := range rec.Changes {
+		kind, err := r.ReadByte()
+		if err != nil {
+			return nil, ErrWALCorrupt
+		}
+
+		change := StateChange{Kind: StateKind(kind)}
+		if change.Key, err = readWALBytes(r); err != nil {
+			return nil, err
+		}
+		if change.Prev, err = readWALBytes(r); err != nil {
+			return nil, err
+		}
+		if change.Next, err = readWALBytes(r); err != nil {
+			return nil, err
+		}
+
+		rec.Changes[i] = change
+	}
+
+	receipts, err := readWALBytes(r)
+	if err != nil {
+		return nil, err
+	}
+	if rec.Receipts, err = UnmarshalReceipts(receipts); err != nil {
+		return nil, err
+	}
+
+	return rec, nil
+}
+
+// writeWALBytes keeps nil and empty slices apart, since a nil value in a
+// StateChange means the key does not exist.
+func writeWALBytes(buf *bytes.Buffer, b []byte) {
+	if b == nil {
+		buf.WriteByte(0)
+		return
+	}
+
+	buf.WriteByte(1)
+	binary.Write(buf, binary.BigEndian, uint32(len(b)))
+	buf.Write(b)
+}
+
+func readWALBytes(r *bytes.Reader) ([]byte, error) {
+	present, err := r.ReadByte()
+	if err != nil {
+		return nil, ErrWALCorrupt
+	}
+	if present == 0 {
+		return nil, nil
+	}
+
+	var n uint32
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return nil, ErrWALCorrupt
+	}
+	if int64(n) > int64(r.Len()) {
+		return nil, fmt.Errorf("%w: value length (%d) exceeds record", ErrWALCorrupt, n)
+	}
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, ErrWALCorrupt
+	}
+
+	return b, nil
+}
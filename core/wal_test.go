@@ -0,0 +1,158 @@
+package core
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/k0yote/privatechain/crypto"
+	"github.com/k0yote/privatechain/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type faultyStore struct {
+	Storage
+	fail  bool
+	crash bool
+}
+
+func (s *faultyStore) Put(b *Block, receipts []*Receipt) error {
+	if s.crash {
+		panic("crash while storing block")
+	}
+	if s.fail {
+		return errors.New("disk full")
+	}
+
+	return s.Storage.Put(b, receipts)
+}
+
+func TestWALWritePending(t *testing.T) {
+	wal, err := OpenWAL(filepath.Join(t.TempDir(), "wal.dat"))
+	assert.Nil(t, err)
+	defer wal.Close()
+
+	rec, err := wal.Pending()
+	assert.Nil(t, err)
+	assert.Nil(t, rec)
+
+	b := randomBlock(t, 1, types.Hash{})
+	changes := []StateChange{
+		{Kind: StateKindContract, Key: []byte("foo"), Prev: nil, Next: []byte{}},
+		{Kind: StateKindAccount, Key: []byte("bar"), Prev: []byte{1}, Next: nil},
+	}
+	receipts := []*Receipt{{TxHash: types.Hash{0x01}, Status: ReceiptSuccess, GasUsed: 1_000}}
+	assert.Nil(t, wal.Write(&WALRecord{Block: b, Changes: changes, Receipts: receipts}))
+
+	rec, err = wal.Pending()
+	assert.Nil(t, err)
+	assert.Equal(t, b.Hash(BlockHasher{}), rec.Block.Hash(BlockHasher{}))
+	assert.Equal(t, changes, rec.Changes)
+	assert.Equal(t, receipts, rec.Receipts)
+
+	assert.Nil(t, wal.Clear())
+	rec, err = wal.Pending()
+	assert.Nil(t, err)
+	assert.Nil(t, rec)
+}
+
+func TestAddBlockRollbackOnStoreError(t *testing.T) {
+	store := &faultyStore{Storage: NewMemoryStore()}
+	bc, err := NewBlockchainWithStorage(log.NewNopLogger(), store, randomBlock(t, 0, types.Hash{}))
+	assert.Nil(t, err)
+
+	store.fail = true
+	block := contractBlock(t, 1, getPrevBlockHash(t, bc, 1))
+	assert.NotNil(t, bc.AddBlock(prepareBlock(t, bc, block)))
+
+	assert.Equal(t, uint32(0), bc.Height())
+	_, err = bc.contractState.Get([]byte("FOO"))
+	assert.NotNil(t, err)
+
+	store.fail = false
+	assert.Nil(t, bc.AddBlock(prepareBlock(t, bc, block)))
+	assert.Equal(t, uint32(1), bc.Height())
+	_, err = bc.contractState.Get([]byte("FOO"))
+	assert.Nil(t, err)
+}
+
+func TestOpenBlockchainRecoversPendingBlock(t *testing.T) {
+	dir := t.TempDir()
+
+	fileStore, err := NewFileStore(dir)
+	assert.Nil(t, err)
+	wal, err := OpenWAL(filepath.Join(dir, "wal.dat"))
+	assert.Nil(t, err)
+
+	store := &faultyStore{Storage: fileStore}
+	bc, err := newBlockchain(log.NewNopLogger(), store, wal, randomBlock(t, 0, types.Hash{}))
+	assert.Nil(t, err)
+
+	store.crash = true
+	block := contractBlock(t, 1, getPrevBlockHash(t, bc, 1))
+	assert.Panics(t, func() { bc.AddBlock(prepareBlock(t, bc, block)) })
+	assert.Nil(t, fileStore.Close())
+	assert.Nil(t, wal.Close())
+
+	bc, err = OpenBlockchain(log.NewNopLogger(), dir, nil)
+	assert.Nil(t, err)
+	defer bc.Close()
+
+	assert.Equal(t, uint32(1), bc.Height())
+	_, err = bc.contractState.Get([]byte("FOO"))
+	assert.Nil(t, err)
+
+	rec, err := bc.wal.Pending()
+	assert.Nil(t, err)
+	assert.Nil(t, rec)
+}
+
+func TestJournalRevertToSnapshot(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	address := crypto.GeneratePrivateKey().PublicKey().Address()
+
+	bc.journal.begin()
+	defer bc.journal.finish()
+
+	assert.Nil(t, bc.contractState.Put([]byte("foo"), []byte("bar")))
+	snapshot := bc.journal.snapshot()
+
+	bc.accountState.CreateAccountWithBalance(address, 100)
+	assert.Nil(t, bc.contractState.Put([]byte("foo"), []byte("baz")))
+	assert.Len(t, bc.journal.changes(), 2)
+
+	assert.Nil(t, bc.journal.revertToSnapshot(snapshot))
+
+	_, err := bc.accountState.GetAccount(address)
+	assert.Equal(t, ErrAccountNotFound, err)
+	value, err := bc.contractState.Get([]byte("foo"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bar"), value)
+
+	assert.Nil(t, bc.journal.revertToSnapshot(0))
+	_, err = bc.contractState.Get([]byte("foo"))
+	assert.NotNil(t, err)
+}
+
+func contractBlock(t *testing.T, height uint32, prevBlockHash types.Hash) *Block {
+	tx := NewTransaction([]byte{0x02, 0x0a, 0x03, 0x0a, 0x0b, 0x4f, 0x0c, 0x4f, 0x0c, 0x46, 0x0c, 0x03, 0x0a, 0x0d, 0x0f})
+	tx.GasLimit = intrinsicGas(t, tx) + 10_000
+	assert.Nil(t, tx.Sign(crypto.GeneratePrivateKey()))
+
+	header := &Header{
+		Version:       1,
+		PrevBlockHash: prevBlockHash,
+		Height:        height,
+		Timestamp:     time.Now().UnixNano(),
+	}
+
+	b, err := NewBlock(header, []*Transaction{tx})
+	assert.Nil(t, err)
+	b.DataHash, err = CalculateDataHash(b.Transactions)
+	assert.Nil(t, err)
+	assert.Nil(t, b.Sign(crypto.GeneratePrivateKey()))
+
+	return b
+}
@@ -0,0 +1,37 @@
+package crypto
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// LoadOrCreatePrivateKey reads the hex encoded private key stored at path.
+// A new key is generated and stored there when the file does not exist.
+func LoadOrCreatePrivateKey(path string) (PrivateKey, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		key := GeneratePrivateKey()
+		if err := os.WriteFile(path, []byte(hex.EncodeToString(key.Bytes())+"\n"), 0o600); err != nil {
+			return PrivateKey{}, err
+		}
+		return key, nil
+	}
+	if err != nil {
+		return PrivateKey{}, err
+	}
+
+	raw, err := hex.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil {
+		return PrivateKey{}, fmt.Errorf("key file %s: %w", path, err)
+	}
+
+	key, err := PrivateKeyFromBytes(raw)
+	if err != nil {
+		return PrivateKey{}, fmt.Errorf("key file %s: %w", path, err)
+	}
+
+	return key, nil
+}
@@ -6,6 +6,7 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"io"
 	"math/big"
 
@@ -42,12 +43,43 @@ func GeneratePrivateKey() PrivateKey {
 	return NewPrivateKeyFromReader(rand.Reader)
 }
 
+// PrivateKeyFromBytes is the inverse of PrivateKey.Bytes.
+func PrivateKeyFromBytes(b []byte) (PrivateKey, error) {
+	curve := elliptic.P256()
+	d := new(big.Int).SetBytes(b)
+	if len(b) != 32 || d.Sign() == 0 || d.Cmp(curve.Params().N) >= 0 {
+		return PrivateKey{}, errors.New("invalid private key")
+	}
+
+	key := &ecdsa.PrivateKey{D: d}
+	key.PublicKey.Curve = curve
+	key.PublicKey.X, key.PublicKey.Y = curve.ScalarBaseMult(b)
+
+	return PrivateKey{
+		key: key,
+	}, nil
+}
+
+// Bytes returns the 32 byte big endian scalar of the key.
+func (k PrivateKey) Bytes() []byte {
+	return k.key.D.FillBytes(make([]byte, 32))
+}
+
 func (k PrivateKey) PublicKey() PublicKey {
 	return elliptic.MarshalCompressed(k.key.PublicKey, k.key.PublicKey.X, k.key.PublicKey.Y)
 }
 
 type PublicKey []byte
 
+// Valid reports whether the key is a compressed point on the P-256 curve.
+func (k PublicKey) Valid() bool {
+	if len(k) != 33 {
+		return false
+	}
+	x, _ := elliptic.UnmarshalCompressed(elliptic.P256(), k)
+	return x != nil
+}
+
 func (k PublicKey) String() string {
 	return hex.EncodeToString(k)
 }
@@ -66,8 +98,38 @@ func (sig *Signature) String() string {
 	return hex.EncodeToString(b)
 }
 
+// Bytes returns R and S as 32 byte big endian integers.
+func (sig *Signature) Bytes() []byte {
+	b := make([]byte, 64)
+	sig.R.FillBytes(b[:32])
+	sig.S.FillBytes(b[32:])
+
+	return b
+}
+
+// SignatureFromBytes is the inverse of Signature.Bytes.
+func SignatureFromBytes(b []byte) (*Signature, error) {
+	if len(b) != 64 {
+		return nil, errors.New("invalid signature length")
+	}
+
+	return &Signature{
+		R: new(big.Int).SetBytes(b[:32]),
+		S: new(big.Int).SetBytes(b[32:]),
+	}, nil
+}
+
+// Verify reports whether sig is a signature of data by pubKey. Malformed
+// keys and signatures never verify.
 func (sig *Signature) Verify(pubKey PublicKey, data []byte) bool {
+	if sig == nil || sig.R == nil || sig.S == nil {
+		return false
+	}
+
 	x, y := elliptic.UnmarshalCompressed(elliptic.P256(), pubKey)
+	if x == nil {
+		return false
+	}
 	key := &ecdsa.PublicKey{
 		Curve: elliptic.P256(),
 		X:     x,
@@ -1,6 +1,7 @@
 package crypto
 
 import (
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -25,3 +26,48 @@ func TestKeypairSignVerifyFailure(t *testing.T) {
 	assert.Nil(t, err)
 	assert.False(t, sig.Verify(pubKey, []byte("aaa")))
 }
+
+func TestPrivateKeyBytes(t *testing.T) {
+	privKey := GeneratePrivateKey()
+
+	decoded, err := PrivateKeyFromBytes(privKey.Bytes())
+	assert.Nil(t, err)
+	assert.Equal(t, privKey.PublicKey(), decoded.PublicKey())
+
+	_, err = PrivateKeyFromBytes(make([]byte, 32))
+	assert.NotNil(t, err)
+}
+
+func TestSignatureBytes(t *testing.T) {
+	privKey := GeneratePrivateKey()
+	sig, err := privKey.Sign([]byte("Hello World"))
+	assert.Nil(t, err)
+
+	decoded, err := SignatureFromBytes(sig.Bytes())
+	assert.Nil(t, err)
+	assert.True(t, decoded.Verify(privKey.PublicKey(), []byte("Hello World")))
+}
+
+func TestLoadOrCreatePrivateKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nodekey")
+
+	created, err := LoadOrCreatePrivateKey(path)
+	assert.Nil(t, err)
+
+	loaded, err := LoadOrCreatePrivateKey(path)
+	assert.Nil(t, err)
+	assert.Equal(t, created.PublicKey(), loaded.PublicKey())
+}
+
+func TestVerifyMalformed(t *testing.T) {
+	privKey := GeneratePrivateKey()
+	msg := []byte("Hello World")
+	sig, err := privKey.Sign(msg)
+	assert.Nil(t, err)
+
+	assert.False(t, sig.Verify(PublicKey{0x02, 0x01}, msg))
+	assert.False(t, sig.Verify(nil, msg))
+	assert.False(t, (&Signature{S: sig.S}).Verify(privKey.PublicKey(), msg))
+	assert.False(t, privKey.PublicKey()[:32].Valid())
+	assert.True(t, privKey.PublicKey().Valid())
+}
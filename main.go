@@ -5,8 +5,8 @@ import (
 	"encoding/json"
 	"io"
 	"log"
-	"net"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/k0yote/privatechain/core"
@@ -17,6 +17,13 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	validatorPrivKey := crypto.GeneratePrivateKey()
 	localNode := makeServer("LOCAL_NODE", &validatorPrivKey, ":3000", []string{":4000"}, ":9000")
 	go localNode.Start()
@@ -57,8 +64,10 @@ func sendTransaction(privKey crypto.PrivateKey) error {
 	toPrivKey := crypto.GeneratePrivateKey()
 
 	tx := core.Transaction{
-		To:    toPrivKey.PublicKey(),
-		Value: 10,
+		To:       toPrivKey.PublicKey(),
+		Value:    10,
+		ChainID:  network.DefaultChainID,
+		GasLimit: core.TxBaseGas,
 	}
 
 	if err := tx.Sign(privKey); err != nil {
@@ -66,7 +75,7 @@ func sendTransaction(privKey crypto.PrivateKey) error {
 	}
 
 	buf := &bytes.Buffer{}
-	if err := tx.Encode(core.NewGobTxEncoder(buf)); err != nil {
+	if err := tx.Encode(core.NewBinaryTxEncoder(buf)); err != nil {
 		return err
 	}
 
@@ -101,14 +110,16 @@ func makeServer(id string, pk *crypto.PrivateKey, addr string, seedNodes []strin
 
 func createCollectionTx(privKey crypto.PrivateKey) types.Hash {
 	tx := core.NewTransaction(nil)
+	tx.ChainID = network.DefaultChainID
 	tx.TxInner = core.CollectionTx{
 		Fee:      10,
 		MetaData: []byte("My NFT collection"),
 	}
+	tx.GasLimit, _ = core.IntrinsicGas(tx)
 
 	tx.Sign(privKey)
 	buf := &bytes.Buffer{}
-	if err := tx.Encode(core.NewGobTxEncoder(buf)); err != nil {
+	if err := tx.Encode(core.NewBinaryTxEncoder(buf)); err != nil {
 		log.Fatal(err)
 	}
 
@@ -143,6 +154,7 @@ func nftMinter(privKey crypto.PrivateKey, collection types.Hash) {
 	}
 
 	tx := core.NewTransaction(nil)
+	tx.ChainID = network.DefaultChainID
 	tx.TxInner = core.MintTx{
 		Fee:             10,
 		NFT:             util.RandomHash(),
@@ -150,10 +162,11 @@ func nftMinter(privKey crypto.PrivateKey, collection types.Hash) {
 		Collection:      collection,
 		CollectionOwner: privKey.PublicKey(),
 	}
+	tx.GasLimit, _ = core.IntrinsicGas(tx)
 
 	tx.Sign(privKey)
 	buf := &bytes.Buffer{}
-	if err := tx.Encode(core.NewGobTxEncoder(buf)); err != nil {
+	if err := tx.Encode(core.NewBinaryTxEncoder(buf)); err != nil {
 		log.Fatal(err)
 	}
 
@@ -173,34 +186,3 @@ func nftMinter(privKey crypto.PrivateKey, collection types.Hash) {
 		panic(err)
 	}
 }
-
-func tcpTester() {
-	conn, err := net.Dial("tcp", ":3000")
-	if err != nil {
-		panic(err)
-	}
-
-	privkey := crypto.GeneratePrivateKey()
-
-	tx := core.NewTransaction(contract())
-	tx.Sign(privkey)
-	buf := &bytes.Buffer{}
-	if err := tx.Encode(core.NewGobTxEncoder(buf)); err != nil {
-		log.Fatal(err)
-	}
-
-	msg := network.NewMessage(network.MessageTypeTx, buf.Bytes())
-
-	_, err = conn.Write(msg.Bytes())
-	if err != nil {
-		panic(err)
-	}
-}
-
-func contract() []byte {
-	data := []byte{0x02, 0x0a, 0x03, 0x0a, 0x0b, 0x4f, 0x0c, 0x4f, 0x0c, 0x46, 0x0c, 0x03, 0x0a, 0x0d, 0x0f}
-	pushFoo := []byte{0x4f, 0x0c, 0x4f, 0x0c, 0x46, 0x0c, 0x03, 0x0a, 0x0d, 0x10}
-	data = append(data, pushFoo...)
-
-	return data
-}
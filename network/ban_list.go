@@ -0,0 +1,139 @@
+package network
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/k0yote/privatechain/crypto"
+)
+
+// Ban keeps a node from connecting until Until, or forever when Until is
+// zero.
+type Ban struct {
+	NodeKey string
+	Until   time.Time
+	Reason  string
+}
+
+func (b Ban) expired(now time.Time) bool {
+	return !b.Until.IsZero() && !now.Before(b.Until)
+}
+
+// banList holds the banned node keys. When path is set every change is
+// written to it, so bans survive restarts.
+type banList struct {
+	lock sync.RWMutex
+	path string
+	bans map[string]Ban
+}
+
+func newBanList(path string) (*banList, error) {
+	l := &banList{
+		path: path,
+		bans: make(map[string]Ban),
+	}
+	if len(path) == 0 {
+		return l, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	bans := []Ban{}
+	if err := json.Unmarshal(b, &bans); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for _, ban := range bans {
+		if !ban.expired(now) {
+			l.bans[ban.NodeKey] = ban
+		}
+	}
+
+	return l, nil
+}
+
+func (l *banList) isBanned(nodeKey crypto.PublicKey, now time.Time) bool {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	ban, ok := l.bans[nodeKey.String()]
+	return ok && !ban.expired(now)
+}
+
+func (l *banList) add(ban Ban) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.bans[ban.NodeKey] = ban
+
+	return l.saveLocked()
+}
+
+// remove lifts the ban of a node and reports whether it was banned.
+func (l *banList) remove(nodeKey crypto.PublicKey) (bool, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if _, ok := l.bans[nodeKey.String()]; !ok {
+		return false, nil
+	}
+	delete(l.bans, nodeKey.String())
+
+	return true, l.saveLocked()
+}
+
+// list returns the bans that have not expired.
+func (l *banList) list(now time.Time) []Ban {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	bans := []Ban{}
+	for _, ban := range l.bans {
+		if !ban.expired(now) {
+			bans = append(bans, ban)
+		}
+	}
+
+	sort.Slice(bans, func(i, j int) bool { return bans[i].NodeKey < bans[j].NodeKey })
+
+	return bans
+}
+
+// saveLocked writes the bans to a temporary file first, so a crash cannot
+// leave a truncated list behind.
+func (l *banList) saveLocked() error {
+	if len(l.path) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	bans := []Ban{}
+	for _, ban := range l.bans {
+		if !ban.expired(now) {
+			bans = append(bans, ban)
+		}
+	}
+
+	b, err := json.MarshalIndent(bans, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := l.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, l.path)
+}
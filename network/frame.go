@@ -0,0 +1,90 @@
+package network
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// Messages sent over TCP are framed with a fixed size header:
+//
+//	magic    4 bytes  FrameMagic
+//	version  1 byte   FrameVersion
+//	type     1 byte   MessageType
+//	length   4 bytes  big endian length of the payload
+//	checksum 4 bytes  big endian CRC-32 (IEEE) of the payload
+//
+// followed by the payload, the data of the message.
+const (
+	FrameMagic      uint32 = 0x6b30796f // "k0yo"
+	FrameVersion    byte   = 1
+	frameHeaderSize        = 14
+)
+
+// MaxMessageSize is the largest payload accepted from a peer.
+const MaxMessageSize = 32 << 20
+
+var (
+	ErrInvalidFrame    = errors.New("invalid frame")
+	ErrMessageTooLarge = errors.New("message too large")
+)
+
+// encodeFrame returns the frame of a message of type t with the given data.
+func encodeFrame(t MessageType, data []byte) ([]byte, error) {
+	if len(data) > MaxMessageSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrMessageTooLarge, len(data))
+	}
+
+	b := make([]byte, frameHeaderSize+len(data))
+	binary.BigEndian.PutUint32(b[0:4], FrameMagic)
+	b[4] = FrameVersion
+	b[5] = byte(t)
+	binary.BigEndian.PutUint32(b[6:10], uint32(len(data)))
+	binary.BigEndian.PutUint32(b[10:14], crc32.ChecksumIEEE(data))
+	copy(b[frameHeaderSize:], data)
+
+	return b, nil
+}
+
+// Frame returns the message framed for sending over TCP.
+func (msg *Message) Frame() ([]byte, error) {
+	return encodeFrame(msg.Header, msg.Data)
+}
+
+// readFrame reads the next frame from r, which may span any number of reads.
+// Payloads larger than maxSize are rejected before they are read. A clean
+// end of the stream before a frame is reported as io.EOF.
+func readFrame(r io.Reader, maxSize int) (*Message, error) {
+	header := make([]byte, frameHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	if magic := binary.BigEndian.Uint32(header[0:4]); magic != FrameMagic {
+		return nil, fmt.Errorf("%w: bad magic (%x)", ErrInvalidFrame, magic)
+	}
+	if header[4] != FrameVersion {
+		return nil, fmt.Errorf("%w: unsupported version (%d)", ErrInvalidFrame, header[4])
+	}
+
+	length := binary.BigEndian.Uint32(header[6:10])
+	if uint64(length) > uint64(maxSize) {
+		return nil, fmt.Errorf("%w: %d bytes", ErrMessageTooLarge, length)
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	if sum := binary.BigEndian.Uint32(header[10:14]); sum != crc32.ChecksumIEEE(data) {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrInvalidFrame)
+	}
+
+	return NewMessage(MessageType(header[5]), data), nil
+}
@@ -0,0 +1,283 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"time"
+
+	"github.com/k0yote/privatechain/core"
+	"github.com/k0yote/privatechain/types"
+)
+
+// ProtocolVersion is the version of the wire protocol spoken by this node.
+// Peers agree on the lower of their versions, which has to be at least
+// MinProtocolVersion.
+const (
+	ProtocolVersion    uint32 = 1
+	MinProtocolVersion uint32 = 1
+)
+
+// Capabilities a node can offer to its peers. Only the capabilities both
+// sides of a connection offer are used.
+const (
+	// CapabilityBlocks is the relay of new blocks and serving GetBlocks.
+	CapabilityBlocks = "blocks"
+	// CapabilityTxs is the relay of transactions.
+	CapabilityTxs = "txs"
+)
+
+var defaultCapabilities = []string{CapabilityBlocks, CapabilityTxs}
+
+const (
+	handshakeTimeout = 5 * time.Second
+	// maxHandshakeSize bounds the handshake we read from peers that have
+	// not identified themselves yet.
+	maxHandshakeSize = 4096
+)
+
+var ErrIncompatiblePeer = errors.New("incompatible peer")
+
+// HandshakeMessage is the first message sent in both directions of a new
+// connection. No other message is processed before the handshake of the
+// peer was accepted.
+type HandshakeMessage struct {
+	ID              string
+	ProtocolVersion uint32
+	GenesisHash     types.Hash
+	ChainID         uint64
+	Height          uint32
+	Capabilities    []string
+}
+
+// handshakeFields are the fixed size fields of a HandshakeMessage in the
+// order they are encoded.
+type handshakeFields struct {
+	ProtocolVersion uint32
+	GenesisHash     types.Hash
+	ChainID         uint64
+	Height          uint32
+}
+
+// Encode writes the ID, the fixed size fields and the capabilities of m in
+// big endian, strings prefixed with their 16 bit length.
+func (m *HandshakeMessage) Encode(w io.Writer) error {
+	if err := writeString(w, m.ID); err != nil {
+		return err
+	}
+
+	fields := handshakeFields{
+		ProtocolVersion: m.ProtocolVersion,
+		GenesisHash:     m.GenesisHash,
+		ChainID:         m.ChainID,
+		Height:          m.Height,
+	}
+	if err := binary.Write(w, binary.BigEndian, &fields); err != nil {
+		return err
+	}
+
+	if len(m.Capabilities) > math.MaxUint16 {
+		return fmt.Errorf("too many capabilities (%d)", len(m.Capabilities))
+	}
+	if err := binary.Write(w, binary.BigEndian, uint16(len(m.Capabilities))); err != nil {
+		return err
+	}
+	for _, c := range m.Capabilities {
+		if err := writeString(w, c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *HandshakeMessage) Decode(r io.Reader) error {
+	id, err := readString(r)
+	if err != nil {
+		return err
+	}
+
+	var fields handshakeFields
+	if err := binary.Read(r, binary.BigEndian, &fields); err != nil {
+		return err
+	}
+
+	var n uint16
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return err
+	}
+
+	capabilities := []string{}
+	for i := uint16(0); i < n; i++ {
+		c, err := readString(r)
+		if err != nil {
+			return err
+		}
+		capabilities = append(capabilities, c)
+	}
+
+	*m = HandshakeMessage{
+		ID:              id,
+		ProtocolVersion: fields.ProtocolVersion,
+		GenesisHash:     fields.GenesisHash,
+		ChainID:         fields.ChainID,
+		Height:          fields.Height,
+		Capabilities:    capabilities,
+	}
+
+	return nil
+}
+
+func writeString(w io.Writer, s string) error {
+	if len(s) > math.MaxUint16 {
+		return fmt.Errorf("string of %d bytes is too long", len(s))
+	}
+	if err := binary.Write(w, binary.BigEndian, uint16(len(s))); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, s)
+
+	return err
+}
+
+func readString(r io.Reader) (string, error) {
+	var n uint16
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return "", err
+	}
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+func (s *Server) handshakeMessage() (*HandshakeMessage, error) {
+	genesis, err := s.chain.GetHeader(0)
+	if err != nil {
+		return nil, err
+	}
+
+	return &HandshakeMessage{
+		ID:              s.ID,
+		ProtocolVersion: ProtocolVersion,
+		GenesisHash:     core.BlockHasher{}.Hash(genesis),
+		ChainID:         s.ChainID,
+		Height:          s.chain.Height(),
+		Capabilities:    s.Capabilities,
+	}, nil
+}
+
+// handshake secures the connection to a newly connected peer, exchanges
+// handshakes with it and stores its identity and the negotiated protocol
+// version and capabilities on it.
+func (s *Server) handshake(peer *TCPPeer) error {
+	conn, nodeKey, err := secureHandshake(peer.conn, *s.NodeKey, handshakeTimeout)
+	if err != nil {
+		return err
+	}
+	peer.conn = conn
+	peer.NodeKey = nodeKey
+
+	if expected := s.peers.expectedKey(peer.dialAddr); expected != nil && !bytes.Equal(expected, nodeKey) {
+		return fmt.Errorf("%w: node key (%s) => expected (%s)", ErrAuthFailed, nodeKey, expected)
+	}
+
+	if s.bans.isBanned(nodeKey, time.Now()) {
+		return fmt.Errorf("%w: node %s", ErrPeerBanned, nodeKey)
+	}
+
+	ours, err := s.handshakeMessage()
+	if err != nil {
+		return err
+	}
+
+	theirs, err := exchangeHandshake(peer.conn, ours, handshakeTimeout)
+	if err != nil {
+		return err
+	}
+
+	return acceptHandshake(peer, ours, theirs)
+}
+
+// exchangeHandshake sends ours and reads the handshake of the other side at
+// the same time, so neither side waits for the other to read first.
+func exchangeHandshake(conn net.Conn, ours *HandshakeMessage, timeout time.Duration) (*HandshakeMessage, error) {
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+	defer conn.SetDeadline(time.Time{})
+
+	buf := new(bytes.Buffer)
+	if err := ours.Encode(buf); err != nil {
+		return nil, err
+	}
+
+	frame, err := NewMessage(MessageTypeHandshake, buf.Bytes()).Frame()
+	if err != nil {
+		return nil, err
+	}
+
+	sendErr := make(chan error, 1)
+	go func() {
+		_, err := conn.Write(frame)
+		sendErr <- err
+	}()
+
+	msg, err := readFrame(conn, maxHandshakeSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read handshake: %w", err)
+	}
+	if msg.Header != MessageTypeHandshake {
+		return nil, fmt.Errorf("%w: expected handshake, got message type (%d)", ErrIncompatiblePeer, msg.Header)
+	}
+
+	theirs := new(HandshakeMessage)
+	if err := theirs.Decode(bytes.NewReader(msg.Data)); err != nil {
+		return nil, err
+	}
+
+	if err := <-sendErr; err != nil {
+		return nil, fmt.Errorf("failed to send handshake: %w", err)
+	}
+
+	return theirs, nil
+}
+
+func acceptHandshake(peer *TCPPeer, ours, theirs *HandshakeMessage) error {
+	switch {
+	case theirs.ChainID != ours.ChainID:
+		return fmt.Errorf("%w: chain id (%d) => ours (%d)", ErrIncompatiblePeer, theirs.ChainID, ours.ChainID)
+	case theirs.GenesisHash != ours.GenesisHash:
+		return fmt.Errorf("%w: genesis (%s) => ours (%s)", ErrIncompatiblePeer, theirs.GenesisHash, ours.GenesisHash)
+	case theirs.ProtocolVersion < MinProtocolVersion:
+		return fmt.Errorf("%w: protocol version (%d) is below (%d)", ErrIncompatiblePeer, theirs.ProtocolVersion, MinProtocolVersion)
+	case len(ours.ID) > 0 && theirs.ID == ours.ID:
+		return fmt.Errorf("%w: connected to ourselves (%s)", ErrIncompatiblePeer, ours.ID)
+	}
+
+	peer.ID = theirs.ID
+	peer.Height = theirs.Height
+	peer.ProtocolVersion = ours.ProtocolVersion
+	if theirs.ProtocolVersion < peer.ProtocolVersion {
+		peer.ProtocolVersion = theirs.ProtocolVersion
+	}
+
+	peer.Capabilities = []string{}
+	for _, c := range ours.Capabilities {
+		for _, other := range theirs.Capabilities {
+			if c == other {
+				peer.Capabilities = append(peer.Capabilities, c)
+				break
+			}
+		}
+	}
+
+	return nil
+}
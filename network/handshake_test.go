@@ -0,0 +1,164 @@
+package network
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/k0yote/privatechain/crypto"
+	"github.com/k0yote/privatechain/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestServer(t *testing.T, opts ServerOpts) *Server {
+	s, err := NewServer(opts)
+	assert.Nil(t, err)
+
+	return s
+}
+
+// secureRemote runs the remote side of the key exchange with a new node key.
+func secureRemote(t *testing.T, conn net.Conn) net.Conn {
+	sc, _, err := secureHandshake(conn, crypto.GeneratePrivateKey(), time.Second)
+	assert.Nil(t, err)
+
+	return sc
+}
+
+func TestHandshakeMessageCodec(t *testing.T) {
+	msg := &HandshakeMessage{
+		ID:              "A",
+		ProtocolVersion: ProtocolVersion,
+		GenesisHash:     types.Hash{0x01, 0x02},
+		ChainID:         7,
+		Height:          42,
+		Capabilities:    []string{CapabilityBlocks, CapabilityTxs},
+	}
+
+	buf := new(bytes.Buffer)
+	assert.Nil(t, msg.Encode(buf))
+	encoded := buf.Bytes()
+
+	decoded := new(HandshakeMessage)
+	assert.Nil(t, decoded.Decode(bytes.NewReader(encoded)))
+	assert.Equal(t, msg, decoded)
+
+	// The encoding is deterministic.
+	buf = new(bytes.Buffer)
+	assert.Nil(t, decoded.Encode(buf))
+	assert.Equal(t, encoded, buf.Bytes())
+
+	assert.NotNil(t, new(HandshakeMessage).Decode(bytes.NewReader(encoded[:len(encoded)-1])))
+}
+
+func TestHandshake(t *testing.T) {
+	a := newTestServer(t, ServerOpts{ID: "A"})
+	b := newTestServer(t, ServerOpts{ID: "B", Capabilities: []string{CapabilityBlocks, "other"}})
+
+	connA, connB := net.Pipe()
+	peerA, peerB := &TCPPeer{conn: connB}, &TCPPeer{conn: connA}
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- b.handshake(peerA) }()
+	assert.Nil(t, a.handshake(peerB))
+	assert.Nil(t, <-errCh)
+
+	assert.Equal(t, b.NodeKey.PublicKey(), peerB.NodeKey)
+	assert.Equal(t, a.NodeKey.PublicKey(), peerA.NodeKey)
+	assert.Equal(t, "B", peerB.ID)
+	assert.Equal(t, "A", peerA.ID)
+	assert.Equal(t, ProtocolVersion, peerB.ProtocolVersion)
+	assert.Equal(t, []string{CapabilityBlocks}, peerB.Capabilities)
+	assert.Equal(t, []string{CapabilityBlocks}, peerA.Capabilities)
+	assert.False(t, peerB.HasCapability(CapabilityTxs))
+}
+
+func TestHandshakeRejected(t *testing.T) {
+	s := newTestServer(t, ServerOpts{ID: "A"})
+	ours, err := s.handshakeMessage()
+	assert.Nil(t, err)
+
+	other := newTestServer(t, ServerOpts{ID: "B", ChainID: 2})
+	otherChain, err := other.handshakeMessage()
+	assert.Nil(t, err)
+
+	tests := map[string]func(m *HandshakeMessage){
+		"chain id":     func(m *HandshakeMessage) { m.ChainID = 2 },
+		"genesis":      func(m *HandshakeMessage) { m.GenesisHash = otherChain.GenesisHash },
+		"old protocol": func(m *HandshakeMessage) { m.ProtocolVersion = MinProtocolVersion - 1 },
+		"ourselves":    func(m *HandshakeMessage) { m.ID = "A" },
+	}
+
+	for name, modify := range tests {
+		t.Run(name, func(t *testing.T) {
+			theirs := *ours
+			theirs.ID = "B"
+			modify(&theirs)
+
+			local, remote := net.Pipe()
+			go func() {
+				exchangeHandshake(secureRemote(t, remote), &theirs, time.Second)
+			}()
+
+			err := s.handshake(&TCPPeer{conn: local})
+			assert.True(t, errors.Is(err, ErrIncompatiblePeer), err)
+		})
+	}
+}
+
+func TestHandshakeRequiredFirst(t *testing.T) {
+	s := newTestServer(t, ServerOpts{ID: "A"})
+
+	local, remote := net.Pipe()
+	go func() {
+		peer := &TCPPeer{conn: secureRemote(t, remote)}
+		peer.Send(NewMessage(MessageTypeGetStatus, nil).Bytes())
+	}()
+
+	err := s.handshake(&TCPPeer{conn: local})
+	assert.True(t, errors.Is(err, ErrIncompatiblePeer), err)
+}
+
+func TestHandshakePinnedNodeKey(t *testing.T) {
+	pinned := crypto.GeneratePrivateKey()
+	a := newTestServer(t, ServerOpts{
+		ID:              "A",
+		PersistentPeers: []string{pinned.PublicKey().String() + "@:4000"},
+	})
+	b := newTestServer(t, ServerOpts{ID: "B", NodeKey: &pinned})
+
+	local, remote := net.Pipe()
+	errCh := make(chan error, 1)
+	go func() { errCh <- b.handshake(&TCPPeer{conn: remote}) }()
+	assert.Nil(t, a.handshake(&TCPPeer{conn: local, Outgoing: true, dialAddr: ":4000"}))
+	assert.Nil(t, <-errCh)
+
+	local, remote = net.Pipe()
+	go func() {
+		secureHandshake(remote, crypto.GeneratePrivateKey(), time.Second)
+		remote.Close()
+	}()
+	err := a.handshake(&TCPPeer{conn: local, Outgoing: true, dialAddr: ":4000"})
+	assert.True(t, errors.Is(err, ErrAuthFailed), err)
+}
+
+func TestParsePeerAddr(t *testing.T) {
+	key := crypto.GeneratePrivateKey().PublicKey()
+
+	addr, nodeKey, err := parsePeerAddr(key.String() + "@127.0.0.1:3000")
+	assert.Nil(t, err)
+	assert.Equal(t, "127.0.0.1:3000", addr)
+	assert.Equal(t, key, nodeKey)
+
+	addr, nodeKey, err = parsePeerAddr(":3000")
+	assert.Nil(t, err)
+	assert.Equal(t, ":3000", addr)
+	assert.Nil(t, nodeKey)
+
+	_, _, err = parsePeerAddr("abcd@:3000")
+	assert.NotNil(t, err)
+	_, err = NewServer(ServerOpts{SeedNodes: []string{"abcd@:3000"}})
+	assert.NotNil(t, err)
+}
@@ -1,6 +1,24 @@
 package network
 
-import "github.com/k0yote/privatechain/core"
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/k0yote/privatechain/core"
+)
+
+// maxBlocksPerMessage bounds the number of blocks a peer can make us decode
+// from a single BlocksMessage.
+const maxBlocksPerMessage = 10_000
+
+// GetBlocks is answered with pages of at most maxBlocksPerPage blocks and
+// maxPageSize encoded bytes, which keeps them well within the limits of a
+// single message.
+const (
+	maxBlocksPerPage = 256
+	maxPageSize      = MaxMessageSize / 2
+)
 
 type GetBlocksMessage struct {
 	From uint32
@@ -11,6 +29,43 @@ type BlocksMessage struct {
 	Blocks []*core.Block
 }
 
+func (m *BlocksMessage) Encode(w io.Writer) error {
+	if err := binary.Write(w, binary.BigEndian, uint32(len(m.Blocks))); err != nil {
+		return err
+	}
+
+	enc := core.NewBinaryBlockEncoder(w)
+	for _, b := range m.Blocks {
+		if err := b.Encode(enc); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *BlocksMessage) Decode(r io.Reader) error {
+	var n uint32
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return err
+	}
+	if n > maxBlocksPerMessage {
+		return fmt.Errorf("too many blocks in message (%d)", n)
+	}
+
+	dec := core.NewBinaryBlockDecoder(r)
+	m.Blocks = make([]*core.Block, n)
+	for i := range m.Blocks {
+		b := new(core.Block)
+		if err := b.Decode(dec); err != nil {
+			return err
+		}
+		m.Blocks[i] = b
+	}
+
+	return nil
+}
+
 type GetStatusMessage struct{}
 
 type StatusMessage struct {
@@ -0,0 +1,414 @@
+package network
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/k0yote/privatechain/crypto"
+)
+
+const (
+	DefaultMaxInboundPeers  = 32
+	DefaultMaxOutboundPeers = 16
+)
+
+const (
+	dialTimeout  = 5 * time.Second
+	dialInterval = time.Second
+	// Redials of a target wait minBackoff after the first failure and twice
+	// as long after every following one, up to maxBackoff.
+	minBackoff = time.Second
+	maxBackoff = time.Minute
+)
+
+var ErrTooManyPeers = errors.New("too many peers")
+
+// PeerState is the state of a peer or an address we keep connected to.
+type PeerState byte
+
+const (
+	PeerStateDialing PeerState = iota + 1
+	PeerStateHandshaking
+	PeerStateConnected
+	// PeerStateBackoff is a target waiting to be redialed.
+	PeerStateBackoff
+)
+
+func (s PeerState) String() string {
+	switch s {
+	case PeerStateDialing:
+		return "dialing"
+	case PeerStateHandshaking:
+		return "handshaking"
+	case PeerStateConnected:
+		return "connected"
+	case PeerStateBackoff:
+		return "backoff"
+	default:
+		return fmt.Sprintf("PeerState(%d)", byte(s))
+	}
+}
+
+// dialTarget is an address the server dials on its own and redials whenever
+// its connection is lost. When nodeKey is set the node at addr has to
+// authenticate with it.
+type dialTarget struct {
+	addr    string
+	nodeKey crypto.PublicKey
+	state   PeerState
+	// peer is the connection to the node at addr while connected. It is
+	// not dialed by us when the node was already connected from elsewhere.
+	peer     *TCPPeer
+	attempts int
+	nextDial time.Time
+}
+
+// peerManager keeps track of the connections of a server.
+type peerManager struct {
+	lock        sync.RWMutex
+	maxInbound  int
+	maxOutbound int
+	// pending holds the remote addresses of the peers that are still
+	// handshaking. Their conn is replaced by the handshake.
+	pending   map[*TCPPeer]net.Addr
+	connected map[net.Addr]*TCPPeer
+	targets   map[string]*dialTarget
+}
+
+func newPeerManager(maxInbound, maxOutbound int) *peerManager {
+	return &peerManager{
+		maxInbound:  maxInbound,
+		maxOutbound: maxOutbound,
+		pending:     make(map[*TCPPeer]net.Addr),
+		connected:   make(map[net.Addr]*TCPPeer),
+		targets:     make(map[string]*dialTarget),
+	}
+}
+
+// parsePeerAddr splits a peer address of the form [nodekey@]host:port into
+// the address to dial and the hex encoded node key expected from the peer.
+func parsePeerAddr(s string) (string, crypto.PublicKey, error) {
+	key, addr, ok := strings.Cut(s, "@")
+	if !ok {
+		return s, nil, nil
+	}
+
+	b, err := hex.DecodeString(key)
+	if err != nil || !crypto.PublicKey(b).Valid() {
+		return "", nil, fmt.Errorf("invalid node key in peer address (%s)", s)
+	}
+
+	return addr, crypto.PublicKey(b), nil
+}
+
+func (m *peerManager) addTarget(addr string, nodeKey crypto.PublicKey) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if t, ok := m.targets[addr]; ok {
+		if nodeKey != nil {
+			t.nodeKey = nodeKey
+		}
+		return
+	}
+
+	m.targets[addr] = &dialTarget{
+		addr:    addr,
+		nodeKey: nodeKey,
+		state:   PeerStateBackoff,
+	}
+}
+
+// expectedKey returns the node key the target at addr has to authenticate
+// with, or nil if any key is accepted.
+func (m *peerManager) expectedKey(addr string) crypto.PublicKey {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	if t, ok := m.targets[addr]; ok {
+		return t.nodeKey
+	}
+
+	return nil
+}
+
+// count returns the number of inbound and outbound connections, including
+// the ones being dialed or handshaking.
+func (m *peerManager) count() (inbound, outbound int) {
+	for peer := range m.pending {
+		if !peer.Outgoing {
+			inbound++
+		}
+	}
+	for _, peer := range m.connected {
+		if !peer.Outgoing {
+			inbound++
+		}
+	}
+	for _, t := range m.targets {
+		if t.state != PeerStateBackoff {
+			outbound++
+		}
+	}
+
+	return inbound, outbound
+}
+
+// due marks the targets that are to be dialed now as dialing and returns
+// their addresses.
+func (m *peerManager) due(now time.Time) []string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	_, outbound := m.count()
+
+	addrs := []string{}
+	for addr, t := range m.targets {
+		if outbound >= m.maxOutbound {
+			break
+		}
+		if t.state != PeerStateBackoff || now.Before(t.nextDial) {
+			continue
+		}
+
+		t.state = PeerStateDialing
+		addrs = append(addrs, addr)
+		outbound++
+	}
+
+	return addrs
+}
+
+// accept registers a new connection as handshaking. Connections we dialed
+// were accounted for by due already.
+func (m *peerManager) accept(peer *TCPPeer) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if !peer.Outgoing {
+		if inbound, _ := m.count(); inbound >= m.maxInbound {
+			return fmt.Errorf("%w: %d inbound connections", ErrTooManyPeers, inbound)
+		}
+	}
+
+	m.pending[peer] = peer.conn.RemoteAddr()
+	if t, ok := m.targets[peer.dialAddr]; ok {
+		t.state = PeerStateHandshaking
+	}
+
+	return nil
+}
+
+// connect marks a peer that completed its handshake as connected. A node
+// can only be connected once, a duplicate connection is dropped and the
+// target it was dialed for is served by the existing connection.
+func (m *peerManager) connect(peer *TCPPeer) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	delete(m.pending, peer)
+
+	for _, other := range m.connected {
+		if bytes.Equal(other.NodeKey, peer.NodeKey) {
+			m.connectTargetLocked(peer.dialAddr, other)
+			return fmt.Errorf("node %s already connected from %s", peer.NodeKey, other.conn.RemoteAddr())
+		}
+	}
+
+	peer.connectedAt = time.Now()
+	m.connected[peer.conn.RemoteAddr()] = peer
+	m.connectTargetLocked(peer.dialAddr, peer)
+
+	return nil
+}
+
+func (m *peerManager) connectTargetLocked(addr string, peer *TCPPeer) {
+	if t, ok := m.targets[addr]; ok {
+		t.state = PeerStateConnected
+		t.peer = peer
+		t.attempts = 0
+	}
+}
+
+// remove forgets a peer whose connection was closed.
+func (m *peerManager) remove(peer *TCPPeer) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.removeLocked(peer)
+}
+
+func (m *peerManager) removeLocked(peer *TCPPeer) {
+	delete(m.pending, peer)
+
+	addr := peer.conn.RemoteAddr()
+	if m.connected[addr] == peer {
+		delete(m.connected, addr)
+	}
+
+	for _, t := range m.targets {
+		// Targets that are still handshaking have no peer set yet.
+		if t.peer == peer || (t.peer == nil && len(peer.dialAddr) > 0 && t.addr == peer.dialAddr) {
+			t.peer = nil
+			m.backoffLocked(t)
+		}
+	}
+}
+
+// dialFailed schedules the next dial of a target that could not be reached.
+func (m *peerManager) dialFailed(addr string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if t, ok := m.targets[addr]; ok {
+		m.backoffLocked(t)
+	}
+}
+
+func (m *peerManager) backoffLocked(t *dialTarget) {
+	backoff := maxBackoff
+	if t.attempts < 16 {
+		if d := minBackoff << t.attempts; d < maxBackoff {
+			backoff = d
+		}
+	}
+
+	t.state = PeerStateBackoff
+	t.attempts++
+	t.nextDial = time.Now().Add(backoff)
+}
+
+func (m *peerManager) get(addr net.Addr) (*TCPPeer, bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	peer, ok := m.connected[addr]
+	return peer, ok
+}
+
+// peers returns the connected peers.
+func (m *peerManager) peers() []*TCPPeer {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	peers := make([]*TCPPeer, 0, len(m.connected))
+	for _, peer := range m.connected {
+		peers = append(peers, peer)
+	}
+
+	return peers
+}
+
+// penalize lowers the score of the connected peer at addr by n after
+// crediting the score it recovered since it was last updated.
+func (m *peerManager) penalize(addr net.Addr, n int, now time.Time) (*TCPPeer, int, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	peer, ok := m.connected[addr]
+	if !ok {
+		return nil, 0, false
+	}
+
+	peer.score = recoveredScore(peer, now) - n
+	peer.scoreUpdated = now
+
+	return peer, peer.score, true
+}
+
+// countMessage counts a message received from the peer at addr and reports
+// whether the peer exceeded maxMessagesPerSecond.
+func (m *peerManager) countMessage(addr net.Addr, now time.Time) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	peer, ok := m.connected[addr]
+	if !ok {
+		return false
+	}
+
+	if now.Sub(peer.windowStart) >= time.Second {
+		peer.windowStart = now
+		peer.windowCount = 0
+	}
+	peer.windowCount++
+
+	return peer.windowCount > maxMessagesPerSecond
+}
+
+// byNodeKey returns the connected peers with the given node key.
+func (m *peerManager) byNodeKey(nodeKey []byte) []*TCPPeer {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	peers := []*TCPPeer{}
+	for _, peer := range m.connected {
+		if bytes.Equal(peer.NodeKey, nodeKey) {
+			peers = append(peers, peer)
+		}
+	}
+
+	return peers
+}
+
+// peerInfo describes a peer or an address we keep connected to.
+type peerInfo struct {
+	Addr     string
+	State    PeerState
+	Outgoing bool
+	// Peer is only set once connected, the handshake is still filling it
+	// in before.
+	Peer        *TCPPeer
+	ConnectedAt time.Time
+	Score       int
+	// The fields below are set for targets that are not connected.
+	Attempts int
+	NextDial time.Time
+}
+
+func (m *peerManager) info() []peerInfo {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	infos := []peerInfo{}
+	for peer, addr := range m.pending {
+		infos = append(infos, peerInfo{
+			Addr:     addr.String(),
+			State:    PeerStateHandshaking,
+			Outgoing: peer.Outgoing,
+		})
+	}
+	for addr, peer := range m.connected {
+		infos = append(infos, peerInfo{
+			Addr:        addr.String(),
+			State:       PeerStateConnected,
+			Outgoing:    peer.Outgoing,
+			Peer:        peer,
+			ConnectedAt: peer.connectedAt,
+			Score:       recoveredScore(peer, time.Now()),
+		})
+	}
+	for _, t := range m.targets {
+		if t.state != PeerStateDialing && t.state != PeerStateBackoff {
+			continue
+		}
+		infos = append(infos, peerInfo{
+			Addr:     t.addr,
+			State:    t.state,
+			Outgoing: true,
+			Attempts: t.attempts,
+			NextDial: t.nextDial,
+		})
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Addr < infos[j].Addr })
+
+	return infos
+}
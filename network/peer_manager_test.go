@@ -0,0 +1,131 @@
+package network
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/k0yote/privatechain/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+// addrConn is a connection that only knows its remote address.
+type addrConn struct {
+	net.Conn
+	addr net.Addr
+}
+
+func (c addrConn) RemoteAddr() net.Addr { return c.addr }
+
+func newTestPeer(port int, outgoing bool, dialAddr string) *TCPPeer {
+	return &TCPPeer{
+		conn:     addrConn{addr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}},
+		Outgoing: outgoing,
+		NodeKey:  crypto.GeneratePrivateKey().PublicKey(),
+		dialAddr: dialAddr,
+	}
+}
+
+func TestPeerManagerInboundLimit(t *testing.T) {
+	m := newPeerManager(2, 1)
+
+	first := newTestPeer(1, false, "")
+	assert.Nil(t, m.accept(first))
+	assert.Nil(t, m.connect(first))
+	assert.Nil(t, m.accept(newTestPeer(2, false, "")))
+
+	err := m.accept(newTestPeer(3, false, ""))
+	assert.True(t, errors.Is(err, ErrTooManyPeers), err)
+
+	m.remove(first)
+	assert.Nil(t, m.accept(newTestPeer(3, false, "")))
+}
+
+func TestPeerManagerOutboundLimit(t *testing.T) {
+	m := newPeerManager(2, 1)
+	m.addTarget(":3000", nil)
+	m.addTarget(":4000", nil)
+
+	assert.Len(t, m.due(time.Now()), 1)
+	assert.Empty(t, m.due(time.Now()))
+}
+
+func TestPeerManagerBackoff(t *testing.T) {
+	m := newPeerManager(1, 1)
+	m.addTarget(":3000", nil)
+	assert.Equal(t, []string{":3000"}, m.due(time.Now()))
+
+	m.dialFailed(":3000")
+	assert.Empty(t, m.due(time.Now()))
+	assert.Equal(t, []string{":3000"}, m.due(time.Now().Add(minBackoff)))
+
+	m.dialFailed(":3000")
+	assert.Empty(t, m.due(time.Now().Add(minBackoff)))
+	assert.Equal(t, []string{":3000"}, m.due(time.Now().Add(2*minBackoff)))
+
+	info := m.info()
+	assert.Len(t, info, 1)
+	assert.Equal(t, PeerStateDialing, info[0].State)
+	assert.Equal(t, 2, info[0].Attempts)
+
+	peer := newTestPeer(3000, true, ":3000")
+	assert.Nil(t, m.accept(peer))
+	assert.Nil(t, m.connect(peer))
+	assert.Equal(t, PeerStateConnected, m.info()[0].State)
+	assert.Equal(t, 0, m.targets[":3000"].attempts)
+}
+
+func TestPeerManagerRedial(t *testing.T) {
+	m := newPeerManager(1, 1)
+	m.addTarget(":3000", nil)
+	m.due(time.Now())
+
+	peer := newTestPeer(3000, true, ":3000")
+	assert.Nil(t, m.accept(peer))
+	assert.Nil(t, m.connect(peer))
+
+	m.remove(peer)
+	_, ok := m.get(peer.conn.RemoteAddr())
+	assert.False(t, ok)
+	assert.Equal(t, []string{":3000"}, m.due(time.Now().Add(minBackoff)))
+}
+
+func TestPeerManagerDuplicateNode(t *testing.T) {
+	m := newPeerManager(2, 1)
+
+	first, second := newTestPeer(1, false, ""), newTestPeer(2, false, "")
+	second.NodeKey = first.NodeKey
+
+	assert.Nil(t, m.accept(first))
+	assert.Nil(t, m.accept(second))
+	assert.Nil(t, m.connect(first))
+	assert.NotNil(t, m.connect(second))
+	assert.Equal(t, []*TCPPeer{first}, m.peers())
+}
+
+func TestPeerManagerDuplicateTarget(t *testing.T) {
+	m := newPeerManager(2, 2)
+	m.addTarget(":3000", nil)
+	m.due(time.Now())
+
+	inbound, dialed := newTestPeer(1, false, ""), newTestPeer(3000, true, ":3000")
+	dialed.NodeKey = inbound.NodeKey
+
+	assert.Nil(t, m.accept(inbound))
+	assert.Nil(t, m.connect(inbound))
+	assert.Nil(t, m.accept(dialed))
+	assert.NotNil(t, m.connect(dialed))
+
+	// The target is served by the inbound connection instead of waiting
+	// for a redial.
+	target := m.targets[":3000"]
+	assert.Equal(t, PeerStateConnected, target.state)
+	assert.Equal(t, 0, target.attempts)
+	assert.Empty(t, m.pending)
+	assert.Empty(t, m.due(time.Now().Add(maxBackoff)))
+
+	m.remove(inbound)
+	assert.Equal(t, PeerStateBackoff, target.state)
+	assert.Equal(t, []string{":3000"}, m.due(time.Now().Add(minBackoff)))
+}
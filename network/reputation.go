@@ -0,0 +1,117 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/k0yote/privatechain/core"
+	"github.com/k0yote/privatechain/crypto"
+)
+
+// Peers start with a score of zero and lose points for misbehaving. A peer
+// whose score drops to banThreshold is disconnected and banned. Scores
+// recover one point per scoreRecovery, up to zero.
+const (
+	penaltyMalformed        = 10
+	penaltyInvalidSignature = 40
+	penaltyBadBlock         = 50
+	penaltySpam             = 5
+
+	banThreshold  = -100
+	scoreRecovery = 10 * time.Second
+
+	// maxMessagesPerSecond is the number of messages a peer can send per
+	// second before every further message counts as spam and is dropped.
+	maxMessagesPerSecond = 100
+
+	DefaultBanDuration = time.Hour
+)
+
+var ErrPeerBanned = errors.New("peer is banned")
+
+func recoveredScore(peer *TCPPeer, now time.Time) int {
+	if peer.score >= 0 {
+		return peer.score
+	}
+
+	score := peer.score + int(now.Sub(peer.scoreUpdated)/scoreRecovery)
+	if score > 0 {
+		return 0
+	}
+
+	return score
+}
+
+// penaltyFor returns the penalty for a message of a peer that could not be
+// processed. Only errors that prove the data of the peer forged or invalid
+// are penalized. Blocks we know already or cannot connect yet are expected
+// while syncing, transactions can be outdated by the time they reach us and
+// local failures are not the fault of the peer.
+func penaltyFor(err error) int {
+	switch {
+	case errors.Is(err, core.ErrInvalidSignature):
+		return penaltyInvalidSignature
+	case errors.Is(err, core.ErrInvalidDataHash),
+		errors.Is(err, core.ErrInvalidStateRoot),
+		errors.Is(err, core.ErrInvalidReceiptsRoot),
+		errors.Is(err, core.ErrInvalidTransaction),
+		errors.Is(err, core.ErrInvalidChainID):
+		return penaltyBadBlock
+	}
+
+	return 0
+}
+
+// penalize lowers the score of the peer at addr and bans it once the score
+// reaches banThreshold.
+func (s *Server) penalize(addr net.Addr, penalty int, reason error) {
+	if penalty == 0 {
+		return
+	}
+
+	peer, score, ok := s.peers.penalize(addr, penalty, time.Now())
+	if !ok {
+		return
+	}
+
+	s.Logger.Log("msg", "peer penalized", "addr", addr, "penalty", penalty, "score", score, "reason", reason)
+
+	if score > banThreshold {
+		return
+	}
+
+	if err := s.Ban(peer.NodeKey, s.BanDuration, fmt.Sprintf("score %d: %s", score, reason)); err != nil {
+		s.Logger.Log("msg", "failed to ban peer", "addr", addr, "err", err)
+	}
+}
+
+// Ban disconnects the node with the given key and refuses its connections
+// for the given duration, or forever when it is zero.
+func (s *Server) Ban(nodeKey crypto.PublicKey, duration time.Duration, reason string) error {
+	ban := Ban{
+		NodeKey: nodeKey.String(),
+		Reason:  reason,
+	}
+	if duration > 0 {
+		ban.Until = time.Now().Add(duration)
+	}
+
+	if err := s.bans.add(ban); err != nil {
+		return err
+	}
+
+	for _, peer := range s.peers.byNodeKey(nodeKey) {
+		peer.conn.Close()
+	}
+
+	s.Logger.Log("msg", "node banned", "nodeKey", ban.NodeKey, "until", ban.Until, "reason", reason)
+
+	return nil
+}
+
+// Unban lifts the ban of a node and reports whether it was banned.
+func (s *Server) Unban(nodeKey crypto.PublicKey) (bool, error) {
+	return s.bans.remove(nodeKey)
+}
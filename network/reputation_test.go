@@ -0,0 +1,176 @@
+package network
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/k0yote/privatechain/core"
+	"github.com/k0yote/privatechain/crypto"
+	"github.com/k0yote/privatechain/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBanListPersisted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bans.json")
+	banned, expired := crypto.GeneratePrivateKey().PublicKey(), crypto.GeneratePrivateKey().PublicKey()
+
+	l, err := newBanList(path)
+	assert.Nil(t, err)
+	assert.Nil(t, l.add(Ban{NodeKey: banned.String(), Reason: "manual"}))
+	assert.Nil(t, l.add(Ban{NodeKey: expired.String(), Until: time.Now().Add(time.Millisecond)}))
+	time.Sleep(time.Millisecond)
+
+	l, err = newBanList(path)
+	assert.Nil(t, err)
+	assert.True(t, l.isBanned(banned, time.Now()))
+	assert.False(t, l.isBanned(expired, time.Now()))
+	assert.Equal(t, []Ban{{NodeKey: banned.String(), Reason: "manual"}}, l.list(time.Now()))
+
+	ok, err := l.remove(banned)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	l, err = newBanList(path)
+	assert.Nil(t, err)
+	assert.Empty(t, l.list(time.Now()))
+}
+
+func TestPenaltyFor(t *testing.T) {
+	assert.Equal(t, 0, penaltyFor(core.ErrBlockKnown))
+	assert.Equal(t, 0, penaltyFor(fmt.Errorf("%w: ahead", core.ErrBlockTooHigh)))
+	assert.Equal(t, 0, penaltyFor(errors.New("disk full")))
+	assert.Equal(t, penaltyBadBlock, penaltyFor(fmt.Errorf("%w: block", core.ErrInvalidStateRoot)))
+	assert.Equal(t, penaltyBadBlock, penaltyFor(fmt.Errorf("%w: block", core.ErrInvalidDataHash)))
+	assert.Equal(t, penaltyBadBlock, penaltyFor(fmt.Errorf("%w: tx", core.ErrInvalidTransaction)))
+	assert.Equal(t, penaltyInvalidSignature, penaltyFor(fmt.Errorf("%w: forged", core.ErrInvalidSignature)))
+	assert.Equal(t, 0, penaltyFor(core.ErrNonceTooLow))
+}
+
+// blocksRPC returns an RPC carrying the blocks as a BlocksMessage.
+func blocksRPC(t *testing.T, from net.Addr, blocks ...*core.Block) RPC {
+	buf := new(bytes.Buffer)
+	assert.Nil(t, (&BlocksMessage{Blocks: blocks}).Encode(buf))
+
+	return RPC{
+		From:    from,
+		Payload: bytes.NewReader(NewMessage(MessageTypeBlocks, buf.Bytes()).Bytes()),
+	}
+}
+
+func signedBlock(t *testing.T, prevHeader *core.Header) *core.Block {
+	b, err := core.NewBlockFromPrevHeader(prevHeader, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, b.Sign(crypto.GeneratePrivateKey()))
+
+	return b
+}
+
+func TestOutOfOrderBlockNotPenalized(t *testing.T) {
+	s := newTestServer(t, ServerOpts{ID: "A"})
+
+	peer := newTestPeer(1, false, "")
+	assert.Nil(t, s.peers.accept(peer))
+	assert.Nil(t, s.peers.connect(peer))
+	addr := peer.conn.RemoteAddr()
+
+	genesis, err := s.chain.GetHeader(0)
+	assert.Nil(t, err)
+
+	ahead := *genesis
+	ahead.Height = 5
+	fork := *genesis
+	fork.Timestamp++
+
+	for i := 0; i < 10; i++ {
+		s.handleRPC(blocksRPC(t, addr, signedBlock(t, &ahead)))
+		s.handleRPC(blocksRPC(t, addr, signedBlock(t, &fork)))
+	}
+	assert.Equal(t, 0, peer.score)
+	assert.False(t, s.bans.isBanned(peer.NodeKey, time.Now()))
+
+	invalid := signedBlock(t, genesis)
+	invalid.DataHash = types.Hash{0x01}
+	assert.Nil(t, invalid.Sign(crypto.GeneratePrivateKey()))
+	s.handleRPC(blocksRPC(t, addr, invalid))
+	assert.Equal(t, -penaltyBadBlock, peer.score)
+}
+
+func TestRecoveredScore(t *testing.T) {
+	now := time.Now()
+	peer := &TCPPeer{score: -50, scoreUpdated: now}
+
+	assert.Equal(t, -50, recoveredScore(peer, now))
+	assert.Equal(t, -47, recoveredScore(peer, now.Add(3*scoreRecovery)))
+	assert.Equal(t, 0, recoveredScore(peer, now.Add(time.Hour)))
+}
+
+func TestPenalizeBansPeer(t *testing.T) {
+	s := newTestServer(t, ServerOpts{ID: "A"})
+
+	local, remote := net.Pipe()
+	peer := newTestPeer(1, false, "")
+	peer.conn = addrConn{Conn: local, addr: peer.conn.RemoteAddr()}
+	assert.Nil(t, s.peers.accept(peer))
+	assert.Nil(t, s.peers.connect(peer))
+
+	addr := peer.conn.RemoteAddr()
+	s.penalize(addr, penaltyBadBlock, errors.New("bad block"))
+	assert.False(t, s.bans.isBanned(peer.NodeKey, time.Now()))
+
+	s.penalize(addr, penaltyBadBlock, errors.New("bad block"))
+	assert.True(t, s.bans.isBanned(peer.NodeKey, time.Now()))
+
+	// The connection of the banned peer was closed.
+	_, err := remote.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+
+	assert.Len(t, s.Bans(), 1)
+	ok, err := s.Unban(peer.NodeKey)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Empty(t, s.Bans())
+}
+
+func TestHandshakeBannedPeer(t *testing.T) {
+	key := crypto.GeneratePrivateKey()
+	a := newTestServer(t, ServerOpts{ID: "A"})
+	assert.Nil(t, a.Ban(key.PublicKey(), 0, "manual"))
+
+	local, remote := net.Pipe()
+	go func() {
+		secureHandshake(remote, key, time.Second)
+		remote.Close()
+	}()
+
+	err := a.handshake(&TCPPeer{conn: local})
+	assert.True(t, errors.Is(err, ErrPeerBanned), err)
+}
+
+func TestMalformedFramePenalized(t *testing.T) {
+	s := newTestServer(t, ServerOpts{ID: "A"})
+	rpcCh, closeCh := make(chan RPC), make(chan *TCPPeer, 1)
+
+	peer, remote := pipePeer(t, s)
+	go peer.readLoop(rpcCh, closeCh)
+	remote.Write(make([]byte, frameHeaderSize))
+
+	s.removePeer(<-closeCh)
+	assert.True(t, errors.Is(peer.readErr, ErrInvalidFrame), peer.readErr)
+	assert.Equal(t, -penaltyMalformed, peer.score)
+	assert.Empty(t, s.peers.peers())
+
+	// Closed connections are not penalized.
+	peer, remote = pipePeer(t, s)
+	go peer.readLoop(rpcCh, closeCh)
+	remote.Close()
+
+	s.removePeer(<-closeCh)
+	assert.Nil(t, peer.readErr)
+	assert.Equal(t, 0, peer.score)
+}
@@ -21,6 +21,7 @@ const (
 	MessageTypeStatus    MessageType = 0x4
 	MessageTypeGetStatus MessageType = 0x5
 	MessageTypeBlocks    MessageType = 0x6
+	MessageTypeHandshake MessageType = 0x7
 )
 
 type RPC struct {
@@ -40,10 +41,25 @@ func NewMessage(t MessageType, data []byte) *Message {
 	}
 }
 
+// Bytes returns the message type followed by the message data.
 func (msg *Message) Bytes() []byte {
-	buf := &bytes.Buffer{}
-	gob.NewEncoder(buf).Encode(msg)
-	return buf.Bytes()
+	b := make([]byte, 1+len(msg.Data))
+	b[0] = byte(msg.Header)
+	copy(b[1:], msg.Data)
+
+	return b
+}
+
+func DecodeMessage(r io.Reader) (*Message, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, fmt.Errorf("empty message")
+	}
+
+	return NewMessage(MessageType(b[0]), b[1:]), nil
 }
 
 type DecodedMessage struct {
@@ -54,8 +70,8 @@ type DecodedMessage struct {
 type RPCDecodeFunc func(RPC) (*DecodedMessage, error)
 
 func DefaultRPCDecodeFunc(rpc RPC) (*DecodedMessage, error) {
-	msg := Message{}
-	if err := gob.NewDecoder(rpc.Payload).Decode(&msg); err != nil {
+	msg, err := DecodeMessage(rpc.Payload)
+	if err != nil {
 		return nil, fmt.Errorf("failed to decode message from %s:%s", rpc.From, err)
 	}
 
@@ -67,7 +83,7 @@ func DefaultRPCDecodeFunc(rpc RPC) (*DecodedMessage, error) {
 	switch msg.Header {
 	case MessageTypeTx:
 		tx := new(core.Transaction)
-		if err := tx.Decode(core.NewGobTxDecoder(bytes.NewReader(msg.Data))); err != nil {
+		if err := tx.Decode(core.NewBinaryTxDecoder(bytes.NewReader(msg.Data))); err != nil {
 			return nil, err
 		}
 		return &DecodedMessage{
@@ -77,7 +93,7 @@ func DefaultRPCDecodeFunc(rpc RPC) (*DecodedMessage, error) {
 
 	case MessageTypeBlock:
 		block := new(core.Block)
-		if err := block.Decode(core.NewGobBlockDecoder(bytes.NewReader(msg.Data))); err != nil {
+		if err := block.Decode(core.NewBinaryBlockDecoder(bytes.NewReader(msg.Data))); err != nil {
 			return nil, err
 		}
 
@@ -116,7 +132,7 @@ func DefaultRPCDecodeFunc(rpc RPC) (*DecodedMessage, error) {
 
 	case MessageTypeBlocks:
 		blocks := new(BlocksMessage)
-		if err := gob.NewDecoder(bytes.NewReader(msg.Data)).Decode(blocks); err != nil {
+		if err := blocks.Decode(bytes.NewReader(msg.Data)); err != nil {
 			return nil, err
 		}
 
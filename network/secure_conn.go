@@ -0,0 +1,229 @@
+package network
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/k0yote/privatechain/crypto"
+)
+
+// Connections between nodes are encrypted and authenticated before anything
+// else is sent. Both sides send a hello with their node key and an
+// ephemeral P-256 key, derive one AES-256-GCM key per direction from the
+// ECDH secret of the ephemeral keys and then prove ownership of their node
+// key by signing both hellos. Everything after the hellos, including the
+// signatures, is sent in records of a 4 byte big endian length followed by
+// the sealed data, with a counter as nonce.
+const (
+	secureHelloSize = 33 + 65
+	// maxRecordSize is the largest plaintext sealed in a single record.
+	maxRecordSize = 64 << 10
+)
+
+var ErrAuthFailed = errors.New("peer authentication failed")
+
+// secureConn is a net.Conn that encrypts everything written to it.
+type secureConn struct {
+	net.Conn
+
+	sendLock  sync.Mutex
+	send      cipher.AEAD
+	sendNonce uint64
+
+	recv      cipher.AEAD
+	recvNonce uint64
+	// pending holds decrypted data not read yet.
+	pending []byte
+}
+
+func newAEAD(secret, ephemeral []byte) (cipher.AEAD, error) {
+	h := sha256.New()
+	h.Write([]byte("k0yote-secure-conn"))
+	h.Write(secret)
+	h.Write(ephemeral)
+
+	block, err := aes.NewCipher(h.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func recordNonce(n uint64) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], n)
+
+	return nonce
+}
+
+func (c *secureConn) Write(b []byte) (int, error) {
+	c.sendLock.Lock()
+	defer c.sendLock.Unlock()
+
+	written := 0
+	for len(b) > 0 {
+		n := len(b)
+		if n > maxRecordSize {
+			n = maxRecordSize
+		}
+
+		record := make([]byte, 4, 4+n+c.send.Overhead())
+		record = c.send.Seal(record, recordNonce(c.sendNonce), b[:n], nil)
+		binary.BigEndian.PutUint32(record, uint32(len(record)-4))
+		c.sendNonce++
+
+		if _, err := c.Conn.Write(record); err != nil {
+			return written, err
+		}
+
+		written += n
+		b = b[n:]
+	}
+
+	return written, nil
+}
+
+func (c *secureConn) Read(b []byte) (int, error) {
+	if len(c.pending) == 0 {
+		if err := c.readRecord(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+
+	return n, nil
+}
+
+func (c *secureConn) readRecord() error {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(c.Conn, header); err != nil {
+		return err
+	}
+
+	length := binary.BigEndian.Uint32(header)
+	if length > uint32(maxRecordSize+c.recv.Overhead()) {
+		return fmt.Errorf("%w: record of %d bytes", ErrMessageTooLarge, length)
+	}
+
+	sealed := make([]byte, length)
+	if _, err := io.ReadFull(c.Conn, sealed); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	data, err := c.recv.Open(sealed[:0], recordNonce(c.recvNonce), sealed, nil)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrAuthFailed, err)
+	}
+	c.recvNonce++
+	c.pending = data
+
+	return nil
+}
+
+// exchange writes out while reading len(in) bytes from conn.
+func exchange(conn io.ReadWriter, out, in []byte) error {
+	sendErr := make(chan error, 1)
+	go func() {
+		_, err := conn.Write(out)
+		sendErr <- err
+	}()
+
+	if _, err := io.ReadFull(conn, in); err != nil {
+		return err
+	}
+
+	return <-sendErr
+}
+
+func authDigest(signer, other []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte("k0yote-auth"))
+	h.Write(signer)
+	h.Write(other)
+
+	return h.Sum(nil)
+}
+
+// secureHandshake runs the key exchange over conn and returns the encrypted
+// connection along with the authenticated node key of the other side.
+func secureHandshake(conn net.Conn, nodeKey crypto.PrivateKey, timeout time.Duration) (*secureConn, crypto.PublicKey, error) {
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, nil, err
+	}
+	defer conn.SetDeadline(time.Time{})
+
+	ephemeral, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ours := append(append([]byte{}, nodeKey.PublicKey()...), ephemeral.PublicKey().Bytes()...)
+	theirs := make([]byte, secureHelloSize)
+	if err := exchange(conn, ours, theirs); err != nil {
+		return nil, nil, fmt.Errorf("failed to exchange hello: %w", err)
+	}
+
+	peerKey := crypto.PublicKey(theirs[:33])
+	if x, _ := elliptic.UnmarshalCompressed(elliptic.P256(), peerKey); x == nil {
+		return nil, nil, fmt.Errorf("%w: invalid node key", ErrAuthFailed)
+	}
+	if bytes.Equal(peerKey, nodeKey.PublicKey()) {
+		return nil, nil, fmt.Errorf("%w: connected to ourselves", ErrAuthFailed)
+	}
+
+	peerEphemeral, err := ecdh.P256().NewPublicKey(theirs[33:])
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %s", ErrAuthFailed, err)
+	}
+
+	secret, err := ephemeral.ECDH(peerEphemeral)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %s", ErrAuthFailed, err)
+	}
+
+	sc := &secureConn{Conn: conn}
+	if sc.send, err = newAEAD(secret, ours[33:]); err != nil {
+		return nil, nil, err
+	}
+	if sc.recv, err = newAEAD(secret, theirs[33:]); err != nil {
+		return nil, nil, err
+	}
+
+	sig, err := nodeKey.Sign(authDigest(ours, theirs))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	peerSig := make([]byte, 64)
+	if err := exchange(sc, sig.Bytes(), peerSig); err != nil {
+		return nil, nil, fmt.Errorf("failed to exchange signature: %w", err)
+	}
+
+	decoded, err := crypto.SignatureFromBytes(peerSig)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !decoded.Verify(peerKey, authDigest(theirs, ours)) {
+		return nil, nil, fmt.Errorf("%w: invalid signature", ErrAuthFailed)
+	}
+
+	return sc, peerKey, nil
+}
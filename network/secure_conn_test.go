@@ -0,0 +1,91 @@
+package network
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/k0yote/privatechain/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecureConn(t *testing.T) {
+	keyA, keyB := crypto.GeneratePrivateKey(), crypto.GeneratePrivateKey()
+	connA, connB := net.Pipe()
+
+	type result struct {
+		conn    net.Conn
+		peerKey crypto.PublicKey
+	}
+	resCh := make(chan result, 1)
+	go func() {
+		sc, peerKey, err := secureHandshake(connB, keyB, time.Second)
+		assert.Nil(t, err)
+		resCh <- result{sc, peerKey}
+	}()
+
+	a, peerKey, err := secureHandshake(connA, keyA, time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, keyB.PublicKey(), peerKey)
+
+	b := <-resCh
+	assert.Equal(t, keyA.PublicKey(), b.peerKey)
+
+	// Larger than a single record.
+	data := bytes.Repeat([]byte("k0yote"), maxRecordSize/2)
+	go a.Write(data)
+
+	received := make([]byte, len(data))
+	_, err = io.ReadFull(b.conn, received)
+	assert.Nil(t, err)
+	assert.Equal(t, data, received)
+}
+
+func TestSecureConnTampered(t *testing.T) {
+	connA, connB := net.Pipe()
+	go secureRemote(t, connB)
+
+	sc, _, err := secureHandshake(connA, crypto.GeneratePrivateKey(), time.Second)
+	assert.Nil(t, err)
+
+	// A record sealed with the key of the other direction is rejected.
+	sealed := sc.send.Seal([]byte{0, 0, 0, 17}, recordNonce(0), []byte{1}, nil)
+	go connB.Write(sealed)
+
+	_, err = sc.Read(make([]byte, 1))
+	assert.True(t, errors.Is(err, ErrAuthFailed), err)
+}
+
+func TestSecureHandshakeImpersonation(t *testing.T) {
+	victim, attacker := crypto.GeneratePrivateKey(), crypto.GeneratePrivateKey()
+	connA, connB := net.Pipe()
+
+	// The attacker claims the node key of the victim but can only sign with
+	// its own key.
+	go func() {
+		ephemeral, _ := ecdh.P256().GenerateKey(rand.Reader)
+		hello := append(append([]byte{}, victim.PublicKey()...), ephemeral.PublicKey().Bytes()...)
+
+		theirs := make([]byte, secureHelloSize)
+		if exchange(connB, hello, theirs) != nil {
+			return
+		}
+
+		peerEphemeral, _ := ecdh.P256().NewPublicKey(theirs[33:])
+		secret, _ := ephemeral.ECDH(peerEphemeral)
+		sc := &secureConn{Conn: connB}
+		sc.send, _ = newAEAD(secret, hello[33:])
+		sc.recv, _ = newAEAD(secret, theirs[33:])
+
+		sig, _ := attacker.Sign(authDigest(hello, theirs))
+		exchange(sc, sig.Bytes(), make([]byte, 64))
+	}()
+
+	_, _, err := secureHandshake(connA, crypto.GeneratePrivateKey(), time.Second)
+	assert.True(t, errors.Is(err, ErrAuthFailed), err)
+}
@@ -3,10 +3,11 @@ package network
 import (
 	"bytes"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"net"
 	"os"
-	"sync"
+	"path/filepath"
 	"time"
 
 	"github.com/go-kit/log"
@@ -18,6 +19,9 @@ import (
 
 var defaultBlockTime = 5 * time.Second
 
+// DefaultChainID is used when ServerOpts.ChainID is not set.
+const DefaultChainID uint64 = 1
+
 type ServerOpts struct {
 	APIListenAddr string
 	SeedNodes     []string
@@ -29,14 +33,47 @@ type ServerOpts struct {
 	RPCProcessor  RPCProcessor
 	BlockTime     time.Duration
 	PrivateKey    *crypto.PrivateKey
+	// NodeKey authenticates the node to its peers. It is stored in DataDir
+	// and generated on first use when nil.
+	NodeKey *crypto.PrivateKey
+	// DataDir is where the blockchain is persisted. When empty the chain
+	// is kept in memory only.
+	DataDir string
+	// ChainID identifies the chain in the genesis block. Blocks and
+	// transactions of other chains are rejected.
+	ChainID uint64
+	// PersistentPeers are dialed like SeedNodes. Both are redialed whenever
+	// the connection is lost and are host:port addresses, optionally
+	// prefixed with the hex node key the peer has to authenticate with, as
+	// in nodekey@host:port.
+	PersistentPeers []string
+	// MaxInboundPeers and MaxOutboundPeers limit the number of connections.
+	// DefaultMaxInboundPeers and DefaultMaxOutboundPeers are used when zero.
+	MaxInboundPeers  int
+	MaxOutboundPeers int
+	// BanDuration is how long peers are banned for misbehaving.
+	// DefaultBanDuration is used when zero. The ban list is stored in
+	// DataDir.
+	BanDuration time.Duration
+	// Capabilities are offered to peers in the handshake. All capabilities
+	// are offered when nil.
+	Capabilities []string
+	// APIAdminToken enables the admin routes of the JSON API, like banning
+	// peers, for requests that carry it as bearer token.
+	APIAdminToken string
+	// DebugAPI serves the debug routes of the JSON API and keeps the state
+	// snapshots they need. It is off by default.
+	DebugAPI bool
 	// Blockchain    *core.Blockchain
 }
 
 type Server struct {
 	TCPTransport *TCPTransport
 	peerCh       chan *TCPPeer
-	mu           sync.RWMutex
-	peerMap      map[net.Addr]*TCPPeer
+	addPeerCh    chan *TCPPeer
+	delPeerCh    chan *TCPPeer
+	peers        *peerManager
+	bans         *banList
 	ServerOpts
 	mempool     *TxPool
 	chain       *core.Blockchain
@@ -50,6 +87,21 @@ func NewServer(opts ServerOpts) (*Server, error) {
 	if opts.BlockTime == time.Duration(0) {
 		opts.BlockTime = defaultBlockTime
 	}
+	if opts.ChainID == 0 {
+		opts.ChainID = DefaultChainID
+	}
+	if opts.MaxInboundPeers == 0 {
+		opts.MaxInboundPeers = DefaultMaxInboundPeers
+	}
+	if opts.MaxOutboundPeers == 0 {
+		opts.MaxOutboundPeers = DefaultMaxOutboundPeers
+	}
+	if opts.BanDuration == 0 {
+		opts.BanDuration = DefaultBanDuration
+	}
+	if opts.Capabilities == nil {
+		opts.Capabilities = defaultCapabilities
+	}
 	if opts.RPCDecodeFunc == nil {
 		opts.RPCDecodeFunc = DefaultRPCDecodeFunc
 	}
@@ -58,24 +110,57 @@ func NewServer(opts ServerOpts) (*Server, error) {
 		opts.Logger = log.With(opts.Logger, "addr", opts.ID)
 	}
 
-	chain, err := core.NewBlockchain(opts.Logger, genesisBlock())
+	targets := []dialTarget{}
+	for _, addrs := range [][]string{opts.SeedNodes, opts.PersistentPeers} {
+		for _, addr := range addrs {
+			addr, nodeKey, err := parsePeerAddr(addr)
+			if err != nil {
+				return nil, err
+			}
+			targets = append(targets, dialTarget{
+				addr:    addr,
+				nodeKey: nodeKey,
+			})
+		}
+	}
+
+	var (
+		chain *core.Blockchain
+		err   error
+	)
+	if len(opts.DataDir) > 0 {
+		chain, err = core.OpenBlockchain(opts.Logger, opts.DataDir, genesisBlock(opts.ChainID))
+	} else {
+		chain, err = core.NewBlockchain(opts.Logger, genesisBlock(opts.ChainID))
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	txChan := make(chan *core.Transaction)
+	if chain.ChainID() != opts.ChainID {
+		return nil, fmt.Errorf("%w: stored chain has chain id (%d) => configured (%d)", core.ErrInvalidChainID, chain.ChainID(), opts.ChainID)
+	}
 
-	if len(opts.APIListenAddr) > 0 {
-		apiServerCfg := api.ServerConfig{
-			Logger:     opts.Logger,
-			ListenAddr: opts.APIListenAddr,
+	if opts.NodeKey == nil {
+		key := crypto.GeneratePrivateKey()
+		if len(opts.DataDir) > 0 {
+			if key, err = crypto.LoadOrCreatePrivateKey(filepath.Join(opts.DataDir, "nodekey")); err != nil {
+				return nil, err
+			}
 		}
-		apiServer := api.NewServer(apiServerCfg, chain, txChan)
-
-		go apiServer.Start()
+		opts.NodeKey = &key
+	}
 
-		opts.Logger.Log("msg", "JSON API server running on", "port", opts.APIListenAddr)
+	banPath := ""
+	if len(opts.DataDir) > 0 {
+		banPath = filepath.Join(opts.DataDir, "bans.json")
 	}
+	bans, err := newBanList(banPath)
+	if err != nil {
+		return nil, err
+	}
+
+	txChan := make(chan *core.Transaction)
 
 	peerCh := make(chan *TCPPeer)
 	tr := NewTCPTransport(opts.ListenAddr, peerCh)
@@ -83,7 +168,10 @@ func NewServer(opts ServerOpts) (*Server, error) {
 	s := &Server{
 		TCPTransport: tr,
 		peerCh:       peerCh,
-		peerMap:      make(map[net.Addr]*TCPPeer),
+		addPeerCh:    make(chan *TCPPeer),
+		delPeerCh:    make(chan *TCPPeer),
+		peers:        newPeerManager(opts.MaxInboundPeers, opts.MaxOutboundPeers),
+		bans:         bans,
 		ServerOpts:   opts,
 		mempool:      NewTxPool(1000),
 		chain:        chain,
@@ -94,11 +182,34 @@ func NewServer(opts ServerOpts) (*Server, error) {
 	}
 
 	s.TCPTransport.peerCh = peerCh
+	for _, target := range targets {
+		s.peers.addTarget(target.addr, target.nodeKey)
+	}
+	s.mempool.SetAccountReader(chain)
 
 	if s.RPCProcessor == nil {
 		s.RPCProcessor = s
 	}
 
+	if len(opts.APIListenAddr) > 0 {
+		apiServerCfg := api.ServerConfig{
+			Logger:     opts.Logger,
+			ListenAddr: opts.APIListenAddr,
+			Network:    s,
+			Mempool:    s.mempool,
+			AdminToken: opts.APIAdminToken,
+			Debug:      opts.DebugAPI,
+		}
+		if opts.DebugAPI {
+			chain.EnableTracing()
+		}
+		apiServer := api.NewServer(apiServerCfg, chain, txChan)
+
+		go apiServer.Start()
+
+		opts.Logger.Log("msg", "JSON API server running on", "port", opts.APIListenAddr)
+	}
+
 	if s.isValidator {
 		go s.validatorLoop()
 	}
@@ -106,20 +217,32 @@ func NewServer(opts ServerOpts) (*Server, error) {
 	return s, nil
 }
 
-func (s *Server) bootstrapNetwork() {
-	for _, addr := range s.SeedNodes {
-		fmt.Println("trying to connect to ", addr)
-		go func(addr string) {
-			conn, err := net.Dial("tcp", addr)
-			if err != nil {
-				fmt.Printf("could not connect to %+v [err: %s]\n", conn, err)
-				return
-			}
+// dialLoop dials the seed nodes and persistent peers that are due.
+func (s *Server) dialLoop() {
+	ticker := time.NewTicker(dialInterval)
+	defer ticker.Stop()
 
-			s.peerCh <- &TCPPeer{
-				conn: conn,
-			}
-		}(addr)
+	for {
+		for _, addr := range s.peers.due(time.Now()) {
+			go s.dial(addr)
+		}
+
+		<-ticker.C
+	}
+}
+
+func (s *Server) dial(addr string) {
+	conn, err := net.DialTimeout("tcp", addr, dialTimeout)
+	if err != nil {
+		s.Logger.Log("msg", "could not connect to peer", "addr", addr, "err", err)
+		s.peers.dialFailed(addr)
+		return
+	}
+
+	s.peerCh <- &TCPPeer{
+		conn:     conn,
+		Outgoing: true,
+		dialAddr: addr,
 	}
 }
 
@@ -128,7 +251,7 @@ func (s *Server) Start() {
 
 	time.Sleep(1 * time.Second)
 
-	s.bootstrapNetwork()
+	go s.dialLoop()
 
 	s.Logger.Log("msg", "accepting TCP connection on", "addr", s.ListenAddr, "id", s.ID)
 
@@ -136,16 +259,32 @@ free:
 	for {
 		select {
 		case peer := <-s.peerCh:
-			s.peerMap[peer.conn.RemoteAddr()] = peer
+			if err := s.peers.accept(peer); err != nil {
+				s.Logger.Log("msg", "peer refused", "addr", peer.conn.RemoteAddr(), "err", err)
+				peer.conn.Close()
+				continue
+			}
 
-			go peer.readLoop(s.rpcCh)
+			go s.handshakePeer(peer)
+
+		case peer := <-s.addPeerCh:
+			if err := s.peers.connect(peer); err != nil {
+				s.Logger.Log("msg", "peer refused", "addr", peer.conn.RemoteAddr(), "err", err)
+				peer.conn.Close()
+				continue
+			}
+
+			go peer.readLoop(s.rpcCh, s.delPeerCh)
 
 			if err := s.sendGetStatusMessage(peer); err != nil {
 				s.Logger.Log("err", err)
 				continue
 			}
 
-			s.Logger.Log("msg", "peer added to the server", "outgoing", peer.Outgoing, "addr", peer.conn.RemoteAddr())
+			s.Logger.Log("msg", "peer added to the server", "outgoing", peer.Outgoing, "addr", peer.conn.RemoteAddr(), "id", peer.ID, "nodeKey", peer.NodeKey, "version", peer.ProtocolVersion)
+
+		case peer := <-s.delPeerCh:
+			s.removePeer(peer)
 
 		case tx := <-s.txChan:
 			if err := s.processTransaction(tx); err != nil {
@@ -153,17 +292,7 @@ free:
 			}
 
 		case rpc := <-s.rpcCh:
-			msg, err := s.RPCDecodeFunc(rpc)
-			if err != nil {
-				s.Logger.Log("RPC error", err)
-				continue
-			}
-
-			if err := s.RPCProcessor.ProcessMessage(msg); err != nil {
-				if err != core.ErrBlockKnown {
-					s.Logger.Log("error", err)
-				}
-			}
+			s.handleRPC(rpc)
 
 		case <-s.quitCh:
 			break free
@@ -173,13 +302,123 @@ free:
 	s.Logger.Log("msg", "Server is shutting down")
 }
 
+// handleRPC decodes and processes a message of a peer and penalizes the
+// peer for spam and for messages that prove it misbehaving.
+func (s *Server) handleRPC(rpc RPC) {
+	if s.peers.countMessage(rpc.From, time.Now()) {
+		s.penalize(rpc.From, penaltySpam, fmt.Errorf("more than %d messages per second", maxMessagesPerSecond))
+		return
+	}
+
+	msg, err := s.RPCDecodeFunc(rpc)
+	if err != nil {
+		s.Logger.Log("RPC error", err)
+		s.penalize(rpc.From, penaltyMalformed, err)
+		return
+	}
+
+	if err := s.RPCProcessor.ProcessMessage(msg); err != nil {
+		if err != core.ErrBlockKnown {
+			s.Logger.Log("error", err)
+		}
+		s.penalize(msg.From, penaltyFor(err), err)
+	}
+}
+
+// handshakePeer adds a new peer to the server once its handshake has been
+// accepted and drops it otherwise.
+func (s *Server) handshakePeer(peer *TCPPeer) {
+	if err := s.handshake(peer); err != nil {
+		s.Logger.Log("msg", "peer rejected", "addr", peer.conn.RemoteAddr(), "err", err)
+		peer.conn.Close()
+		s.delPeerCh <- peer
+		return
+	}
+
+	s.addPeerCh <- peer
+}
+
+func (s *Server) getPeer(addr net.Addr) (*TCPPeer, error) {
+	peer, ok := s.peers.get(addr)
+	if !ok {
+		return nil, fmt.Errorf("peer %s not known", addr)
+	}
+
+	return peer, nil
+}
+
+// Peers returns the connected peers along with the ones handshaking and the
+// addresses waiting to be dialed.
+func (s *Server) Peers() []api.PeerInfo {
+	infos := s.peers.info()
+
+	peers := make([]api.PeerInfo, len(infos))
+	for i, info := range infos {
+		peers[i] = api.PeerInfo{
+			Addr:     info.Addr,
+			State:    info.State.String(),
+			Outgoing: info.Outgoing,
+			Attempts: info.Attempts,
+		}
+		if nextDial := info.NextDial; !nextDial.IsZero() {
+			peers[i].NextDial = &nextDial
+		}
+		if info.Peer != nil {
+			peers[i].ID = info.Peer.ID
+			peers[i].NodeKey = info.Peer.NodeKey.String()
+			peers[i].ProtocolVersion = info.Peer.ProtocolVersion
+			peers[i].Capabilities = info.Peer.Capabilities
+			peers[i].Height = info.Peer.Height
+			connectedAt := info.ConnectedAt
+			peers[i].ConnectedAt = &connectedAt
+			peers[i].Score = info.Score
+		}
+	}
+
+	return peers
+}
+
+// Bans returns the nodes that are banned.
+func (s *Server) Bans() []api.BanInfo {
+	bans := s.bans.list(time.Now())
+
+	infos := make([]api.BanInfo, len(bans))
+	for i, ban := range bans {
+		infos[i] = api.BanInfo{
+			NodeKey: ban.NodeKey,
+			Reason:  ban.Reason,
+		}
+		if until := ban.Until; !until.IsZero() {
+			infos[i].Until = &until
+		}
+	}
+
+	return infos
+}
+
+// removePeer forgets a peer whose connection was closed or that failed its
+// handshake. Peers that were disconnected for sending a malformed frame are
+// penalized first.
+func (s *Server) removePeer(peer *TCPPeer) {
+	if err := peer.readErr; err != nil {
+		s.Logger.Log("msg", "read error", "addr", peer.conn.RemoteAddr(), "err", err)
+		if errors.Is(err, ErrInvalidFrame) || errors.Is(err, ErrMessageTooLarge) {
+			s.penalize(peer.conn.RemoteAddr(), penaltyMalformed, err)
+		}
+	}
+
+	s.peers.remove(peer)
+
+	s.Logger.Log("msg", "peer removed from the server", "addr", peer.conn.RemoteAddr())
+}
+
 func (s *Server) validatorLoop() {
 	ticker := time.NewTicker(s.BlockTime)
 
 	s.Logger.Log("msg", "Starting validator loop", "BlockTime", s.BlockTime)
 
 	for {
-		fmt.Println("creating new block")
+		s.Logger.Log("msg", "creating new block")
 
 		if err := s.createNewBlock(); err != nil {
 			s.Logger.Log("create block error", err)
@@ -207,23 +446,37 @@ func (s *Server) ProcessMessage(msg *DecodedMessage) error {
 	return nil
 }
 
+// processGetBlocksMessage answers with a single page of the requested
+// blocks, at most maxBlocksPerPage blocks and maxPageSize bytes. The peer
+// requests the blocks after the page once it added them.
 func (s *Server) processGetBlocksMessage(from net.Addr, data *GetBlocksMessage) error {
 	s.Logger.Log("msg", "received getBlocks message", "from", from)
 
 	var (
-		blocks    = []*core.Block{}
-		ourHeight = s.chain.Height()
+		blocks = []*core.Block{}
+		last   = s.chain.Height()
+		size   = 0
 	)
+	if data.To != 0 && data.To < last {
+		last = data.To
+	}
 
-	if data.To == 0 {
-		for i := int(data.From); i <= int(ourHeight); i++ {
-			block, err := s.chain.GetBlock(uint32(i))
-			if err != nil {
-				return err
-			}
+	for h := data.From; h <= last && len(blocks) < maxBlocksPerPage; h++ {
+		block, err := s.chain.GetBlock(h)
+		if err != nil {
+			return err
+		}
 
-			blocks = append(blocks, block)
+		b, err := core.MarshalBlock(block)
+		if err != nil {
+			return err
+		}
+		// A block larger than a page is sent on its own.
+		if size += len(b); size > maxPageSize && len(blocks) > 0 {
+			break
 		}
+
+		blocks = append(blocks, block)
 	}
 
 	blocksMsg := &BlocksMessage{
@@ -231,18 +484,16 @@ func (s *Server) processGetBlocksMessage(from net.Addr, data *GetBlocksMessage)
 	}
 
 	buf := new(bytes.Buffer)
-	if err := gob.NewEncoder(buf).Encode(blocksMsg); err != nil {
+	if err := blocksMsg.Encode(buf); err != nil {
 		return err
 	}
 
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	peer, err := s.getPeer(from)
+	if err != nil {
+		return err
+	}
 
 	msg := NewMessage(MessageTypeBlocks, buf.Bytes())
-	peer, ok := s.peerMap[from]
-	if !ok {
-		return fmt.Errorf("peer %s not known", peer.conn.RemoteAddr())
-	}
 
 	return peer.Send(msg.Bytes())
 }
@@ -260,34 +511,60 @@ func (s *Server) sendGetStatusMessage(peer *TCPPeer) error {
 	return peer.Send(msg.Bytes())
 }
 
-func (s *Server) broadcast(payload []byte) error {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	for netAddr, peer := range s.peerMap {
+// broadcast sends payload to the peers that negotiated the capability.
+func (s *Server) broadcast(capability string, payload []byte) error {
+	for _, peer := range s.peers.peers() {
+		if !peer.HasCapability(capability) {
+			continue
+		}
 		if err := peer.Send(payload); err != nil {
-			fmt.Printf("peer send error => addr %s [err: %s]\n", netAddr, err)
+			s.Logger.Log("msg", "peer send error", "addr", peer.conn.RemoteAddr(), "err", err)
 		}
 	}
 
 	return nil
 }
 
+// processBlocksMessage adds a page of blocks and requests the next page
+// right away. Blocks we added in the meantime are skipped.
 func (s *Server) processBlocksMessage(from net.Addr, data *BlocksMessage) error {
 	s.Logger.Log("msg", "received BLOCKS!!!!!!!!", "from", from)
 
 	for _, block := range data.Blocks {
-		if err := s.chain.AddBlock(block); err != nil {
+		if err := s.chain.AddBlock(block); err != nil && err != core.ErrBlockKnown {
 			s.Logger.Log("error", err.Error())
 			return err
 		}
 	}
 
-	return nil
+	s.mempool.PruneStale()
+
+	if len(data.Blocks) == 0 {
+		return nil
+	}
+
+	peer, err := s.getPeer(from)
+	if err != nil {
+		return err
+	}
+
+	return s.requestBlocks(peer)
 }
 
 func (s *Server) processStatusMessage(from net.Addr, data *StatusMessage) error {
 	s.Logger.Log("msg", "received STATUS message", "from", from)
 
+	peer, err := s.getPeer(from)
+	if err != nil {
+		return err
+	}
+	if data.Version != peer.ProtocolVersion {
+		return fmt.Errorf("%w: status of version (%d) => negotiated (%d)", ErrIncompatiblePeer, data.Version, peer.ProtocolVersion)
+	}
+	if !peer.HasCapability(CapabilityBlocks) {
+		return nil
+	}
+
 	if data.CurrentHeight <= s.chain.Height() {
 		s.Logger.Log("msg", "cannot sync blockHeight to low", "ourHeight", s.chain.Height(), "theirHeight", data.CurrentHeight, "addr", from)
 		return nil
@@ -301,9 +578,15 @@ func (s *Server) processStatusMessage(from net.Addr, data *StatusMessage) error
 func (s *Server) processGetStatusMessage(from net.Addr, data *GetStatusMessage) error {
 	s.Logger.Log("msg", "received getStatus message", "from", from)
 
+	peer, err := s.getPeer(from)
+	if err != nil {
+		return err
+	}
+
 	statusMessage := &StatusMessage{
 		CurrentHeight: s.chain.Height(),
 		ID:            s.ID,
+		Version:       peer.ProtocolVersion,
 	}
 
 	buf := new(bytes.Buffer)
@@ -311,14 +594,6 @@ func (s *Server) processGetStatusMessage(from net.Addr, data *GetStatusMessage)
 		return err
 	}
 
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	peer, ok := s.peerMap[from]
-	if !ok {
-		return fmt.Errorf("peer %s not known", peer.conn.RemoteAddr())
-	}
-
 	msg := NewMessage(MessageTypeStatus, buf.Bytes())
 
 	return peer.Send(msg.Bytes())
@@ -330,6 +605,8 @@ func (s *Server) processBlock(b *core.Block) error {
 		return err
 	}
 
+	s.mempool.PruneStale()
+
 	go s.broadcastBlock(b)
 
 	return nil
@@ -342,10 +619,18 @@ func (s *Server) processTransaction(tx *core.Transaction) error {
 		return nil
 	}
 
+	if tx.ChainID != s.ChainID {
+		return fmt.Errorf("%w: transaction (%s) with chain id (%d)", core.ErrInvalidChainID, hash, tx.ChainID)
+	}
+
 	if err := tx.Verify(); err != nil {
 		return err
 	}
 
+	if err := s.mempool.Add(tx); err != nil {
+		return err
+	}
+
 	// s.Logger.Log(
 	// 	"msg", "adding new tx to mempool",
 	// 	"hash", hash,
@@ -354,40 +639,21 @@ func (s *Server) processTransaction(tx *core.Transaction) error {
 
 	go s.broadcastTx(tx)
 
-	s.mempool.Add(tx)
-
 	return nil
 }
 
-func (s *Server) requestBlocksLoop(peer net.Addr) error {
+// requestBlocksLoop requests blocks from the peer until it is removed.
+func (s *Server) requestBlocksLoop(addr net.Addr) error {
 	ticker := time.NewTicker(3 * time.Second)
+	defer ticker.Stop()
 
 	for {
-		ourHeight := s.chain.Height()
-
-		s.Logger.Log("msg", "requesting new blocks", "requesting height", ourHeight+1)
-
-		// In this case we are 100% sure that the node has blocks heigher than us.
-		getBlocksMessage := &GetBlocksMessage{
-			From: ourHeight + 1,
-			To:   0,
-		}
-
-		buf := new(bytes.Buffer)
-		if err := gob.NewEncoder(buf).Encode(getBlocksMessage); err != nil {
+		peer, err := s.getPeer(addr)
+		if err != nil {
 			return err
 		}
 
-		s.mu.RLock()
-		defer s.mu.RUnlock()
-
-		msg := NewMessage(MessageTypeGetBlocks, buf.Bytes())
-		peer, ok := s.peerMap[peer]
-		if !ok {
-			return fmt.Errorf("peer %s not known", peer.conn.RemoteAddr())
-		}
-
-		if err := peer.Send(msg.Bytes()); err != nil {
+		if err := s.requestBlocks(peer); err != nil {
 			s.Logger.Log("error", "failed to send to peer", "err", err, "peer", peer)
 		}
 
@@ -395,26 +661,48 @@ func (s *Server) requestBlocksLoop(peer net.Addr) error {
 	}
 }
 
+// requestBlocks requests the blocks after our height from the peer. The
+// peer answers with a single page of them.
+func (s *Server) requestBlocks(peer *TCPPeer) error {
+	ourHeight := s.chain.Height()
+
+	s.Logger.Log("msg", "requesting new blocks", "requesting height", ourHeight+1)
+
+	getBlocksMessage := &GetBlocksMessage{
+		From: ourHeight + 1,
+		To:   0,
+	}
+
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(getBlocksMessage); err != nil {
+		return err
+	}
+
+	msg := NewMessage(MessageTypeGetBlocks, buf.Bytes())
+
+	return peer.Send(msg.Bytes())
+}
+
 func (s *Server) broadcastBlock(b *core.Block) error {
 	buf := &bytes.Buffer{}
-	if err := b.Encode(core.NewGobBlockEncoder(buf)); err != nil {
+	if err := b.Encode(core.NewBinaryBlockEncoder(buf)); err != nil {
 		return err
 	}
 
 	msg := NewMessage(MessageTypeBlock, buf.Bytes())
 
-	return s.broadcast(msg.Bytes())
+	return s.broadcast(CapabilityBlocks, msg.Bytes())
 }
 
 func (s *Server) broadcastTx(tx *core.Transaction) error {
 	buf := &bytes.Buffer{}
-	if err := tx.Encode(core.NewGobTxEncoder(buf)); err != nil {
+	if err := tx.Encode(core.NewBinaryTxEncoder(buf)); err != nil {
 		return err
 	}
 
 	msg := NewMessage(MessageTypeTx, buf.Bytes())
 
-	return s.broadcast(msg.Bytes())
+	return s.broadcast(CapabilityTxs, msg.Bytes())
 }
 
 func (s *Server) createNewBlock() error {
@@ -429,6 +717,12 @@ func (s *Server) createNewBlock() error {
 		return err
 	}
 
+	// Fees are credited to the validator while preparing the block.
+	block.Validator = s.PrivateKey.PublicKey()
+	if err := s.chain.PrepareBlock(block); err != nil {
+		return err
+	}
+
 	if err := block.Sign(*s.PrivateKey); err != nil {
 		return err
 	}
@@ -437,16 +731,22 @@ func (s *Server) createNewBlock() error {
 		return err
 	}
 
-	s.mempool.ClearPending()
+	// Transactions left out of the block stay queued, they may become
+	// executable in a later block.
+	for _, tx := range block.Transactions {
+		s.mempool.Remove(tx.Hash(core.TxHasher{}))
+	}
+	s.mempool.PruneStale()
 
 	go s.broadcastBlock(block)
 
 	return nil
 }
 
-func genesisBlock() *core.Block {
+func genesisBlock(chainID uint64) *core.Block {
 	header := &core.Header{
 		Version:   1,
+		ChainID:   chainID,
 		DataHash:  types.Hash{},
 		Height:    0,
 		Timestamp: 000000,
@@ -459,6 +759,7 @@ func genesisBlock() *core.Block {
 	tx.To = coinbase
 	tx.From = coinbase
 	tx.Value = 10_000_000
+	tx.ChainID = chainID
 	b.Transactions = append(b.Transactions, tx)
 
 	privKey := crypto.GeneratePrivateKey()
@@ -0,0 +1,134 @@
+package network
+
+import (
+	"bytes"
+	"encoding/gob"
+	"net"
+	"testing"
+
+	"github.com/k0yote/privatechain/core"
+	"github.com/k0yote/privatechain/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func addBlocks(t *testing.T, chain *core.Blockchain, n int) {
+	privKey := crypto.GeneratePrivateKey()
+
+	for i := 0; i < n; i++ {
+		prevHeader, err := chain.GetHeader(chain.Height())
+		assert.Nil(t, err)
+
+		b, err := core.NewBlockFromPrevHeader(prevHeader, nil)
+		assert.Nil(t, err)
+		b.Validator = privKey.PublicKey()
+		assert.Nil(t, chain.PrepareBlock(b))
+		assert.Nil(t, b.Sign(privKey))
+		assert.Nil(t, chain.AddBlock(b))
+	}
+}
+
+// pipePeer connects a new peer to s and returns the remote end of its
+// connection.
+func pipePeer(t *testing.T, s *Server) (*TCPPeer, net.Conn) {
+	local, remote := net.Pipe()
+	peer := newTestPeer(1, false, "")
+	peer.conn = addrConn{Conn: local, addr: peer.conn.RemoteAddr()}
+	assert.Nil(t, s.peers.accept(peer))
+	assert.Nil(t, s.peers.connect(peer))
+
+	return peer, remote
+}
+
+// receive runs fn and returns the message it sends to remote.
+func receive(t *testing.T, remote net.Conn, fn func() error) *Message {
+	errCh := make(chan error, 1)
+	go func() { errCh <- fn() }()
+
+	msg, err := readFrame(remote, MaxMessageSize)
+	assert.Nil(t, err)
+	assert.Nil(t, <-errCh)
+
+	return msg
+}
+
+func TestGetBlocksPaged(t *testing.T) {
+	a := newTestServer(t, ServerOpts{ID: "A"})
+	b := newTestServer(t, ServerOpts{ID: "B"})
+	addBlocks(t, a.chain, maxBlocksPerPage+5)
+
+	peerOfA, remoteA := pipePeer(t, a)
+	peerOfB, remoteB := pipePeer(t, b)
+
+	for _, expected := range []int{maxBlocksPerPage, 5, 0} {
+		// B requests the blocks after its height from A.
+		msg := receive(t, remoteB, func() error { return b.requestBlocks(peerOfB) })
+		assert.Equal(t, MessageTypeGetBlocks, msg.Header)
+		getBlocks := new(GetBlocksMessage)
+		assert.Nil(t, gob.NewDecoder(bytes.NewReader(msg.Data)).Decode(getBlocks))
+		assert.Equal(t, b.chain.Height()+1, getBlocks.From)
+
+		msg = receive(t, remoteA, func() error {
+			return a.processGetBlocksMessage(peerOfA.conn.RemoteAddr(), getBlocks)
+		})
+		blocks := new(BlocksMessage)
+		assert.Nil(t, blocks.Decode(bytes.NewReader(msg.Data)))
+		assert.Len(t, blocks.Blocks, expected)
+
+		if expected == 0 {
+			assert.Nil(t, b.processBlocksMessage(peerOfB.conn.RemoteAddr(), blocks))
+			break
+		}
+
+		// Adding a page requests the next one right away.
+		msg = receive(t, remoteB, func() error {
+			return b.processBlocksMessage(peerOfB.conn.RemoteAddr(), blocks)
+		})
+		assert.Equal(t, MessageTypeGetBlocks, msg.Header)
+	}
+
+	assert.Equal(t, a.chain.Height(), b.chain.Height())
+}
+
+// transferTx returns a transaction of privKey sending value to a new key.
+func transferTx(t *testing.T, s *Server, privKey crypto.PrivateKey, nonce, value uint64) *core.Transaction {
+	tx := &core.Transaction{
+		To:      crypto.GeneratePrivateKey().PublicKey(),
+		Value:   value,
+		Nonce:   nonce,
+		ChainID: s.ChainID,
+	}
+	gas, err := core.IntrinsicGas(tx)
+	assert.Nil(t, err)
+	tx.GasLimit = gas
+	assert.Nil(t, tx.Sign(privKey))
+
+	return tx
+}
+
+func TestCreateNewBlockKeepsLeftOutTxs(t *testing.T) {
+	s := newTestServer(t, ServerOpts{ID: "A"})
+	validator := crypto.GeneratePrivateKey()
+	s.PrivateKey = &validator
+
+	included := transferTx(t, s, crypto.GeneratePrivateKey(), 0, 0)
+	assert.Nil(t, s.mempool.Add(included))
+
+	// The sender cannot pay yet, so both transactions are left out.
+	poor := crypto.GeneratePrivateKey()
+	leftOut := []*core.Transaction{transferTx(t, s, poor, 0, 10), transferTx(t, s, poor, 1, 10)}
+	s.mempool.SetAccountReader(nil)
+	for _, tx := range leftOut {
+		assert.Nil(t, s.mempool.Add(tx))
+	}
+	s.mempool.SetAccountReader(s.chain)
+
+	assert.Nil(t, s.createNewBlock())
+
+	block, err := s.chain.GetBlock(s.chain.Height())
+	assert.Nil(t, err)
+	assert.Len(t, block.Transactions, 1)
+	assert.False(t, s.mempool.Contains(included.Hash(core.TxHasher{})))
+	for _, tx := range leftOut {
+		assert.True(t, s.mempool.Contains(tx.Hash(core.TxHasher{})))
+	}
+}
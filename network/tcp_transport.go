@@ -1,38 +1,99 @@
 package network
 
 import (
+	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"sync"
+	"time"
+
+	"github.com/k0yote/privatechain/crypto"
 )
 
 type TCPPeer struct {
 	conn     net.Conn
 	Outgoing bool
+
+	// The fields below are set by the handshake and fixed afterwards.
+	// NodeKey is the authenticated node key of the peer.
+	NodeKey         crypto.PublicKey
+	ID              string
+	ProtocolVersion uint32
+	Capabilities    []string
+	// Height is the height of the peer at the time of the handshake.
+	Height uint32
+
+	// dialAddr is the address we dialed for outgoing peers.
+	dialAddr    string
+	connectedAt time.Time
+	sendLock    sync.Mutex
+	// readErr is the error that ended the readLoop, unless the connection
+	// was simply closed.
+	readErr error
+
+	// The reputation fields are guarded by the peer manager.
+	score        int
+	scoreUpdated time.Time
+	windowStart  time.Time
+	windowCount  int
+}
+
+// HasCapability reports whether the capability was negotiated with the peer.
+func (p *TCPPeer) HasCapability(capability string) bool {
+	for _, c := range p.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+
+	return false
 }
 
+// Send writes b, a message as returned by Message.Bytes, to the peer in a
+// single frame.
 func (p *TCPPeer) Send(b []byte) error {
-	_, err := p.conn.Write(b)
+	if len(b) == 0 {
+		return fmt.Errorf("empty message")
+	}
+
+	frame, err := encodeFrame(MessageType(b[0]), b[1:])
+	if err != nil {
+		return err
+	}
+
+	p.sendLock.Lock()
+	defer p.sendLock.Unlock()
+
+	_, err = p.conn.Write(frame)
 	return err
 }
 
-func (p *TCPPeer) readLoop(rpcCh chan RPC) {
-	buf := make([]byte, 4096)
+// readLoop reads frames from the peer until the connection is closed or the
+// peer sends a malformed frame, after which the stream cannot be trusted
+// anymore. The connection is then closed and the peer sent on closeCh with
+// readErr set to the error of the malformed frame.
+func (p *TCPPeer) readLoop(rpcCh chan<- RPC, closeCh chan<- *TCPPeer) {
+	defer func() {
+		p.conn.Close()
+		closeCh <- p
+	}()
+
+	r := bufio.NewReader(p.conn)
 	for {
-		n, err := p.conn.Read(buf)
-		if err == io.EOF {
-			continue
-		}
+		msg, err := readFrame(r, MaxMessageSize)
 		if err != nil {
-			fmt.Printf("read error: %s\n", err)
-			continue
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				p.readErr = err
+			}
+			return
 		}
 
-		msg := buf[:n]
 		rpcCh <- RPC{
 			From:    p.conn.RemoteAddr(),
-			Payload: bytes.NewReader(msg),
+			Payload: bytes.NewReader(msg.Bytes()),
 		}
 	}
 }
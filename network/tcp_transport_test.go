@@ -0,0 +1,97 @@
+package network
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	large := bytes.Repeat([]byte{0xab}, 100_000)
+
+	buf := &bytes.Buffer{}
+	for _, data := range [][]byte{[]byte("foo"), {}, large} {
+		frame, err := encodeFrame(MessageTypeBlocks, data)
+		assert.Nil(t, err)
+		buf.Write(frame)
+	}
+
+	// Frames are reassembled however the stream is split.
+	r := iotest.OneByteReader(buf)
+	for _, data := range [][]byte{[]byte("foo"), {}, large} {
+		msg, err := readFrame(r, MaxMessageSize)
+		assert.Nil(t, err)
+		assert.Equal(t, MessageTypeBlocks, msg.Header)
+		assert.Equal(t, data, msg.Data)
+	}
+
+	_, err := readFrame(r, MaxMessageSize)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestReadFrameErrors(t *testing.T) {
+	frame, err := encodeFrame(MessageTypeTx, []byte("payload"))
+	assert.Nil(t, err)
+
+	corrupt := func(i int) []byte {
+		b := append([]byte{}, frame...)
+		b[i] ^= 0xff
+		return b
+	}
+
+	tests := map[string]struct {
+		frame   []byte
+		maxSize int
+		err     error
+	}{
+		"bad magic":    {corrupt(0), MaxMessageSize, ErrInvalidFrame},
+		"bad version":  {corrupt(4), MaxMessageSize, ErrInvalidFrame},
+		"bad checksum": {corrupt(len(frame) - 1), MaxMessageSize, ErrInvalidFrame},
+		"too large":    {frame, 6, ErrMessageTooLarge},
+		"truncated":    {frame[:len(frame)-1], MaxMessageSize, io.ErrUnexpectedEOF},
+		"short header": {frame[:5], MaxMessageSize, io.ErrUnexpectedEOF},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := readFrame(bytes.NewReader(tc.frame), tc.maxSize)
+			assert.True(t, errors.Is(err, tc.err), err)
+		})
+	}
+}
+
+func TestTCPPeerReadLoop(t *testing.T) {
+	local, remote := net.Pipe()
+	rpcCh := make(chan RPC)
+	closeCh := make(chan *TCPPeer, 1)
+
+	peer := &TCPPeer{conn: local}
+	go peer.readLoop(rpcCh, closeCh)
+
+	sender := &TCPPeer{conn: remote}
+	data := bytes.Repeat([]byte{0x01}, 10_000)
+	go func() {
+		assert.Nil(t, sender.Send(NewMessage(MessageTypeBlock, data).Bytes()))
+	}()
+
+	rpc := <-rpcCh
+	msg, err := DecodeMessage(rpc.Payload)
+	assert.Nil(t, err)
+	assert.Equal(t, MessageTypeBlock, msg.Header)
+	assert.Equal(t, data, msg.Data)
+
+	// The peer is handed back once the other side hangs up.
+	assert.Nil(t, remote.Close())
+	select {
+	case closed := <-closeCh:
+		assert.Equal(t, peer, closed)
+	case <-time.After(time.Second):
+		t.Fatal("peer was not closed")
+	}
+}
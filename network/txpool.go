@@ -1,18 +1,28 @@
 package network
 
 import (
+	"fmt"
+	"math"
+	"sort"
 	"sync"
 
 	"github.com/k0yote/privatechain/core"
 	"github.com/k0yote/privatechain/types"
 )
 
+// AccountReader gives the pool access to the account state of the chain.
+type AccountReader interface {
+	GetNonce(types.Address) uint64
+	GetBalance(types.Address) uint64
+}
+
 type TxPool struct {
 	all     *TxSortedMap
 	pending *TxSortedMap
 	// The maxLength of the total pool of transactions.
 	// When the pool is full we will prune the oldest transaction.
 	maxLength int
+	accounts  AccountReader
 }
 
 func NewTxPool(maxLength int) *TxPool {
@@ -23,30 +33,174 @@ func NewTxPool(maxLength int) *TxPool {
 	}
 }
 
-func (p *TxPool) Add(tx *core.Transaction) {
+func (p *TxPool) SetAccountReader(r AccountReader) {
+	p.accounts = r
+}
+
+func (p *TxPool) Add(tx *core.Transaction) error {
+	if p.all.Contains(tx.Hash(core.TxHasher{})) {
+		return nil
+	}
+
+	if p.accounts != nil {
+		from := tx.From.Address()
+		if nonce := p.accounts.GetNonce(from); tx.Nonce < nonce {
+			return fmt.Errorf("%w: transaction nonce (%d) => account (%s) nonce (%d)", core.ErrNonceTooLow, tx.Nonce, from, nonce)
+		}
+
+		gas, err := core.IntrinsicGas(tx)
+		if err != nil {
+			return err
+		}
+		if tx.GasLimit < gas {
+			return fmt.Errorf("%w: gas limit (%d) => intrinsic gas (%d)", core.ErrIntrinsicGas, tx.GasLimit, gas)
+		}
+
+		cost, err := tx.Cost()
+		if err != nil {
+			return err
+		}
+		// The transactions of the sender already queued are paid from the
+		// same balance.
+		queued := p.queuedCost(from)
+		if balance := p.accounts.GetBalance(from); balance < cost || balance-cost < queued {
+			return fmt.Errorf("%w: transaction cost (%d) and queued cost (%d) => account (%s) balance (%d)", core.ErrInsufficientBalance, cost, queued, from, balance)
+		}
+	}
+
 	// prune the oldest transaction that is sitting in the all pool
 	if p.all.Count() == p.maxLength {
 		oldest := p.all.First()
-		p.all.Remove(oldest.Hash(core.TxHasher{}))
+		p.Remove(oldest.Hash(core.TxHasher{}))
+	}
+
+	p.all.Add(tx)
+	p.pending.Add(tx)
+
+	return nil
+}
+
+// queuedCost returns the total cost of the transactions of from in the pool
+// whose nonce has not been used yet.
+func (p *TxPool) queuedCost(from types.Address) uint64 {
+	nonce := p.accounts.GetNonce(from)
+
+	p.all.lock.RLock()
+	defer p.all.lock.RUnlock()
+
+	var total uint64
+	for _, tx := range p.all.lookup {
+		if tx.Nonce < nonce || tx.From.Address() != from {
+			continue
+		}
+
+		cost, err := tx.Cost()
+		if err != nil || cost > math.MaxUint64-total {
+			return math.MaxUint64
+		}
+		total += cost
+	}
+
+	return total
+}
+
+// PendingNonce returns the nonce the next transaction of from has to use:
+// the account nonce advanced past the transactions of from that are
+// executable in the pool.
+func (p *TxPool) PendingNonce(from types.Address) uint64 {
+	var nonce uint64
+	if p.accounts != nil {
+		nonce = p.accounts.GetNonce(from)
 	}
 
-	if !p.all.Contains(tx.Hash(core.TxHasher{})) {
-		p.all.Add(tx)
-		p.pending.Add(tx)
+	p.all.lock.RLock()
+	defer p.all.lock.RUnlock()
+
+	queued := make(map[uint64]bool)
+	for _, tx := range p.all.lookup {
+		if tx.From.Address() == from {
+			queued[tx.Nonce] = true
+		}
+	}
+	for queued[nonce] {
+		nonce++
 	}
+
+	return nonce
 }
 
 func (p *TxPool) Contains(hash types.Hash) bool {
 	return p.all.Contains(hash)
 }
 
-// Pending returns a slice of transactions that are in the pending pool
+// Pending returns the executable transactions of the pending pool, ordered
+// by nonce so that transactions of the same sender can be applied in
+// sequence. A transaction is executable when the nonces of its sender
+// follow the account nonce up to it without a gap. The others stay queued
+// until the gap is closed.
 func (p *TxPool) Pending() []*core.Transaction {
-	return p.pending.txx.Data
+	p.pending.lock.RLock()
+	txx := make([]*core.Transaction, len(p.pending.txx.Data))
+	copy(txx, p.pending.txx.Data)
+	p.pending.lock.RUnlock()
+
+	sort.SliceStable(txx, func(i, j int) bool {
+		return txx[i].Nonce < txx[j].Nonce
+	})
+
+	if p.accounts == nil {
+		return txx
+	}
+
+	var (
+		executable = []*core.Transaction{}
+		next       = make(map[types.Address]uint64)
+	)
+	for _, tx := range txx {
+		from := tx.From.Address()
+		nonce, ok := next[from]
+		if !ok {
+			nonce = p.accounts.GetNonce(from)
+		}
+		if tx.Nonce != nonce {
+			next[from] = nonce
+			continue
+		}
+
+		executable = append(executable, tx)
+		next[from] = nonce + 1
+	}
+
+	return executable
+}
+
+// Remove drops a transaction from the pool, e.g. once it was included in a
+// block or turned out invalid.
+func (p *TxPool) Remove(hash types.Hash) {
+	p.all.Remove(hash)
+	p.pending.Remove(hash)
 }
 
-func (p *TxPool) ClearPending() {
-	p.pending.Clear()
+// PruneStale drops every transaction whose nonce has already been used.
+func (p *TxPool) PruneStale() {
+	if p.accounts == nil {
+		return
+	}
+
+	for _, m := range []*TxSortedMap{p.all, p.pending} {
+		m.lock.RLock()
+		stale := []types.Hash{}
+		for hash, tx := range m.lookup {
+			if tx.Nonce < p.accounts.GetNonce(tx.From.Address()) {
+				stale = append(stale, hash)
+			}
+		}
+		m.lock.RUnlock()
+
+		for _, hash := range stale {
+			m.Remove(hash)
+		}
+	}
 }
 
 func (p *TxPool) PendingCount() int {
@@ -1,13 +1,29 @@
 package network
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/k0yote/privatechain/core"
+	"github.com/k0yote/privatechain/crypto"
+	"github.com/k0yote/privatechain/types"
 	"github.com/k0yote/privatechain/util"
 	"github.com/stretchr/testify/assert"
 )
 
+type staticAccounts struct {
+	nonces   map[types.Address]uint64
+	balances map[types.Address]uint64
+}
+
+func (a staticAccounts) GetNonce(address types.Address) uint64 {
+	return a.nonces[address]
+}
+
+func (a staticAccounts) GetBalance(address types.Address) uint64 {
+	return a.balances[address]
+}
+
 func TestTxMaxLength(t *testing.T) {
 	p := NewTxPool(1)
 	p.Add(util.NewRandomTransaction(10))
@@ -105,3 +121,153 @@ func TestTxSortedMapRemove(t *testing.T) {
 	assert.Equal(t, m.Count(), 0)
 	assert.False(t, m.Contains(tx.Hash(core.TxHasher{})))
 }
+
+func TestTxPoolRejectStaleNonce(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+	accounts := staticAccounts{
+		nonces: map[types.Address]uint64{privKey.PublicKey().Address(): 2},
+	}
+
+	p := NewTxPool(10)
+	p.SetAccountReader(accounts)
+
+	stale := util.NewRandomTransactionWithSignature(t, privKey, 10)
+	err := p.Add(stale)
+	assert.True(t, errors.Is(err, core.ErrNonceTooLow))
+	assert.Equal(t, 0, p.PendingCount())
+
+	txx := []*core.Transaction{}
+	for _, nonce := range []uint64{4, 2, 3} {
+		tx := util.NewRandomTransaction(10)
+		tx.Nonce = nonce
+		assert.Nil(t, tx.Sign(privKey))
+		assert.Nil(t, p.Add(tx))
+		txx = append(txx, tx)
+	}
+
+	pending := p.Pending()
+	assert.Equal(t, []*core.Transaction{txx[1], txx[2], txx[0]}, pending)
+
+	accounts.nonces[privKey.PublicKey().Address()] = 4
+	p.PruneStale()
+	assert.Equal(t, []*core.Transaction{txx[0]}, p.Pending())
+	assert.Equal(t, 1, p.all.Count())
+}
+
+func TestTxPoolRejectUnpaidGas(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+	accounts := staticAccounts{
+		balances: map[types.Address]uint64{privKey.PublicKey().Address(): 10_000},
+	}
+
+	p := NewTxPool(10)
+	p.SetAccountReader(accounts)
+
+	tx := util.NewRandomTransaction(10)
+	tx.GasLimit = core.TxBaseGas
+	assert.Nil(t, tx.Sign(privKey))
+	assert.True(t, errors.Is(p.Add(tx), core.ErrIntrinsicGas))
+
+	tx = util.NewRandomTransaction(10)
+	tx.GasPrice = 10
+	assert.Nil(t, tx.Sign(privKey))
+	assert.True(t, errors.Is(p.Add(tx), core.ErrInsufficientBalance))
+
+	tx = util.NewRandomTransaction(10)
+	tx.GasPrice = 1
+	tx.Value = 10_000 - tx.GasLimit
+	assert.Nil(t, tx.Sign(privKey))
+	assert.Nil(t, p.Add(tx))
+	assert.Equal(t, 1, p.PendingCount())
+}
+
+func TestTxPoolQueuesNonceGap(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+	accounts := staticAccounts{
+		nonces: map[types.Address]uint64{},
+	}
+
+	p := NewTxPool(10)
+	p.SetAccountReader(accounts)
+
+	txx := []*core.Transaction{}
+	for _, nonce := range []uint64{1, 0} {
+		tx := util.NewRandomTransaction(10)
+		tx.Nonce = nonce
+		assert.Nil(t, tx.Sign(privKey))
+		assert.Nil(t, p.Add(tx))
+		txx = append(txx, tx)
+
+		if nonce == 1 {
+			// Nonce 0 is missing, so nonce 1 is queued.
+			assert.Empty(t, p.Pending())
+			assert.True(t, p.Contains(tx.Hash(core.TxHasher{})))
+		}
+	}
+
+	// Nonce 0 closed the gap and nonce 1 is executable as well.
+	assert.Equal(t, []*core.Transaction{txx[1], txx[0]}, p.Pending())
+
+	accounts.nonces[privKey.PublicKey().Address()] = 2
+	p.PruneStale()
+	assert.Empty(t, p.Pending())
+	assert.Equal(t, 0, p.all.Count())
+}
+
+func TestTxPoolRejectQueuedCost(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+	from := privKey.PublicKey().Address()
+	accounts := staticAccounts{
+		nonces:   map[types.Address]uint64{},
+		balances: map[types.Address]uint64{from: 10_000},
+	}
+
+	p := NewTxPool(10)
+	p.SetAccountReader(accounts)
+
+	txx := []*core.Transaction{}
+	for nonce := uint64(0); nonce < 3; nonce++ {
+		tx := util.NewRandomTransaction(10)
+		tx.Nonce = nonce
+		tx.Value = 4_000 - tx.GasLimit
+		tx.GasPrice = 1
+		assert.Nil(t, tx.Sign(privKey))
+		txx = append(txx, tx)
+	}
+
+	assert.Nil(t, p.Add(txx[0]))
+	assert.Nil(t, p.Add(txx[1]))
+	// Adding a transaction twice does not count its cost twice.
+	assert.Nil(t, p.Add(txx[1]))
+	assert.True(t, errors.Is(p.Add(txx[2]), core.ErrInsufficientBalance))
+	assert.Equal(t, uint64(2), p.PendingNonce(from))
+
+	// The first one was included and the account received more funds.
+	accounts.nonces[from] = 1
+	accounts.balances[from] = 8_000
+	assert.Nil(t, p.Add(txx[2]))
+	assert.Equal(t, uint64(3), p.PendingNonce(from))
+}
+
+func TestTxPoolPendingNonce(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+	from := privKey.PublicKey().Address()
+	accounts := staticAccounts{
+		nonces: map[types.Address]uint64{from: 2},
+	}
+
+	p := NewTxPool(10)
+	p.SetAccountReader(accounts)
+	assert.Equal(t, uint64(2), p.PendingNonce(from))
+
+	for _, nonce := range []uint64{2, 4} {
+		tx := util.NewRandomTransaction(10)
+		tx.Nonce = nonce
+		assert.Nil(t, tx.Sign(privKey))
+		assert.Nil(t, p.Add(tx))
+	}
+
+	// Nonce 4 is queued behind the gap at 3.
+	assert.Equal(t, uint64(3), p.PendingNonce(from))
+	assert.Equal(t, uint64(0), p.PendingNonce(types.Address{}))
+}
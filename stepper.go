@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/k0yote/privatechain/asm"
+	"github.com/k0yote/privatechain/core"
+)
+
+// debugGas is the gas given to code run by the debug command.
+const debugGas uint64 = 1_000_000
+
+var errQuit = errors.New("quit")
+
+// stepper is a core.Tracer that prints every instruction and waits for a
+// command before it is executed.
+type stepper struct {
+	lines   map[int]asm.Line
+	in      *bufio.Scanner
+	out     io.Writer
+	running bool
+}
+
+func (s *stepper) OnStep(step core.TraceStep) error {
+	text := step.Instr.String()
+	if line, ok := s.lines[step.IP]; ok && step.Depth == 0 {
+		text = line.String()
+	}
+
+	stack := make([]string, len(step.Stack))
+	for i, v := range step.Stack {
+		stack[i] = fmt.Sprint(v)
+	}
+	fmt.Fprintf(s.out, "%-32s gas=%d stack=[%s]\n", text, step.Gas, strings.Join(stack, " "))
+
+	for !s.running {
+		fmt.Fprint(s.out, "> ")
+		if !s.in.Scan() {
+			s.running = true
+			break
+		}
+
+		switch strings.TrimSpace(s.in.Text()) {
+		case "", "s":
+			return nil
+		case "c":
+			s.running = true
+		case "q":
+			return errQuit
+		default:
+			fmt.Fprintln(s.out, "commands: s (or enter) step, c continue, q quit")
+		}
+	}
+
+	return nil
+}
+
+func (s *stepper) OnStorage(access core.StorageAccess) {
+	op := "load"
+	if access.Write {
+		op = "store"
+	}
+	fmt.Fprintf(s.out, "  %s 0x%x = 0x%x\n", op, access.Key, access.Value)
+}
+
+// debugCode assembles source and runs it one instruction at a time with
+// commands read from in.
+func debugCode(source string, in io.Reader, out io.Writer) error {
+	code, err := asm.Assemble(source)
+	if err != nil {
+		return err
+	}
+
+	_, lines, err := asm.Decode(code)
+	if err != nil {
+		return err
+	}
+
+	s := &stepper{
+		lines: make(map[int]asm.Line, len(lines)),
+		in:    bufio.NewScanner(in),
+		out:   out,
+	}
+	for _, line := range lines {
+		s.lines[line.Address] = line
+	}
+
+	vm := core.NewVM(code, core.NewState(), debugGas, core.VMContext{Tracer: s})
+	if err := vm.Run(); err != nil {
+		if errors.Is(err, errQuit) {
+			return nil
+		}
+		fmt.Fprintf(out, "error: %s\n", err)
+		return nil
+	}
+
+	_, err = fmt.Fprintf(out, "result: %v\ngas used: %d\n", vm.Result(), vm.GasUsed())
+
+	return err
+}
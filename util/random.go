@@ -22,8 +22,12 @@ func RandomHash() types.Hash {
 }
 
 // NewRandomTransaction return a new random transaction whithout signature.
+// Its gas limit covers exactly the intrinsic gas.
 func NewRandomTransaction(size int) *core.Transaction {
-	return core.NewTransaction(RandomBytes(size))
+	tx := core.NewTransaction(RandomBytes(size))
+	tx.GasLimit, _ = core.IntrinsicGas(tx)
+
+	return tx
 }
 
 func NewRandomTransactionWithSignature(t *testing.T, privKey crypto.PrivateKey, size int) *core.Transaction {